@@ -0,0 +1,287 @@
+package wasp
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+/* Abort rules checked continuously over a sliding window of calls */
+
+const (
+	// DefaultAbortWindowBuckets is the amount of buckets a sliding window is split into
+	DefaultAbortWindowBuckets = 10
+	// DefaultAbortCheckInterval is the max interval between abort rules checks
+	DefaultAbortCheckInterval = 1 * time.Second
+	// DefaultAbortMinCalls is the default amount of calls in a window before a rule is checked
+	DefaultAbortMinCalls = 10
+)
+
+var (
+	ErrInvalidAbortRule = errors.New("invalid abort rule")
+)
+
+// AbortMetric is a metric an abort rule is checked against
+type AbortMetric string
+
+const (
+	// AbortErrorRatio checks a ratio of failed calls, timeouts are not included
+	AbortErrorRatio AbortMetric = "error_ratio"
+	// AbortTimeoutRatio checks a ratio of timed out calls
+	AbortTimeoutRatio AbortMetric = "timeout_ratio"
+	// AbortLatency checks a latency percentile
+	AbortLatency AbortMetric = "latency"
+)
+
+// AbortRule stops the generator, or the whole profile, when a metric over the last Window is breached,
+// use AbortOnErrorRatio, AbortOnTimeoutRatio or AbortOnLatency to create one
+type AbortRule struct {
+	Metric AbortMetric
+	// Window is a sliding window duration
+	Window time.Duration
+	// MaxRatio is an exclusive upper bound for error and timeout ratio rules, 0-1
+	MaxRatio float64
+	// Percentile for latency rules, (0, 100]
+	Percentile float64
+	// MaxLatency is an exclusive upper bound for latency rules
+	MaxLatency time.Duration
+	// MinCalls is the amount of calls in the window required to check the rule, default is DefaultAbortMinCalls
+	MinCalls int64
+	// StopProfile stops all the generators of a Profile instead of this generator only
+	StopProfile bool
+}
+
+// AbortOnErrorRatio aborts when the ratio of failed calls over window is higher than max, 0.05 is 5%
+func AbortOnErrorRatio(max float64, window time.Duration) *AbortRule {
+	return &AbortRule{Metric: AbortErrorRatio, MaxRatio: max, Window: window}
+}
+
+// AbortOnTimeoutRatio aborts when the ratio of timed out calls over window is higher than max, 0.05 is 5%
+func AbortOnTimeoutRatio(max float64, window time.Duration) *AbortRule {
+	return &AbortRule{Metric: AbortTimeoutRatio, MaxRatio: max, Window: window}
+}
+
+// AbortOnLatency aborts when the latency percentile over window is higher than max
+func AbortOnLatency(percentile float64, max time.Duration, window time.Duration) *AbortRule {
+	return &AbortRule{Metric: AbortLatency, Percentile: percentile, MaxLatency: max, Window: window}
+}
+
+func (m *AbortRule) Validate() error {
+	if m.Window <= 0 {
+		return fmt.Errorf("%w: %s, window must be > 0", ErrInvalidAbortRule, m)
+	}
+	if m.MinCalls < 0 {
+		return fmt.Errorf("%w: %s, min calls must be >= 0", ErrInvalidAbortRule, m)
+	}
+	if m.MinCalls == 0 {
+		m.MinCalls = DefaultAbortMinCalls
+	}
+	switch m.Metric {
+	case AbortErrorRatio, AbortTimeoutRatio:
+		if m.MaxRatio < 0 || m.MaxRatio >= 1 {
+			return fmt.Errorf("%w: %s, max ratio must be >= 0 and < 1", ErrInvalidAbortRule, m)
+		}
+	case AbortLatency:
+		if m.Percentile <= 0 || m.Percentile > 100 {
+			return fmt.Errorf("%w: %s, %s", ErrInvalidAbortRule, m, ErrInvalidPercentile)
+		}
+		if m.MaxLatency <= 0 {
+			return fmt.Errorf("%w: %s, max latency must be > 0", ErrInvalidAbortRule, m)
+		}
+	default:
+		return fmt.Errorf("%w: unknown metric %q", ErrInvalidAbortRule, m.Metric)
+	}
+	return nil
+}
+
+// String describes the rule, ex.: error ratio > 5% over 30s
+func (m *AbortRule) String() string {
+	switch m.Metric {
+	case AbortErrorRatio:
+		return fmt.Sprintf("error ratio > %g%% over %s", m.MaxRatio*100, m.Window)
+	case AbortTimeoutRatio:
+		return fmt.Sprintf("timeout ratio > %g%% over %s", m.MaxRatio*100, m.Window)
+	case AbortLatency:
+		return fmt.Sprintf("%s > %s over %s", PercentileKey(m.Percentile), m.MaxLatency, m.Window)
+	default:
+		return string(m.Metric)
+	}
+}
+
+// windowBucket holds calls of one part of a sliding window, a bucket is replaced by a new one when its part expires
+type windowBucket struct {
+	id       int64
+	calls    atomic.Int64
+	failed   atomic.Int64
+	timeouts atomic.Int64
+	latency  *LatencyHistogram
+}
+
+// slidingWindow aggregates calls of the last AbortRule.Window, calls are recorded without a lock
+type slidingWindow struct {
+	rule      *AbortRule
+	bucketDur time.Duration
+	buckets   []atomic.Pointer[windowBucket]
+	// merged is reset and reused by every Check of a latency rule, checks are done by one goroutine
+	merged *hdrhistogram.Histogram
+}
+
+func newSlidingWindow(rule *AbortRule) *slidingWindow {
+	m := &slidingWindow{
+		rule:      rule,
+		bucketDur: max(rule.Window/DefaultAbortWindowBuckets, 1),
+		buckets:   make([]atomic.Pointer[windowBucket], DefaultAbortWindowBuckets),
+	}
+	if rule.Metric == AbortLatency {
+		m.merged = hdrhistogram.New(1, DefaultHistogramMaxLatency.Microseconds(), DefaultHistogramSignificantFigures)
+	}
+	return m
+}
+
+// bucket returns the bucket for a moment, replacing it if it belongs to an expired part of the window
+func (m *slidingWindow) bucket(now time.Time) *windowBucket {
+	id := now.UnixNano() / int64(m.bucketDur)
+	slot := &m.buckets[id%int64(len(m.buckets))]
+	for {
+		b := slot.Load()
+		if b != nil && b.id >= id {
+			return b
+		}
+		nb := &windowBucket{id: id}
+		if m.rule.Metric == AbortLatency {
+			nb.latency = NewLatencyHistogram()
+		}
+		if slot.CompareAndSwap(b, nb) {
+			return nb
+		}
+	}
+}
+
+// Record records a call
+func (m *slidingWindow) Record(res *Response, now time.Time) {
+	b := m.bucket(now)
+	b.calls.Add(1)
+	switch {
+	case res.Timeout:
+		b.timeouts.Add(1)
+	case res.Failed:
+		b.failed.Add(1)
+	}
+	if b.latency != nil {
+		b.latency.Record(res.Duration)
+	}
+}
+
+// Check returns true and the actual value if the rule is breached
+func (m *slidingWindow) Check(now time.Time) (bool, string) {
+	current := now.UnixNano() / int64(m.bucketDur)
+	var calls, failed, timeouts int64
+	if m.merged != nil {
+		m.merged.Reset()
+	}
+	for i := range m.buckets {
+		b := m.buckets[i].Load()
+		if b == nil || b.id <= current-int64(len(m.buckets)) || b.id > current {
+			continue
+		}
+		calls += b.calls.Load()
+		failed += b.failed.Load()
+		timeouts += b.timeouts.Load()
+		if m.merged != nil {
+			b.latency.mergeTo(m.merged)
+		}
+	}
+	if calls < m.rule.MinCalls {
+		return false, ""
+	}
+	switch m.rule.Metric {
+	case AbortErrorRatio:
+		v := float64(failed) / float64(calls)
+		return v > m.rule.MaxRatio, fmt.Sprintf("%.2f%% of %d calls", v*100, calls)
+	case AbortTimeoutRatio:
+		v := float64(timeouts) / float64(calls)
+		return v > m.rule.MaxRatio, fmt.Sprintf("%.2f%% of %d calls", v*100, calls)
+	default:
+		v := time.Duration(m.merged.ValueAtPercentile(m.rule.Percentile)) * time.Microsecond
+		return v > m.rule.MaxLatency, fmt.Sprintf("%s of %d calls", v, calls)
+	}
+}
+
+// recordAbortWindows records a call in all the abort rules windows
+func (g *Generator) recordAbortWindows(res *Response) {
+	if len(g.abortWindows) == 0 {
+		return
+	}
+	now := time.Now()
+	for _, w := range g.abortWindows {
+		w.Record(res, now)
+	}
+}
+
+// runAbortRules checks abort rules until the generator stops
+func (g *Generator) runAbortRules() {
+	if len(g.abortWindows) == 0 {
+		return
+	}
+	interval := DefaultAbortCheckInterval
+	for _, w := range g.abortWindows {
+		interval = min(interval, w.bucketDur)
+	}
+	g.ResponsesWaitGroup.Add(1)
+	go func() {
+		defer g.ResponsesWaitGroup.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-g.ResponsesCtx.Done():
+				return
+			case now := <-ticker.C:
+				for _, w := range g.abortWindows {
+					if breached, actual := w.Check(now); breached {
+						g.abort(w.rule, fmt.Sprintf("%s: %s", w.rule, actual))
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// abort records the reason and stops the generator or the whole profile
+func (g *Generator) abort(rule *AbortRule, reason string) {
+	g.setAbortReason(reason)
+	g.Log.Warn().Str("Reason", reason).Bool("StopProfile", rule.StopProfile).Msg("Abort rule was breached")
+	// Stop waits for all the generator goroutines, including the caller
+	go func() {
+		g.pushStatsToSinks()
+		if rule.StopProfile && g.onProfileAbort != nil {
+			g.onProfileAbort(fmt.Sprintf("%s: %s", g.Cfg.GenName, reason))
+			return
+		}
+		g.Stop()
+	}()
+}
+
+// setAbortReason records why the run was aborted, the first reason is kept
+func (g *Generator) setAbortReason(reason string) {
+	if g.stats.abortReason.CompareAndSwap(nil, &reason) {
+		g.stats.Aborted.Store(true)
+	}
+}
+
+// AbortReason returns why the run was aborted, empty if it was not
+func (g *Generator) AbortReason() string {
+	return g.stats.AbortReason()
+}
+
+// AbortReason returns why the run was aborted, empty if it was not
+func (m *Stats) AbortReason() string {
+	if r := m.abortReason.Load(); r != nil {
+		return *r
+	}
+	return ""
+}
@@ -0,0 +1,136 @@
+package wasp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSmokeAbortRules(t *testing.T) {
+	t.Parallel()
+	t.Run("error ratio stops the generator", func(t *testing.T) {
+		t.Parallel()
+		gen, err := NewGenerator(&Config{
+			T:          t,
+			LoadType:   RPS,
+			Schedule:   Plain(50, 10*time.Second),
+			AbortRules: []*AbortRule{AbortOnErrorRatio(0.05, 500*time.Millisecond)},
+			Gun: NewMockGun(&MockGunConfig{
+				FailRatio: 50,
+				CallSleep: 10 * time.Millisecond,
+			}),
+		})
+		require.NoError(t, err)
+		start := time.Now()
+		_, failed := gen.Run(true)
+		require.Equal(t, true, failed)
+		require.Less(t, time.Since(start), 3*time.Second)
+		require.Contains(t, gen.AbortReason(), "error ratio > 5% over 500ms: ")
+		stats := gen.StatsJSON()
+		require.Equal(t, true, stats["aborted"])
+		require.Equal(t, gen.AbortReason(), stats["abort_reason"])
+	})
+	t.Run("latency stops the whole profile", func(t *testing.T) {
+		t.Parallel()
+		rule := AbortOnLatency(95, 20*time.Millisecond, 500*time.Millisecond)
+		rule.StopProfile = true
+		p := NewProfile()
+		p.Add(NewGenerator(&Config{
+			T:          t,
+			GenName:    "slow",
+			LoadType:   RPS,
+			Schedule:   Plain(20, 10*time.Second),
+			AbortRules: []*AbortRule{rule},
+			Gun:        NewMockGun(&MockGunConfig{CallSleep: 50 * time.Millisecond}),
+		}))
+		p.Add(NewGenerator(&Config{
+			T:        t,
+			GenName:  "fast",
+			LoadType: RPS,
+			Schedule: Plain(20, 10*time.Second),
+			Gun:      NewMockGun(&MockGunConfig{CallSleep: 1 * time.Millisecond}),
+		}))
+		start := time.Now()
+		_, err := p.Run(true)
+		require.NoError(t, err)
+		require.Less(t, time.Since(start), 3*time.Second)
+		require.Contains(t, p.Generators[0].AbortReason(), "p95 > 20ms over 500ms: ")
+		require.Contains(t, p.Generators[1].AbortReason(), "slow: p95 > 20ms over 500ms: ")
+	})
+	t.Run("rule is not breached", func(t *testing.T) {
+		t.Parallel()
+		gen, err := NewGenerator(&Config{
+			T:        t,
+			LoadType: RPS,
+			Schedule: Plain(20, 1*time.Second),
+			AbortRules: []*AbortRule{
+				AbortOnErrorRatio(0.05, 200*time.Millisecond),
+				AbortOnTimeoutRatio(0.05, 200*time.Millisecond),
+			},
+			Gun: NewMockGun(&MockGunConfig{CallSleep: 10 * time.Millisecond}),
+		})
+		require.NoError(t, err)
+		_, failed := gen.Run(true)
+		require.Equal(t, false, failed)
+		require.Equal(t, "", gen.AbortReason())
+		require.Equal(t, false, gen.StatsJSON()["aborted"])
+	})
+	t.Run("window expires old calls", func(t *testing.T) {
+		t.Parallel()
+		w := newSlidingWindow(&AbortRule{Metric: AbortErrorRatio, MaxRatio: 0.5, Window: 1 * time.Second, MinCalls: 1})
+		now := time.Now()
+		for i := 0; i < 10; i++ {
+			w.Record(&Response{Failed: true}, now)
+		}
+		breached, actual := w.Check(now)
+		require.True(t, breached)
+		require.Equal(t, "100.00% of 10 calls", actual)
+		later := now.Add(1 * time.Second)
+		w.Record(&Response{}, later)
+		breached, actual = w.Check(later)
+		require.False(t, breached)
+		require.Equal(t, "0.00% of 1 calls", actual)
+	})
+	t.Run("latency window is merged without allocating a histogram", func(t *testing.T) {
+		t.Parallel()
+		w := newSlidingWindow(&AbortRule{Metric: AbortLatency, Percentile: 50, MaxLatency: 50 * time.Millisecond, Window: 1 * time.Second, MinCalls: 1})
+		now := time.Now()
+		for i := 0; i < 10; i++ {
+			w.Record(&Response{Duration: 100 * time.Millisecond}, now)
+		}
+		breached, actual := w.Check(now)
+		require.True(t, breached)
+		require.Contains(t, actual, "of 10 calls")
+		allocs := testing.AllocsPerRun(100, func() {
+			breached, _ = w.Check(now)
+		})
+		require.True(t, breached)
+		require.Less(t, allocs, float64(6))
+		later := now.Add(1 * time.Second)
+		w.Record(&Response{Duration: 10 * time.Millisecond}, later)
+		breached, actual = w.Check(later)
+		require.False(t, breached)
+		require.Contains(t, actual, "of 1 calls")
+	})
+	t.Run("validation", func(t *testing.T) {
+		t.Parallel()
+		for _, r := range []*AbortRule{
+			AbortOnErrorRatio(0.1, 0),
+			AbortOnErrorRatio(1, 1*time.Second),
+			AbortOnLatency(0, 1*time.Second, 1*time.Second),
+			AbortOnLatency(99, 0, 1*time.Second),
+			{Metric: "apdex", Window: 1 * time.Second},
+		} {
+			require.ErrorIs(t, r.Validate(), ErrInvalidAbortRule)
+		}
+		_, err := NewGenerator(&Config{
+			T:          t,
+			LoadType:   RPS,
+			Schedule:   Plain(1, 1*time.Second),
+			Gun:        NewMockGun(&MockGunConfig{}),
+			AbortRules: []*AbortRule{AbortOnTimeoutRatio(-1, 1*time.Second)},
+		})
+		require.ErrorIs(t, err, ErrInvalidAbortRule)
+	})
+}
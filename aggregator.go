@@ -0,0 +1,193 @@
+package wasp
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/* Sharded aggregation of call results */
+
+// cacheLinePad separates shards, so concurrent writers don't share a cache line
+type cacheLinePad [64]byte
+
+// shardCount returns the amount of shards of concurrent aggregators, as many as goroutines that can run at once
+func shardCount() int {
+	return max(1, runtime.GOMAXPROCS(0))
+}
+
+// shardHint is a shard number kept in a sync.Pool, the pool keeps objects per P,
+// so goroutines running on the same P mostly get the same hint and writers on different Ps rarely share a shard
+type shardHint struct {
+	n int
+}
+
+var (
+	shardHintSeq atomic.Int64
+	shardHints   = sync.Pool{New: func() any { return &shardHint{n: int(shardHintSeq.Add(1))} }}
+)
+
+// shardIndex picks a shard of the current P
+func shardIndex(n int) int {
+	if n == 1 {
+		return 0
+	}
+	h := shardHints.Get().(*shardHint)
+	i := h.n % n
+	shardHints.Put(h)
+	return i
+}
+
+// seqResponse is a recorded response with the time it was recorded at, since the aggregator was created
+type seqResponse struct {
+	at  time.Duration
+	res *Response
+}
+
+// resultShard buffers a part of recorded responses, every shard has its own lock
+type resultShard struct {
+	mu      sync.Mutex
+	count   uint64
+	pending []seqResponse
+	_       cacheLinePad
+}
+
+// resultAggregator stores recorded responses in shards picked per P, so concurrent calls rarely wait for each other.
+// A shard buffers up to capacity/n responses and moves them to the shared rings of the latest capacity ok and failed responses in one batch,
+// so the aggregator keeps at most capacity ok, capacity failed and capacity buffered responses.
+// Shards and rings are merged in the recording order only when the data is read, merged responses are cached until the next write
+type resultAggregator struct {
+	start    time.Time
+	capacity int
+	batch    int
+	shards   []resultShard
+	ringsMu  sync.Mutex
+	okRing   ring[seqResponse]
+	failRing ring[seqResponse]
+	mu       sync.Mutex
+	version  uint64
+	merged   bool
+	ok       []*Response
+	fail     []*Response
+	errs     []string
+}
+
+// newResultAggregator creates an aggregator with n shards that keeps up to capacity ok and failed responses
+func newResultAggregator(capacity int, n int) *resultAggregator {
+	n = max(1, n)
+	return &resultAggregator{
+		start:    time.Now(),
+		capacity: capacity,
+		batch:    max(1, (capacity+n-1)/n),
+		shards:   make([]resultShard, n),
+		okRing:   ring[seqResponse]{capacity: capacity},
+		failRing: ring[seqResponse]{capacity: capacity},
+	}
+}
+
+// record buffers a response in the shard of the current P, a full buffer is moved to the rings
+func (m *resultAggregator) record(res *Response) {
+	s := &m.shards[shardIndex(len(m.shards))]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the time is taken under the shard lock, so every shard is in the recording order
+	s.pending = append(s.pending, seqResponse{at: time.Since(m.start), res: res})
+	s.count++
+	if len(s.pending) < m.batch {
+		return
+	}
+	m.ringsMu.Lock()
+	m.flush(s)
+	m.ringsMu.Unlock()
+}
+
+// flush moves buffered responses of a shard to the rings, must be called under the shard lock and m.ringsMu
+func (m *resultAggregator) flush(s *resultShard) {
+	for _, r := range s.pending {
+		if r.res.Failed || r.res.Timeout {
+			m.failRing.append(r)
+		} else {
+			m.okRing.append(r)
+		}
+	}
+	s.pending = s.pending[:0]
+}
+
+// stored returns the amount of responses stored by all the shards
+func (m *resultAggregator) stored() uint64 {
+	var total uint64
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.Lock()
+		total += s.count
+		s.mu.Unlock()
+	}
+	return total
+}
+
+// merge merges shards if something was recorded after the last merge, must be called under m.mu
+func (m *resultAggregator) merge() {
+	if m.merged && m.stored() == m.version {
+		return
+	}
+	// shards are locked before the rings, like in record
+	var version uint64
+	for i := range m.shards {
+		m.shards[i].mu.Lock()
+	}
+	m.ringsMu.Lock()
+	for i := range m.shards {
+		s := &m.shards[i]
+		version += s.count
+		m.flush(s)
+	}
+	ok := m.okRing.snapshot()
+	fail := m.failRing.snapshot()
+	m.ringsMu.Unlock()
+	for i := range m.shards {
+		m.shards[i].mu.Unlock()
+	}
+	m.ok = make([]*Response, 0, len(ok))
+	for _, r := range m.inOrder(ok) {
+		m.ok = append(m.ok, r.res)
+	}
+	m.fail = make([]*Response, 0, len(fail))
+	m.errs = make([]string, 0, len(fail))
+	for _, r := range m.inOrder(fail) {
+		m.fail = append(m.fail, r.res)
+		m.errs = append(m.errs, r.res.Error)
+	}
+	m.merged, m.version = true, version
+}
+
+// inOrder sorts responses in the recording order, batches of different shards can reach the rings out of order
+func (m *resultAggregator) inOrder(rs []seqResponse) []seqResponse {
+	sort.SliceStable(rs, func(i, j int) bool { return rs[i].at < rs[j].at })
+	return rs
+}
+
+// responseData returns merged responses, every call returns new buffers
+func (m *resultAggregator) responseData() *ResponseData {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.merge()
+	okData := make([]any, 0, len(m.ok))
+	for _, r := range m.ok {
+		okData = append(okData, r.Data)
+	}
+	return &ResponseData{
+		okData:        newSliceBufferOf(m.capacity, okData),
+		okResponses:   newSliceBufferOf(m.capacity, m.ok),
+		failResponses: newSliceBufferOf(m.capacity, m.fail),
+	}
+}
+
+// errors returns merged errors of failed and timed out responses
+func (m *resultAggregator) errors() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.merge()
+	return m.errs
+}
@@ -0,0 +1,66 @@
+package wasp
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSmokeResultAggregator(t *testing.T) {
+	t.Parallel()
+	t.Run("shards are merged in the recording order", func(t *testing.T) {
+		t.Parallel()
+		m := newResultAggregator(1000, 4)
+		for i := 0; i < 100; i++ {
+			m.record(&Response{Data: i})
+			if i%10 == 0 {
+				m.record(&Response{Failed: true, Error: strconv.Itoa(i)})
+			}
+		}
+		data := m.responseData()
+		require.Equal(t, 100, data.OKData().Len())
+		okResponses := data.OKResponses().Snapshot()
+		for i, d := range data.OKData().Snapshot() {
+			require.Equal(t, i, d)
+			require.Equal(t, i, okResponses[i].Data)
+		}
+		require.Equal(t, 10, data.FailResponses().Len())
+		require.Equal(t, []string{"0", "10", "20", "30", "40", "50", "60", "70", "80", "90"}, m.errors())
+		m.record(&Response{Data: 100})
+		require.Equal(t, 101, m.responseData().OKData().Len())
+	})
+	t.Run("only the latest responses are kept", func(t *testing.T) {
+		t.Parallel()
+		m := newResultAggregator(10, 4)
+		var wg sync.WaitGroup
+		for w := 0; w < 8; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 1000; i++ {
+					m.record(&Response{Data: i})
+				}
+			}()
+		}
+		wg.Wait()
+		require.LessOrEqual(t, len(m.okRing.items), 10)
+		for i := range m.shards {
+			require.Less(t, len(m.shards[i].pending), m.batch)
+		}
+		data := m.responseData()
+		require.Equal(t, 10, data.OKResponses().Len())
+		require.Empty(t, m.errors())
+	})
+	t.Run("the latest responses are kept when ok and failed responses are mixed", func(t *testing.T) {
+		t.Parallel()
+		for i := 0; i < 100; i++ {
+			m := newResultAggregator(2, 2)
+			for _, res := range []*Response{{Data: 1}, {Data: 2}, {Data: 3}, {Data: 4, Failed: true}, {Data: 5}} {
+				m.record(res)
+			}
+			require.Equal(t, []any{3, 5}, m.responseData().OKData().Snapshot())
+		}
+	})
+}
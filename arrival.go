@@ -0,0 +1,202 @@
+package wasp
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go.uber.org/ratelimit"
+)
+
+/* Arrival processes used to pace RPS calls */
+
+// ArrivalProcess defines how inter-arrival gaps between RPS calls are distributed
+type ArrivalProcess string
+
+const (
+	// ArrivalConstant paces calls with even inter-arrival gaps, default
+	ArrivalConstant ArrivalProcess = "constant"
+	// ArrivalPoisson paces calls with exponentially distributed gaps (open model, Poisson process)
+	ArrivalPoisson ArrivalProcess = "poisson"
+	// ArrivalCustom paces calls with gaps returned by Config.InterArrival
+	ArrivalCustom ArrivalProcess = "custom"
+)
+
+const (
+	// DefaultArrivalSlack is the amount of mean gaps the arrival limiter can fall behind before it stops catching up,
+	// the same as go.uber.org/ratelimit slack used by ArrivalConstant
+	DefaultArrivalSlack = 10
+)
+
+// InterArrivalFunc returns the next gap between two calls, mean is the gap defined by the current rate
+type InterArrivalFunc func(mean time.Duration) time.Duration
+
+// ExponentialInterArrival returns exponentially distributed gaps, used by ArrivalPoisson
+func ExponentialInterArrival(rnd *rand.Rand) InterArrivalFunc {
+	return func(mean time.Duration) time.Duration {
+		return time.Duration(rnd.ExpFloat64() * float64(mean))
+	}
+}
+
+// intendedLimiter is a ratelimit.Limiter that knows when a released call was intended to be sent by the schedule
+type intendedLimiter interface {
+	ratelimit.Limiter
+	// TakeIntended blocks like Take and returns the intended send time of the call,
+	// it is earlier than now if the generator fell behind the schedule
+	TakeIntended() time.Time
+	// pause is called when the generator is paused
+	pause()
+	// resume moves the schedule after a pause, so the pause is not counted as send lateness
+	resume()
+}
+
+// ConstantInterArrival returns even gaps, used by ArrivalConstant
+func ConstantInterArrival(mean time.Duration) time.Duration {
+	return mean
+}
+
+// arrivalLimiter is a ratelimit.Limiter that blocks for gaps returned by InterArrivalFunc
+// it keeps the mean rate by scheduling each arrival relatively to the previous one
+type arrivalLimiter struct {
+	mu    sync.Mutex
+	mean  time.Duration
+	slack time.Duration
+	next  time.Time
+	gap   InterArrivalFunc
+}
+
+// newArrivalLimiter creates a limiter with a mean rate of "rate" calls per "per"
+func newArrivalLimiter(rate int64, per time.Duration, gap InterArrivalFunc) *arrivalLimiter {
+	mean := time.Duration(math.Max(1, float64(per)/float64(rate)))
+	return &arrivalLimiter{
+		mean:  mean,
+		slack: DefaultArrivalSlack * mean,
+		gap:   gap,
+	}
+}
+
+// Take blocks until the next arrival
+func (m *arrivalLimiter) Take() time.Time {
+	_, released := m.take()
+	return released
+}
+
+// TakeIntended blocks until the next arrival and returns its scheduled time
+func (m *arrivalLimiter) TakeIntended() time.Time {
+	intended, _ := m.take()
+	return intended
+}
+
+// take returns when the arrival was scheduled and when it was released, the first arrival is released immediately.
+// If the limiter is behind for more than slack it catches up only slack and the rest of the schedule is shifted,
+// the arrival released after the stall keeps its original scheduled time
+func (m *arrivalLimiter) take() (time.Time, time.Time) {
+	m.mu.Lock()
+	now := time.Now()
+	if m.next.IsZero() {
+		m.next = now
+		m.mu.Unlock()
+		return now, now
+	}
+	gap := m.gap(m.mean)
+	intended := m.next.Add(gap)
+	m.next = m.next.Add(gap)
+	if now.Sub(m.next) > m.slack {
+		m.next = now.Add(-m.slack)
+	}
+	next := m.next
+	m.mu.Unlock()
+	if d := next.Sub(now); d > 0 {
+		time.Sleep(d)
+		return intended, next
+	}
+	return intended, now
+}
+
+// pause does nothing, the schedule is moved on resume
+func (m *arrivalLimiter) pause() {}
+
+// resume shifts the schedule as if the limiter was slack behind, like after any other stall
+func (m *arrivalLimiter) resume() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now := time.Now(); !m.next.IsZero() && now.Sub(m.next) > m.slack {
+		m.next = now.Add(-m.slack)
+	}
+}
+
+// constantLimiter paces ArrivalConstant calls with go.uber.org/ratelimit and follows the same schedule
+// to know when a released call was intended to be sent
+type constantLimiter struct {
+	ratelimit.Limiter
+	mu    sync.Mutex
+	gap   time.Duration
+	slack time.Duration
+	next  time.Time
+}
+
+// newConstantLimiter creates a limiter with a rate of "rate" calls per "per"
+func newConstantLimiter(rate int64, per time.Duration) *constantLimiter {
+	gap := per / time.Duration(rate)
+	return &constantLimiter{
+		Limiter: ratelimit.New(int(rate), ratelimit.Per(per)),
+		gap:     gap,
+		slack:   DefaultArrivalSlack * gap,
+	}
+}
+
+// TakeIntended blocks until ratelimit releases the next call and returns its scheduled time,
+// if the schedule is behind for more than slack it's shifted the same way ratelimit stops catching up
+func (m *constantLimiter) TakeIntended() time.Time {
+	released := m.Take()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.next.IsZero() {
+		m.next = released
+		return released
+	}
+	intended := m.next.Add(m.gap)
+	m.next = intended
+	if released.Sub(m.next) > m.slack {
+		m.next = released.Add(-m.slack)
+	}
+	// ratelimit rounds its own schedule, a call is never intended after it was released
+	return minTime(intended, released)
+}
+
+// pause does nothing, the schedule is moved on resume
+func (m *constantLimiter) pause() {}
+
+// resume shifts the schedule so the first call after a pause is slack behind,
+// ratelimit releases it and catches up the same slack right away
+func (m *constantLimiter) resume() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now := time.Now(); !m.next.IsZero() && now.Sub(m.next) > m.slack {
+		m.next = now.Add(-m.slack - m.gap)
+	}
+}
+
+// minTime returns the earlier of two times
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// newRateLimiter creates a limiter for RPS schedule according to Config.Arrival or Config.Replay
+// replay limiter is shared by all the segments, they are only used to report the rate
+func (g *Generator) newRateLimiter(rate int64) intendedLimiter {
+	if g.replay != nil {
+		return g.replay
+	}
+	// nothing is sent at zero RPS, see pacedCall
+	rate = max(1, rate)
+	if g.interArrival == nil {
+		return newConstantLimiter(rate, g.Cfg.RateLimitUnitDuration)
+	}
+	// limiters are used one by one by pacedCall, they can share the Poisson random source
+	return newArrivalLimiter(rate, g.Cfg.RateLimitUnitDuration, g.interArrival)
+}
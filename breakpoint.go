@@ -0,0 +1,266 @@
+package wasp
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+/* Automated breakpoint search, finds the highest sustainable RPS or VUs */
+
+var (
+	ErrBreakpointNoConfig     = errors.New("breakpoint search generator config is nil")
+	ErrBreakpointStep         = errors.New("breakpoint search From and StepIncrease must be > 0")
+	ErrBreakpointMaxSteps     = errors.New("breakpoint search MaxSteps must be > 0")
+	ErrBreakpointStepDuration = errors.New("breakpoint search StepDuration must be defined")
+	ErrBreakpointNoSLO        = errors.New("breakpoint search requires at least one SLO threshold")
+	ErrBreakpointSampler      = errors.New("breakpoint search can't share a Sampler between steps, use SamplerConfig")
+	ErrBreakpointThresholds   = errors.New("breakpoint search steps are checked with SLO, Thresholds can't be used")
+	ErrBreakpointAddr         = errors.New("breakpoint search can't serve Prometheus or the control API, every step would bind the address again")
+)
+
+// BreakpointSLO are thresholds checked after every search step, zero values are not checked
+type BreakpointSLO struct {
+	// MaxP99 max 99th percentile of call durations
+	MaxP99 time.Duration
+	// MaxErrorRatio max ratio of failed calls, 0-1
+	MaxErrorRatio float64
+	// MaxTimeoutRatio max ratio of timed out calls, 0-1
+	MaxTimeoutRatio float64
+}
+
+// BreakpointConfig configures breakpoint search
+type BreakpointConfig struct {
+	// Config is a generator template, Schedule is replaced on every step.
+	// Sinks are kept open between steps and closed when the search ends, reports are written to ReportDir/step_<n>
+	Config *Config
+	// From is the RPS or VUs of the first step
+	From int64
+	// StepIncrease is RPS or VUs added on every step
+	StepIncrease int64
+	// MaxSteps max amount of increasing steps
+	MaxSteps int
+	// StepDuration is a duration of every step
+	StepDuration time.Duration
+	// Cooldown is a pause between steps to let the system under test recover
+	Cooldown time.Duration
+	// BisectionSteps max amount of steps narrowing the limit between the last good and the first bad step
+	BisectionSteps int
+	SLO            BreakpointSLO
+}
+
+func (m *BreakpointConfig) Validate() error {
+	if m.Config == nil {
+		return ErrBreakpointNoConfig
+	}
+	if m.From <= 0 || m.StepIncrease <= 0 {
+		return ErrBreakpointStep
+	}
+	if m.MaxSteps <= 0 {
+		return ErrBreakpointMaxSteps
+	}
+	if m.StepDuration == 0 {
+		return ErrBreakpointStepDuration
+	}
+	if m.SLO.MaxP99 == 0 && m.SLO.MaxErrorRatio == 0 && m.SLO.MaxTimeoutRatio == 0 {
+		return ErrBreakpointNoSLO
+	}
+	if m.Config.Sampler != nil {
+		return ErrBreakpointSampler
+	}
+	if len(m.Config.Thresholds) > 0 {
+		return ErrBreakpointThresholds
+	}
+	if m.Config.Prometheus != nil || m.Config.ControlServerAddr != "" {
+		return ErrBreakpointAddr
+	}
+	return nil
+}
+
+// BreakpointStep is a result of one search step
+type BreakpointStep struct {
+	Load         int64         `json:"load"`
+	Bisection    bool          `json:"bisection"`
+	Requests     int64         `json:"requests"`
+	P99          time.Duration `json:"p99"`
+	ErrorRatio   float64       `json:"error_ratio"`
+	TimeoutRatio float64       `json:"timeout_ratio"`
+	Passed       bool          `json:"passed"`
+	Violations   []string      `json:"violations,omitempty"`
+}
+
+// BreakpointReport is a result of breakpoint search
+type BreakpointReport struct {
+	// MaxSustainableLoad is the highest RPS or VUs that passed all the SLO thresholds, 0 if none passed
+	MaxSustainableLoad int64 `json:"max_sustainable_load"`
+	// FirstFailedLoad is the lowest RPS or VUs that violated SLO thresholds, 0 if none failed
+	FirstFailedLoad int64             `json:"first_failed_load"`
+	Steps           []*BreakpointStep `json:"steps"`
+}
+
+// BreakpointSearch raises RPS or VUs step by step until SLO thresholds are violated
+type BreakpointSearch struct {
+	cfg *BreakpointConfig
+	l   zerolog.Logger
+}
+
+// NewBreakpointSearch creates a new breakpoint search
+func NewBreakpointSearch(cfg *BreakpointConfig) (*BreakpointSearch, error) {
+	if cfg == nil {
+		return nil, ErrNoCfg
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &BreakpointSearch{
+		cfg: cfg,
+		l:   GetLogger(cfg.Config.T, "BreakpointSearch"),
+	}, nil
+}
+
+// stepSink is a Sink of a search step, it's closed only when the search ends
+type stepSink struct {
+	Sink
+}
+
+func (m *stepSink) Close() error {
+	return nil
+}
+
+// Run runs increasing steps until the first SLO violation, then bisects between the last good and the first bad load
+func (m *BreakpointSearch) Run() (*BreakpointReport, error) {
+	defer m.closeSinks()
+	r := &BreakpointReport{Steps: make([]*BreakpointStep, 0)}
+	var good, bad int64
+	for i := 0; i < m.cfg.MaxSteps; i++ {
+		load := m.cfg.From + int64(i)*m.cfg.StepIncrease
+		step, err := m.runStep(len(r.Steps), load, false)
+		if err != nil {
+			return r, err
+		}
+		r.Steps = append(r.Steps, step)
+		if !step.Passed {
+			bad = load
+			break
+		}
+		good = load
+	}
+	if bad == 0 {
+		r.MaxSustainableLoad = good
+		m.l.Info().Int64("MaxSustainableLoad", good).Msg("SLO thresholds were not violated, increase MaxSteps to search further")
+		return r, nil
+	}
+	for i := 0; i < m.cfg.BisectionSteps && bad-good > 1; i++ {
+		load := good + (bad-good)/2
+		step, err := m.runStep(len(r.Steps), load, true)
+		if err != nil {
+			return r, err
+		}
+		r.Steps = append(r.Steps, step)
+		if step.Passed {
+			good = load
+		} else {
+			bad = load
+		}
+	}
+	r.MaxSustainableLoad = good
+	r.FirstFailedLoad = bad
+	m.l.Info().
+		Int64("MaxSustainableLoad", good).
+		Int64("FirstFailedLoad", bad).
+		Msg("Breakpoint found")
+	return r, nil
+}
+
+// closeSinks closes the template sinks after the last step
+func (m *BreakpointSearch) closeSinks() {
+	for _, s := range m.cfg.Config.Sinks {
+		if err := s.Sink.Close(); err != nil {
+			m.l.Err(err).Str("Sink", s.Sink.Name()).Msg("Failed to close sink")
+		}
+	}
+}
+
+// stepConfig copies the template, everything that a generator changes or closes is copied too
+func (m *BreakpointSearch) stepConfig(n int, load int64) *Config {
+	cfg := *m.cfg.Config
+	cfg.Schedule = Plain(load, m.cfg.StepDuration)
+	cfg.Sinks = make([]*SinkConfig, 0, len(m.cfg.Config.Sinks))
+	for _, s := range m.cfg.Config.Sinks {
+		sc := *s
+		sc.Sink = &stepSink{Sink: s.Sink}
+		cfg.Sinks = append(cfg.Sinks, &sc)
+	}
+	cfg.AbortRules = make([]*AbortRule, 0, len(m.cfg.Config.AbortRules))
+	for _, r := range m.cfg.Config.AbortRules {
+		rc := *r
+		cfg.AbortRules = append(cfg.AbortRules, &rc)
+	}
+	if m.cfg.Config.ResponseStore != nil {
+		rs := *m.cfg.Config.ResponseStore
+		cfg.ResponseStore = &rs
+	}
+	if m.cfg.Config.ReportDir != "" {
+		cfg.ReportDir = filepath.Join(m.cfg.Config.ReportDir, fmt.Sprintf("step_%d", n))
+	}
+	cfg.GenName = fmt.Sprintf("%s_breakpoint_%d", m.cfg.Config.GenName, load)
+	if m.cfg.Config.GenName == "" {
+		cfg.GenName = fmt.Sprintf("breakpoint_%d", load)
+	}
+	return &cfg
+}
+
+// runStep runs a generator with a constant load and checks SLO thresholds
+func (m *BreakpointSearch) runStep(n int, load int64, bisection bool) (*BreakpointStep, error) {
+	gen, err := NewGenerator(m.stepConfig(n, load))
+	if err != nil {
+		return nil, err
+	}
+	m.l.Info().Int64("Load", load).Bool("Bisection", bisection).Msg("Running breakpoint search step")
+	gen.Run(true)
+	step := m.checkStep(gen)
+	step.Load = load
+	step.Bisection = bisection
+	m.l.Info().
+		Int64("Load", load).
+		Int64("Requests", step.Requests).
+		Str("P99", step.P99.String()).
+		Float64("ErrorRatio", step.ErrorRatio).
+		Float64("TimeoutRatio", step.TimeoutRatio).
+		Bool("Passed", step.Passed).
+		Strs("Violations", step.Violations).
+		Msg("Breakpoint search step finished")
+	if m.cfg.Cooldown > 0 {
+		time.Sleep(m.cfg.Cooldown)
+	}
+	return step, nil
+}
+
+// checkStep calculates step results and checks them against SLO thresholds
+func (m *BreakpointSearch) checkStep(gen *Generator) *BreakpointStep {
+	stats := gen.Stats()
+	// histogram counts all the calls, successful ones can be skipped by the sampler
+	total := stats.Latencies.All.Count()
+	timeouts := stats.CallTimeout.Load()
+	step := &BreakpointStep{Requests: total, Passed: true}
+	if total > 0 {
+		step.ErrorRatio = float64(stats.Failed.Load()-timeouts) / float64(total)
+		step.TimeoutRatio = float64(timeouts) / float64(total)
+	}
+	step.P99 = stats.Latencies.All.Percentile(99)
+	slo := m.cfg.SLO
+	if slo.MaxP99 > 0 && step.P99 > slo.MaxP99 {
+		step.Violations = append(step.Violations, fmt.Sprintf("p99 %s > %s", step.P99, slo.MaxP99))
+	}
+	if slo.MaxErrorRatio > 0 && step.ErrorRatio > slo.MaxErrorRatio {
+		step.Violations = append(step.Violations, fmt.Sprintf("error ratio %.4f > %.4f", step.ErrorRatio, slo.MaxErrorRatio))
+	}
+	if slo.MaxTimeoutRatio > 0 && step.TimeoutRatio > slo.MaxTimeoutRatio {
+		step.Violations = append(step.Violations, fmt.Sprintf("timeout ratio %.4f > %.4f", step.TimeoutRatio, slo.MaxTimeoutRatio))
+	}
+	step.Passed = len(step.Violations) == 0
+	return step
+}
@@ -0,0 +1,142 @@
+package wasp
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// capacityGun fails all the calls when generator RPS is above capacity
+type capacityGun struct {
+	capacity int64
+}
+
+func (m *capacityGun) Call(l *Generator) *Response {
+	time.Sleep(5 * time.Millisecond)
+	if l.Stats().CurrentRPS.Load() > m.capacity {
+		return &Response{Data: "failedCallData", Error: "over capacity", Failed: true}
+	}
+	return &Response{Data: "successCallData"}
+}
+
+func TestSmokeBreakpointSearch(t *testing.T) {
+	t.Parallel()
+	t.Run("finds the limit with bisection", func(t *testing.T) {
+		t.Parallel()
+		s, err := NewBreakpointSearch(&BreakpointConfig{
+			Config: &Config{
+				T:        t,
+				LoadType: RPS,
+				Gun:      &capacityGun{capacity: 25},
+			},
+			From:           10,
+			StepIncrease:   10,
+			MaxSteps:       5,
+			StepDuration:   1 * time.Second,
+			BisectionSteps: 3,
+			SLO: BreakpointSLO{
+				MaxErrorRatio: 0.05,
+			},
+		})
+		require.NoError(t, err)
+		r, err := s.Run()
+		require.NoError(t, err)
+		require.Equal(t, int64(25), r.MaxSustainableLoad)
+		require.Equal(t, int64(26), r.FirstFailedLoad)
+		loads := make([]int64, 0)
+		for _, step := range r.Steps {
+			loads = append(loads, step.Load)
+		}
+		require.Equal(t, []int64{10, 20, 30, 25, 27, 26}, loads)
+		require.False(t, r.Steps[2].Passed)
+		require.NotEmpty(t, r.Steps[2].Violations)
+		require.True(t, r.Steps[3].Bisection)
+	})
+	t.Run("reports the last step when SLO is never violated", func(t *testing.T) {
+		t.Parallel()
+		s, err := NewBreakpointSearch(&BreakpointConfig{
+			Config: &Config{
+				T:        t,
+				LoadType: RPS,
+				Gun:      &capacityGun{capacity: 100},
+			},
+			From:         10,
+			StepIncrease: 10,
+			MaxSteps:     2,
+			StepDuration: 1 * time.Second,
+			SLO: BreakpointSLO{
+				MaxP99:        1 * time.Second,
+				MaxErrorRatio: 0.05,
+			},
+		})
+		require.NoError(t, err)
+		r, err := s.Run()
+		require.NoError(t, err)
+		require.Equal(t, int64(20), r.MaxSustainableLoad)
+		require.Equal(t, int64(0), r.FirstFailedLoad)
+		require.Len(t, r.Steps, 2)
+		require.Greater(t, r.Steps[1].P99, time.Duration(0))
+	})
+	t.Run("sinks and reports are kept apart between steps", func(t *testing.T) {
+		t.Parallel()
+		sink := newMemSink()
+		dir := t.TempDir()
+		s, err := NewBreakpointSearch(&BreakpointConfig{
+			Config: &Config{
+				T:                 t,
+				GenName:           "steps",
+				LoadType:          RPS,
+				StatsPollInterval: 100 * time.Millisecond,
+				Sinks:             []*SinkConfig{{Sink: sink}},
+				ReportDir:         dir,
+				Gun:               &capacityGun{capacity: 100},
+			},
+			From:         10,
+			StepIncrease: 10,
+			MaxSteps:     3,
+			StepDuration: 1 * time.Second,
+			SLO:          BreakpointSLO{MaxErrorRatio: 0.05},
+		})
+		require.NoError(t, err)
+		r, err := s.Run()
+		require.NoError(t, err)
+		require.Len(t, r.Steps, 3)
+		var requests int64
+		for _, step := range r.Steps {
+			requests += step.Requests
+		}
+		sink.mu.Lock()
+		require.True(t, sink.closed)
+		require.Zero(t, sink.afterClose)
+		require.Equal(t, requests, int64(len(sink.responses)))
+		sink.mu.Unlock()
+		for i := range r.Steps {
+			reports, err := filepath.Glob(filepath.Join(dir, fmt.Sprintf("step_%d", i), "*.json"))
+			require.NoError(t, err)
+			require.Len(t, reports, 1)
+		}
+	})
+	t.Run("validation", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewBreakpointSearch(&BreakpointConfig{From: 1, StepIncrease: 1, MaxSteps: 1, StepDuration: 1 * time.Second})
+		require.Equal(t, ErrBreakpointNoConfig, err)
+		_, err = NewBreakpointSearch(&BreakpointConfig{Config: &Config{}, StepIncrease: 1, MaxSteps: 1, StepDuration: 1 * time.Second})
+		require.Equal(t, ErrBreakpointStep, err)
+		_, err = NewBreakpointSearch(&BreakpointConfig{Config: &Config{}, From: 1, StepIncrease: 1, StepDuration: 1 * time.Second})
+		require.Equal(t, ErrBreakpointMaxSteps, err)
+		_, err = NewBreakpointSearch(&BreakpointConfig{Config: &Config{}, From: 1, StepIncrease: 1, MaxSteps: 1})
+		require.Equal(t, ErrBreakpointStepDuration, err)
+		_, err = NewBreakpointSearch(&BreakpointConfig{Config: &Config{}, From: 1, StepIncrease: 1, MaxSteps: 1, StepDuration: 1 * time.Second})
+		require.Equal(t, ErrBreakpointNoSLO, err)
+		slo := BreakpointSLO{MaxErrorRatio: 0.05}
+		_, err = NewBreakpointSearch(&BreakpointConfig{Config: &Config{Sampler: NewSampler(nil)}, From: 1, StepIncrease: 1, MaxSteps: 1, StepDuration: 1 * time.Second, SLO: slo})
+		require.Equal(t, ErrBreakpointSampler, err)
+		_, err = NewBreakpointSearch(&BreakpointConfig{Config: &Config{Thresholds: []*Threshold{ErrorRateThreshold(0.1)}}, From: 1, StepIncrease: 1, MaxSteps: 1, StepDuration: 1 * time.Second, SLO: slo})
+		require.Equal(t, ErrBreakpointThresholds, err)
+		_, err = NewBreakpointSearch(&BreakpointConfig{Config: &Config{Prometheus: &PrometheusConfig{Addr: ":0"}}, From: 1, StepIncrease: 1, MaxSteps: 1, StepDuration: 1 * time.Second, SLO: slo})
+		require.Equal(t, ErrBreakpointAddr, err)
+	})
+}
@@ -1,26 +1,117 @@
 package wasp
 
-// SliceBuffer keeps Capacity of type T, after len => cap overrides old data
+import "sync"
+
+// ring keeps up to capacity items, the oldest item is overwritten when it's full, it is not safe for concurrent use
+type ring[T any] struct {
+	capacity int
+	items    []T
+	start    int
+}
+
+// append appends an item, overwrites the oldest one if the ring is full
+func (m *ring[T]) append(v T) {
+	if m.capacity <= 0 {
+		return
+	}
+	if len(m.items) < m.capacity {
+		m.items = append(m.items, v)
+		return
+	}
+	m.items[m.start] = v
+	m.start = (m.start + 1) % m.capacity
+}
+
+// last returns a copy of the newest n items from the oldest to the newest
+func (m *ring[T]) last(n int) []T {
+	n = max(0, min(n, len(m.items)))
+	res := make([]T, n)
+	offset := m.start + len(m.items) - n
+	for i := range res {
+		res[i] = m.items[(offset+i)%len(m.items)]
+	}
+	return res
+}
+
+// snapshot returns a copy of all the items from the oldest to the newest
+func (m *ring[T]) snapshot() []T {
+	return m.last(len(m.items))
+}
+
+// reset removes all the items
+func (m *ring[T]) reset() {
+	m.items = nil
+	m.start = 0
+}
+
+// SliceBuffer is a ring buffer that keeps up to Capacity() of type T, after that the oldest items are overridden.
+// It is safe for concurrent use, all the items are returned from the oldest to the newest
 type SliceBuffer[T any] struct {
-	Idx      int
-	Capacity int
-	Data     []T
+	mu sync.Mutex
+	r  ring[T]
 }
 
-// NewSliceBuffer creates new limited capacity slice
-func NewSliceBuffer[T any](cap int) *SliceBuffer[T] {
-	return &SliceBuffer[T]{Capacity: cap, Data: make([]T, 0)}
+// NewSliceBuffer creates new limited capacity ring buffer, nothing is kept if capacity is <= 0
+func NewSliceBuffer[T any](capacity int) *SliceBuffer[T] {
+	return &SliceBuffer[T]{r: ring[T]{capacity: capacity}}
 }
 
-// Append appends T if len <= cap, overrides old data otherwise
-func (m *SliceBuffer[T]) Append(s T) {
-	if m.Idx >= m.Capacity {
-		m.Idx = 0
+// newSliceBufferOf creates a buffer with the newest items of a slice
+func newSliceBufferOf[T any](capacity int, items []T) *SliceBuffer[T] {
+	m := NewSliceBuffer[T](capacity)
+	for _, v := range items {
+		m.r.append(v)
 	}
-	if len(m.Data) <= m.Capacity {
-		m.Data = append(m.Data, s)
-	} else {
-		m.Data[m.Idx] = s
+	return m
+}
+
+// Append appends T, overrides the oldest item if the buffer is full
+func (m *SliceBuffer[T]) Append(v T) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.r.append(v)
+}
+
+// Len returns the amount of kept items
+func (m *SliceBuffer[T]) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.r.items)
+}
+
+// Capacity returns the maximum amount of kept items
+func (m *SliceBuffer[T]) Capacity() int {
+	return m.r.capacity
+}
+
+// Last returns a copy of the newest n items
+func (m *SliceBuffer[T]) Last(n int) []T {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.r.last(n)
+}
+
+// Snapshot returns a copy of all the items
+func (m *SliceBuffer[T]) Snapshot() []T {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.r.snapshot()
+}
+
+// Drain returns all the items and empties the buffer
+func (m *SliceBuffer[T]) Drain() []T {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := m.r.snapshot()
+	m.r.reset()
+	return res
+}
+
+// Range calls f for a snapshot of items until it returns false, f can use the buffer
+func (m *SliceBuffer[T]) Range(f func(v T) bool) {
+	for _, v := range m.Snapshot() {
+		if !f(v) {
+			return
+		}
 	}
-	m.Idx++
 }
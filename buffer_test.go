@@ -0,0 +1,59 @@
+package wasp
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSmokeSliceBuffer(t *testing.T) {
+	t.Parallel()
+	t.Run("items are kept from the oldest to the newest", func(t *testing.T) {
+		t.Parallel()
+		b := NewSliceBuffer[int](3)
+		require.Empty(t, b.Snapshot())
+		require.Empty(t, b.Last(2))
+		for i := 0; i < 5; i++ {
+			b.Append(i)
+		}
+		require.Equal(t, 3, b.Len())
+		require.Equal(t, 3, b.Capacity())
+		require.Equal(t, []int{2, 3, 4}, b.Snapshot())
+		require.Equal(t, []int{3, 4}, b.Last(2))
+		require.Equal(t, []int{2, 3, 4}, b.Last(10))
+		var ranged []int
+		b.Range(func(v int) bool {
+			ranged = append(ranged, v)
+			return v < 3
+		})
+		require.Equal(t, []int{2, 3}, ranged)
+		require.Equal(t, []int{2, 3, 4}, b.Drain())
+		require.Equal(t, 0, b.Len())
+		b.Append(5)
+		require.Equal(t, []int{5}, b.Snapshot())
+		zero := NewSliceBuffer[int](0)
+		zero.Append(1)
+		require.Equal(t, 0, zero.Len())
+	})
+	t.Run("concurrent appends and drains lose nothing", func(t *testing.T) {
+		t.Parallel()
+		b := NewSliceBuffer[int](100000)
+		var wg sync.WaitGroup
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 10000; i++ {
+					b.Append(i)
+				}
+			}()
+		}
+		drained := 0
+		for i := 0; i < 10; i++ {
+			drained += len(b.Drain())
+		}
+		wg.Wait()
+		require.Equal(t, 40000, drained+len(b.Drain()))
+	})
+}
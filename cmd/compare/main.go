@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/smartcontractkit/wasp"
+)
+
+// compares two runs and exits with 1 if current run has regressions, sources are file sink dirs or Loki queries:
+//
+//	go run cmd/compare/main.go -baseline results/main -current results/branch -out comparison
+//	go run cmd/compare/main.go -baseline 'loki://?test=TestLoad&start=2024-01-01T10:00:00Z&end=2024-01-01T10:10:00Z&branch=main' -current results/branch
+//
+// set LOKI_URL, LOKI_TENANT_ID, LOKI_TOKEN or LOKI_BASIC_AUTH to read runs from Loki
+func main() {
+	cfg := wasp.DefaultCompareConfig()
+	baseline := flag.String("baseline", "", "baseline run, file sink dir or loki://?test=...&start=...")
+	current := flag.String("current", "", "current run, file sink dir or loki://?test=...&start=...")
+	out := flag.String("out", "", "dir to write comparison.json and comparison.md to")
+	flag.Float64Var(&cfg.LatencyTolerance, "latency-tolerance", cfg.LatencyTolerance, "allowed relative latency percentile increase")
+	flag.Float64Var(&cfg.ErrorRateTolerance, "error-tolerance", cfg.ErrorRateTolerance, "allowed absolute error rate increase")
+	flag.Float64Var(&cfg.ThroughputTolerance, "throughput-tolerance", cfg.ThroughputTolerance, "allowed relative throughput decrease")
+	flag.Float64Var(&cfg.Alpha, "alpha", wasp.DefaultCompareAlpha, "significance level")
+	flag.IntVar(&cfg.MinSamples, "min-samples", wasp.DefaultCompareMinSamples, "minimal amount of calls to detect a regression")
+	flag.Parse()
+	if *baseline == "" || *current == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	ctx := context.Background()
+	b, err := wasp.LoadRuns(ctx, *baseline)
+	if err != nil {
+		panic(err)
+	}
+	c, err := wasp.LoadRuns(ctx, *current)
+	if err != nil {
+		panic(err)
+	}
+	res, err := wasp.Compare(b, c, cfg)
+	if err != nil {
+		panic(err)
+	}
+	res.Baseline, res.Current = *baseline, *current
+	fmt.Print(res.Markdown())
+	if *out != "" {
+		if err := res.WriteFiles(*out); err != nil {
+			panic(err)
+		}
+	}
+	if !res.Passed {
+		os.Exit(1)
+	}
+}
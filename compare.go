@@ -0,0 +1,613 @@
+package wasp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/* Baseline comparison and regression detection between runs */
+
+const (
+	DefaultCompareAlpha               = 0.05
+	DefaultCompareLatencyTolerance    = 0.1
+	DefaultCompareErrorRateTolerance  = 0.01
+	DefaultCompareThroughputTolerance = 0.1
+	DefaultCompareMinSamples          = 20
+)
+
+// LokiRunsScheme is a prefix of Loki sources of LoadRuns
+const LokiRunsScheme = "loki://"
+
+var (
+	ErrCompareTolerance = errors.New("compare tolerances must be >= 0")
+	ErrCompareAlpha     = errors.New("compare alpha must be in (0, 1)")
+	ErrCompareSource    = errors.New("compare source must be a file sink dir or loki://?test=...&start=...")
+	ErrCompareDuplicate = errors.New("compare source has several runs of the same generator, use a separate dir for every run")
+)
+
+// Compared metrics, latency metrics are named by percentile, ex.: p95
+const (
+	CompareErrorRate  = "error_rate"
+	CompareThroughput = "throughput"
+	CompareMissing    = "missing"
+)
+
+// CompareConfig defines what is a regression, a change is a regression if it's out of tolerance and statistically significant
+type CompareConfig struct {
+	// Percentiles are compared latency percentiles, default is DefaultPercentiles
+	Percentiles []float64
+	// LatencyTolerance is an allowed relative increase of a latency percentile, 0.1 is +10%
+	LatencyTolerance float64
+	// ErrorRateTolerance is an allowed absolute increase of the error rate, 0.01 is +1%
+	ErrorRateTolerance float64
+	// ThroughputTolerance is an allowed relative decrease of the achieved RPS, 0.1 is -10%
+	ThroughputTolerance float64
+	// Alpha is a significance level, default is DefaultCompareAlpha
+	Alpha float64
+	// MinSamples is the minimal amount of calls in both runs to detect a regression, default is DefaultCompareMinSamples
+	MinSamples int
+}
+
+// DefaultCompareConfig returns default tolerance bands
+func DefaultCompareConfig() *CompareConfig {
+	return &CompareConfig{
+		LatencyTolerance:    DefaultCompareLatencyTolerance,
+		ErrorRateTolerance:  DefaultCompareErrorRateTolerance,
+		ThroughputTolerance: DefaultCompareThroughputTolerance,
+	}
+}
+
+func (m *CompareConfig) Validate() error {
+	if m.LatencyTolerance < 0 || m.ErrorRateTolerance < 0 || m.ThroughputTolerance < 0 {
+		return ErrCompareTolerance
+	}
+	if m.Alpha == 0 {
+		m.Alpha = DefaultCompareAlpha
+	}
+	if m.Alpha <= 0 || m.Alpha >= 1 {
+		return ErrCompareAlpha
+	}
+	if len(m.Percentiles) == 0 {
+		m.Percentiles = DefaultPercentiles
+	}
+	if m.MinSamples <= 0 {
+		m.MinSamples = DefaultCompareMinSamples
+	}
+	return nil
+}
+
+// CompareDiff is a difference of one metric in one generator, call group or schedule segment
+type CompareDiff struct {
+	Generator string `json:"generator"`
+	Group     string `json:"group,omitempty"`
+	Segment   int    `json:"segment,omitempty"`
+	Metric    string `json:"metric"`
+	// Baseline and Current are milliseconds for latency, ratio for error rate and RPS for throughput
+	Baseline float64 `json:"baseline"`
+	Current  float64 `json:"current"`
+	// Change is relative for latency and throughput, absolute for error rate
+	Change      float64 `json:"change"`
+	Tolerance   float64 `json:"tolerance"`
+	PValue      float64 `json:"p_value"`
+	Significant bool    `json:"significant"`
+	Regression  bool    `json:"regression"`
+	Note        string  `json:"note,omitempty"`
+}
+
+// Scope formats generator, group and segment of the diff
+func (m *CompareDiff) Scope() string {
+	s := m.Generator
+	if m.Group != "" {
+		s += fmt.Sprintf(" group=%s", m.Group)
+	}
+	if m.Segment > 0 {
+		s += fmt.Sprintf(" segment=%d", m.Segment)
+	}
+	return s
+}
+
+// Comparison is a diff report of two runs with a pass/fail verdict
+type Comparison struct {
+	Baseline    string         `json:"baseline"`
+	Current     string         `json:"current"`
+	GeneratedAt time.Time      `json:"generated_at"`
+	Config      *CompareConfig `json:"config"`
+	Passed      bool           `json:"passed"`
+	Diffs       []*CompareDiff `json:"diffs"`
+}
+
+// compareScope is data of a generator, a call group or a segment
+type compareScope struct {
+	latencies []time.Duration
+	requests  int64
+	errors    int64
+	duration  time.Duration
+}
+
+func (m *compareScope) add(r *Response) {
+	m.latencies = append(m.latencies, r.Duration)
+	m.requests++
+	if r.Failed || r.Timeout {
+		m.errors++
+	}
+}
+
+// span sets duration from the first call start to the last call finish
+func (m *compareScope) span(responses []*Response) {
+	var first, last time.Time
+	for _, r := range responses {
+		if r.StartedAt != nil && (first.IsZero() || r.StartedAt.Before(first)) {
+			first = *r.StartedAt
+		}
+		if r.FinishedAt != nil && r.FinishedAt.After(last) {
+			last = *r.FinishedAt
+		}
+	}
+	if !first.IsZero() && last.After(first) {
+		m.duration = last.Sub(first)
+	}
+}
+
+// compareRun is a run split by scopes
+type compareRun struct {
+	all      *compareScope
+	groups   map[string]*compareScope
+	segments map[int]*compareScope
+	// sampled is true if successful responses were not all recorded
+	sampled bool
+}
+
+func newCompareRun(run *FileRun) *compareRun {
+	m := &compareRun{
+		all:      &compareScope{},
+		groups:   make(map[string]*compareScope),
+		segments: make(map[int]*compareScope),
+	}
+	groupResponses := make(map[string][]*Response)
+	segmentResponses := make(map[int][]*Response)
+	for _, r := range run.Responses {
+		m.all.add(r)
+		if r.Group != "" {
+			if _, ok := m.groups[r.Group]; !ok {
+				m.groups[r.Group] = &compareScope{}
+			}
+			m.groups[r.Group].add(r)
+			groupResponses[r.Group] = append(groupResponses[r.Group], r)
+		}
+		if r.Segment > 0 {
+			if _, ok := m.segments[r.Segment]; !ok {
+				m.segments[r.Segment] = &compareScope{}
+			}
+			m.segments[r.Segment].add(r)
+			segmentResponses[r.Segment] = append(segmentResponses[r.Segment], r)
+		}
+	}
+	m.all.span(run.Responses)
+	for name, s := range m.groups {
+		s.span(groupResponses[name])
+	}
+	for i, s := range m.segments {
+		s.span(segmentResponses[i])
+	}
+	// counters of sampled runs are taken from the last stats snapshot of every node
+	lastByNode := make(map[interface{}]map[string]interface{})
+	for _, s := range run.Stats {
+		lastByNode[s["node_id"]] = s
+	}
+	if len(lastByNode) > 0 {
+		var requests, failed int64
+		var duration time.Duration
+		for _, last := range lastByNode {
+			if latency, ok := last["latency"].(map[string]interface{}); ok {
+				requests += statsInt(latency["count"])
+			}
+			failed += statsInt(last["failed"]) + statsInt(last["callTimeout"])
+			duration = max(duration, time.Duration(statsInt(last["load_duration"])))
+			m.sampled = m.sampled || statsInt(last["samples_skipped"]) > 0
+		}
+		m.all.requests, m.all.errors = requests, failed
+		if duration > 0 {
+			m.all.duration = duration
+		}
+	}
+	return m
+}
+
+// Compare lines up baseline and current runs by generator, call group and schedule segment
+// and reports differences of latency percentiles, error rate and throughput.
+// Runs can be read from file sinks with ReadFileRuns or from Loki with LokiQueryClient.Runs.
+// Group and segment error rate and throughput are compared only if all the responses were recorded
+func Compare(baseline, current []*FileRun, cfg *CompareConfig) (*Comparison, error) {
+	if cfg == nil {
+		cfg = DefaultCompareConfig()
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	res := &Comparison{GeneratedAt: time.Now(), Config: cfg, Passed: true, Diffs: make([]*CompareDiff, 0)}
+	names := make([]string, 0)
+	baselineRuns := make(map[string]*FileRun)
+	currentRuns := make(map[string]*FileRun)
+	for _, r := range baseline {
+		if prev, ok := baselineRuns[r.Name]; ok {
+			if prev.RunID != r.RunID {
+				return nil, fmt.Errorf("%w: baseline %s", ErrCompareDuplicate, r.Name)
+			}
+		} else {
+			names = append(names, r.Name)
+		}
+		baselineRuns[r.Name] = r
+	}
+	for _, r := range current {
+		if prev, ok := currentRuns[r.Name]; ok && prev.RunID != r.RunID {
+			return nil, fmt.Errorf("%w: current %s", ErrCompareDuplicate, r.Name)
+		}
+		if _, ok := baselineRuns[r.Name]; !ok {
+			if _, ok := currentRuns[r.Name]; !ok {
+				names = append(names, r.Name)
+			}
+		}
+		currentRuns[r.Name] = r
+	}
+	for _, name := range names {
+		b, c := baselineRuns[name], currentRuns[name]
+		switch {
+		case c == nil:
+			res.Diffs = append(res.Diffs, &CompareDiff{Generator: name, Metric: CompareMissing, Regression: true, Note: "generator is missing in the current run"})
+			continue
+		case b == nil:
+			res.Diffs = append(res.Diffs, &CompareDiff{Generator: name, Metric: CompareMissing, Note: "generator is missing in the baseline run"})
+			continue
+		}
+		br, cr := newCompareRun(b), newCompareRun(c)
+		sampled := br.sampled || cr.sampled
+		res.Diffs = append(res.Diffs, cfg.compareScopes(&CompareDiff{Generator: name}, br.all, cr.all, true)...)
+		for _, group := range sortedKeys(br.groups, cr.groups) {
+			res.Diffs = append(res.Diffs, cfg.compareScopes(&CompareDiff{Generator: name, Group: group}, br.groups[group], cr.groups[group], !sampled)...)
+		}
+		for _, segment := range sortedKeys(br.segments, cr.segments) {
+			res.Diffs = append(res.Diffs, cfg.compareScopes(&CompareDiff{Generator: name, Segment: segment}, br.segments[segment], cr.segments[segment], !sampled)...)
+		}
+	}
+	for _, d := range res.Diffs {
+		res.Passed = res.Passed && !d.Regression
+	}
+	return res, nil
+}
+
+func sortedKeys[K int | string](a, b map[K]*compareScope) []K {
+	keys := make([]K, 0)
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// compareScopes compares latency percentiles and, if counts are reliable, error rate and throughput of a scope
+func (m *CompareConfig) compareScopes(scope *CompareDiff, b, c *compareScope, counts bool) []*CompareDiff {
+	diff := func(metric string) *CompareDiff {
+		d := *scope
+		d.Metric = metric
+		return &d
+	}
+	if b == nil || c == nil {
+		d := diff(CompareMissing)
+		d.Note = "missing in the baseline run"
+		if c == nil {
+			d.Note = "missing in the current run"
+		}
+		return []*CompareDiff{d}
+	}
+	enough := len(b.latencies) >= m.MinSamples && len(c.latencies) >= m.MinSamples
+	res := make([]*CompareDiff, 0)
+
+	bh, ch := NewLatencyHistogram(), NewLatencyHistogram()
+	for _, l := range b.latencies {
+		bh.Record(l)
+	}
+	for _, l := range c.latencies {
+		ch.Record(l)
+	}
+	latencyP := mannWhitneyGreater(b.latencies, c.latencies)
+	for _, p := range m.Percentiles {
+		d := diff(PercentileKey(p))
+		d.Baseline, d.Current = durationMs(bh.Percentile(p)), durationMs(ch.Percentile(p))
+		d.Change = relativeChange(d.Baseline, d.Current)
+		d.Tolerance = m.LatencyTolerance
+		d.PValue = latencyP
+		m.verdict(d, enough, d.Change > m.LatencyTolerance)
+		res = append(res, d)
+	}
+	if !counts {
+		return res
+	}
+
+	enough = b.requests >= int64(m.MinSamples) && c.requests >= int64(m.MinSamples)
+	d := diff(CompareErrorRate)
+	if b.requests > 0 && c.requests > 0 {
+		d.Baseline, d.Current = float64(b.errors)/float64(b.requests), float64(c.errors)/float64(c.requests)
+	}
+	d.Change = d.Current - d.Baseline
+	d.Tolerance = m.ErrorRateTolerance
+	d.PValue = twoProportionGreater(b.errors, b.requests, c.errors, c.requests)
+	m.verdict(d, enough, d.Change > m.ErrorRateTolerance)
+	res = append(res, d)
+
+	d = diff(CompareThroughput)
+	if b.duration > 0 && c.duration > 0 {
+		d.Baseline, d.Current = float64(b.requests)/b.duration.Seconds(), float64(c.requests)/c.duration.Seconds()
+		d.Change = relativeChange(d.Baseline, d.Current)
+		d.PValue = poissonRateLess(b.requests, b.duration, c.requests, c.duration)
+	} else {
+		d.PValue = 1
+	}
+	d.Tolerance = m.ThroughputTolerance
+	m.verdict(d, enough && b.duration > 0 && c.duration > 0, d.Change < -m.ThroughputTolerance)
+	res = append(res, d)
+	return res
+}
+
+// verdict marks a diff as a regression if it is out of tolerance and significant
+func (m *CompareConfig) verdict(d *CompareDiff, enough, outOfTolerance bool) {
+	d.Significant = d.PValue < m.Alpha
+	if !enough {
+		d.Note = "not enough samples"
+		return
+	}
+	d.Regression = outOfTolerance && d.Significant
+}
+
+func relativeChange(baseline, current float64) float64 {
+	if baseline == 0 {
+		if current == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return (current - baseline) / baseline
+}
+
+// normalUpperTail is P(Z > z) of the standard normal distribution
+func normalUpperTail(z float64) float64 {
+	return 0.5 * math.Erfc(z/math.Sqrt2)
+}
+
+// mannWhitneyGreater is a one-sided Mann-Whitney U test p-value that current values are greater than baseline values,
+// it uses the normal approximation with ties correction
+func mannWhitneyGreater(baseline, current []time.Duration) float64 {
+	n1, n2 := float64(len(baseline)), float64(len(current))
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	type sample struct {
+		v       time.Duration
+		current bool
+	}
+	all := make([]sample, 0, len(baseline)+len(current))
+	for _, v := range baseline {
+		all = append(all, sample{v: v})
+	}
+	for _, v := range current {
+		all = append(all, sample{v: v, current: true})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+	var rankSum, ties float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		// average rank of tied values, ranks are 1-based
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].current {
+				rankSum += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+	u := rankSum - n2*(n2+1)/2
+	n := n1 + n2
+	sigma := math.Sqrt(n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1))))
+	if sigma == 0 {
+		return 1
+	}
+	// continuity correction
+	z := (u - n1*n2/2 - 0.5) / sigma
+	return normalUpperTail(z)
+}
+
+// twoProportionGreater is a one-sided two-proportion z-test p-value that the current ratio is greater than the baseline ratio
+func twoProportionGreater(x1, n1, x2, n2 int64) float64 {
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	p1, p2 := float64(x1)/float64(n1), float64(x2)/float64(n2)
+	p := float64(x1+x2) / float64(n1+n2)
+	se := math.Sqrt(p * (1 - p) * (1/float64(n1) + 1/float64(n2)))
+	if se == 0 {
+		return 1
+	}
+	return normalUpperTail((p2 - p1) / se)
+}
+
+// poissonRateLess is a one-sided p-value that the current rate of events is lower than the baseline rate
+func poissonRateLess(c1 int64, t1 time.Duration, c2 int64, t2 time.Duration) float64 {
+	s1, s2 := t1.Seconds(), t2.Seconds()
+	se := math.Sqrt(float64(c1)/(s1*s1) + float64(c2)/(s2*s2))
+	if se == 0 {
+		return 1
+	}
+	return normalUpperTail((float64(c1)/s1 - float64(c2)/s2) / se)
+}
+
+// Regressions returns only the diffs that are regressions
+func (m *Comparison) Regressions() []*CompareDiff {
+	res := make([]*CompareDiff, 0)
+	for _, d := range m.Diffs {
+		if d.Regression {
+			res = append(res, d)
+		}
+	}
+	return res
+}
+
+// JSON renders the comparison as indented JSON, infinite changes are rendered as null
+func (m *Comparison) JSON() ([]byte, error) {
+	type diff CompareDiff
+	type diffJSON struct {
+		*diff
+		Change *float64 `json:"change"`
+	}
+	diffs := make([]*diffJSON, 0, len(m.Diffs))
+	for _, d := range m.Diffs {
+		dj := &diffJSON{diff: (*diff)(d)}
+		if !math.IsInf(d.Change, 0) && !math.IsNaN(d.Change) {
+			change := d.Change
+			dj.Change = &change
+		}
+		diffs = append(diffs, dj)
+	}
+	return json.MarshalIndent(struct {
+		*Comparison
+		Diffs []*diffJSON `json:"diffs"`
+	}{m, diffs}, "", "  ")
+}
+
+// Markdown renders the verdict and a diff table, regressions first
+func (m *Comparison) Markdown() string {
+	var sb strings.Builder
+	verdict := "PASSED"
+	if !m.Passed {
+		verdict = "FAILED"
+	}
+	sb.WriteString(fmt.Sprintf("# Baseline comparison: %s\n\n", verdict))
+	if m.Baseline != "" || m.Current != "" {
+		sb.WriteString(fmt.Sprintf("Baseline: %s\n\nCurrent: %s\n\n", m.Baseline, m.Current))
+	}
+	sb.WriteString(fmt.Sprintf("Generated at: %s, alpha: %g\n\n", m.GeneratedAt.Format(time.RFC3339), m.Config.Alpha))
+	sb.WriteString("| Result | Scope | Metric | Baseline | Current | Change | Tolerance | p-value | Note |\n")
+	sb.WriteString("|---|---|---|---|---|---|---|---|---|\n")
+	diffs := append([]*CompareDiff{}, m.Diffs...)
+	sort.SliceStable(diffs, func(i, j int) bool { return diffs[i].Regression && !diffs[j].Regression })
+	for _, d := range diffs {
+		result := "ok"
+		if d.Regression {
+			result = "**regression**"
+		}
+		if d.Metric == CompareMissing {
+			sb.WriteString(fmt.Sprintf("| %s | %s | %s | | | | | | %s |\n", result, escapeMarkdownCell(d.Scope()), d.Metric, d.Note))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("| %s | %s | %s | %s | %s | %s | %s | %.4f | %s |\n",
+			result, escapeMarkdownCell(d.Scope()), d.Metric,
+			formatCompareValue(d.Metric, d.Baseline), formatCompareValue(d.Metric, d.Current),
+			formatCompareChange(d.Metric, d.Change), formatCompareChange(d.Metric, d.Tolerance),
+			d.PValue, d.Note))
+	}
+	return sb.String()
+}
+
+func formatCompareValue(metric string, v float64) string {
+	switch metric {
+	case CompareErrorRate:
+		return fmt.Sprintf("%.3f%%", v*100)
+	case CompareThroughput:
+		return fmt.Sprintf("%.2f RPS", v)
+	default:
+		return fmt.Sprintf("%.2f ms", v)
+	}
+}
+
+func formatCompareChange(metric string, v float64) string {
+	if metric == CompareErrorRate {
+		return fmt.Sprintf("%+.3f%%", v*100)
+	}
+	return fmt.Sprintf("%+.1f%%", v*100)
+}
+
+// WriteFiles writes the comparison to dir as comparison.json and comparison.md
+func (m *Comparison) WriteFiles(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	js, err := m.JSON()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "comparison.json"), js, 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "comparison.md"), []byte(m.Markdown()), 0o644)
+}
+
+// LoadRuns reads runs from a file sink dir or from Loki, Loki source is a query:
+// loki://?test=TestName&start=2006-01-02T15:04:05Z&end=...&gen=...&<label>=<value>,
+// connection params are taken from LOKI_* env vars, see NewEnvLokiConfig
+func LoadRuns(ctx context.Context, source string) ([]*FileRun, error) {
+	if !strings.HasPrefix(source, LokiRunsScheme) {
+		if fi, err := os.Stat(source); err != nil || !fi.IsDir() {
+			return nil, fmt.Errorf("%w: %s", ErrCompareSource, source)
+		}
+		return ReadFileRuns(source)
+	}
+	q, err := parseLokiRunsSource(source)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewLokiQueryClient(NewEnvLokiConfig())
+	if err != nil {
+		return nil, err
+	}
+	return c.Runs(ctx, q)
+}
+
+func parseLokiRunsSource(source string) (*LokiQuery, error) {
+	params, err := url.ParseQuery(strings.TrimPrefix(strings.TrimPrefix(source, LokiRunsScheme), "?"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCompareSource, err)
+	}
+	q := &LokiQuery{Labels: make(map[string]string)}
+	for k := range params {
+		v := params.Get(k)
+		switch k {
+		case "test":
+			q.TestName = v
+		case "gen":
+			q.GenName = v
+		case "start", "end":
+			ts, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrCompareSource, err)
+			}
+			if k == "start" {
+				q.Start = ts
+			} else {
+				q.End = ts
+			}
+		default:
+			q.Labels[k] = v
+		}
+	}
+	if q.Start.IsZero() {
+		return nil, fmt.Errorf("%w: start is required", ErrCompareSource)
+	}
+	return q, nil
+}
@@ -0,0 +1,161 @@
+package wasp
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func fileSinkRun(t *testing.T, gun *MockGunConfig) string {
+	dir := t.TempDir()
+	sink, err := NewFileSink(&FileSinkConfig{Dir: dir})
+	require.NoError(t, err)
+	gen, err := NewGenerator(&Config{
+		T:                 t,
+		GenName:           "compare",
+		LoadType:          RPS,
+		Schedule:          Combine(Plain(40, 1*time.Second), Plain(80, 1*time.Second)),
+		StatsPollInterval: 200 * time.Millisecond,
+		SamplerConfig:     &SamplerConfig{SuccessfulCallResultRecordRatio: 100},
+		Sinks:             []*SinkConfig{{Sink: sink}},
+		Gun:               NewMockGun(gun),
+	})
+	require.NoError(t, err)
+	gen.Run(true)
+	return dir
+}
+
+func findDiff(t *testing.T, c *Comparison, segment int, metric string) *CompareDiff {
+	for _, d := range c.Diffs {
+		if d.Group == "" && d.Segment == segment && d.Metric == metric {
+			return d
+		}
+	}
+	require.Failf(t, "diff not found", "segment: %d, metric: %s", segment, metric)
+	return nil
+}
+
+func TestSmokeCompare(t *testing.T) {
+	t.Parallel()
+	t.Run("regressions are detected between file sink runs", func(t *testing.T) {
+		t.Parallel()
+		baselineDir := fileSinkRun(t, &MockGunConfig{CallSleep: 10 * time.Millisecond})
+		currentDir := fileSinkRun(t, &MockGunConfig{CallSleep: 30 * time.Millisecond, FailRatio: 50})
+		baseline, err := LoadRuns(context.Background(), baselineDir)
+		require.NoError(t, err)
+		current, err := LoadRuns(context.Background(), currentDir)
+		require.NoError(t, err)
+
+		c, err := Compare(baseline, current, nil)
+		require.NoError(t, err)
+		require.False(t, c.Passed)
+		for _, segment := range []int{0, 1, 2} {
+			p50 := findDiff(t, c, segment, "p50")
+			require.True(t, p50.Regression)
+			require.Greater(t, p50.Change, 1.0)
+			require.Less(t, p50.PValue, 0.001)
+			errorRate := findDiff(t, c, segment, CompareErrorRate)
+			require.True(t, errorRate.Regression)
+			require.Equal(t, 0.0, errorRate.Baseline)
+			throughput := findDiff(t, c, segment, CompareThroughput)
+			require.False(t, throughput.Regression)
+		}
+		require.NotEmpty(t, c.Regressions())
+
+		out := t.TempDir()
+		require.NoError(t, c.WriteFiles(out))
+		md, err := os.ReadFile(filepath.Join(out, "comparison.md"))
+		require.NoError(t, err)
+		require.Contains(t, string(md), "# Baseline comparison: FAILED")
+		require.Contains(t, string(md), "**regression**")
+		js, err := os.ReadFile(filepath.Join(out, "comparison.json"))
+		require.NoError(t, err)
+		decoded := &Comparison{}
+		require.NoError(t, json.Unmarshal(js, decoded))
+		require.False(t, decoded.Passed)
+		require.Len(t, decoded.Diffs, len(c.Diffs))
+
+		// the same run has no regressions
+		c, err = Compare(baseline, baseline, nil)
+		require.NoError(t, err)
+		require.True(t, c.Passed)
+		for _, d := range c.Diffs {
+			require.Equal(t, 0.0, d.Change)
+			require.False(t, d.Significant)
+		}
+	})
+	t.Run("changes inside tolerance bands or with not enough samples pass", func(t *testing.T) {
+		t.Parallel()
+		responses := func(n int, d time.Duration) []*Response {
+			res := make([]*Response, 0, n)
+			for i := 0; i < n; i++ {
+				res = append(res, &Response{Duration: d + time.Duration(i)*time.Microsecond, Group: "g"})
+			}
+			return res
+		}
+		baseline := []*FileRun{{Name: "a", Responses: responses(100, 10*time.Millisecond)}}
+		current := []*FileRun{{Name: "a", Responses: responses(100, 10500*time.Microsecond)}}
+		c, err := Compare(baseline, current, DefaultCompareConfig())
+		require.NoError(t, err)
+		require.True(t, c.Passed)
+		p50 := findDiff(t, c, 0, "p50")
+		require.True(t, p50.Significant)
+		require.False(t, p50.Regression)
+
+		cfg := DefaultCompareConfig()
+		cfg.LatencyTolerance = 0.01
+		c, err = Compare(baseline, current, cfg)
+		require.NoError(t, err)
+		require.False(t, c.Passed)
+
+		c, err = Compare(
+			[]*FileRun{{Name: "a", Responses: responses(10, 10*time.Millisecond)}},
+			[]*FileRun{{Name: "a", Responses: responses(10, 50*time.Millisecond)}},
+			cfg,
+		)
+		require.NoError(t, err)
+		require.True(t, c.Passed)
+		require.Equal(t, "not enough samples", findDiff(t, c, 0, "p50").Note)
+	})
+	t.Run("generators are lined up by name", func(t *testing.T) {
+		t.Parallel()
+		c, err := Compare(
+			[]*FileRun{{Name: "a"}, {Name: "b"}},
+			[]*FileRun{{Name: "a"}, {Name: "c"}},
+			nil,
+		)
+		require.NoError(t, err)
+		require.False(t, c.Passed)
+		regressions := c.Regressions()
+		require.Len(t, regressions, 1)
+		require.Equal(t, "b", regressions[0].Generator)
+		require.Equal(t, CompareMissing, regressions[0].Metric)
+		require.Equal(t, "c", c.Diffs[len(c.Diffs)-1].Generator)
+		require.False(t, c.Diffs[len(c.Diffs)-1].Regression)
+	})
+	t.Run("validation", func(t *testing.T) {
+		t.Parallel()
+		_, err := Compare(nil, nil, &CompareConfig{LatencyTolerance: -1})
+		require.ErrorIs(t, err, ErrCompareTolerance)
+		_, err = Compare(nil, nil, &CompareConfig{Alpha: 2})
+		require.ErrorIs(t, err, ErrCompareAlpha)
+		for _, source := range []string{
+			filepath.Join(t.TempDir(), "missing"),
+			"loki://?test=TestX",
+			"loki://?test=TestX&start=yesterday",
+		} {
+			_, err = LoadRuns(context.Background(), source)
+			require.ErrorIs(t, err, ErrCompareSource)
+		}
+		q, err := parseLokiRunsSource("loki://?test=TestX&gen=a&start=2024-01-01T10:00:00Z&branch=main")
+		require.NoError(t, err)
+		require.Equal(t, "TestX", q.TestName)
+		require.Equal(t, "a", q.GenName)
+		require.Equal(t, map[string]string{"branch": "main"}, q.Labels)
+	})
+}
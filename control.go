@@ -0,0 +1,212 @@
+package wasp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+)
+
+/* Runtime control API to steer running generators */
+
+const (
+	DefaultControlServerShutdownTimeout = 5 * time.Second
+)
+
+var (
+	ErrControlServerStarted = errors.New("control server is already started")
+	ErrGeneratorNotFound    = errors.New("generator not found")
+)
+
+// ControlTarget is a request body to override current RPS or VUs
+type ControlTarget struct {
+	Target int64 `json:"target"`
+}
+
+// ControlSegment is a request body to append a schedule segment, duration is in time.ParseDuration format
+type ControlSegment struct {
+	From int64 `json:"from"`
+	// To makes the segment a ramp, it can be 0
+	To       *int64 `json:"to,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// ControlGeneratorStats is a generator name with its stats
+type ControlGeneratorStats struct {
+	Name  string                 `json:"name"`
+	Stats map[string]interface{} `json:"stats"`
+}
+
+// ControlServer is an HTTP API to list, pause, resume, stop and change the load of running generators
+type ControlServer struct {
+	addr     string
+	mu       *sync.Mutex
+	gens     []*Generator
+	srv      *http.Server
+	listener net.Listener
+	stopOnce *sync.Once
+	l        zerolog.Logger
+}
+
+// NewControlServer creates a new control server for generators, addr is in "host:port" format
+func NewControlServer(addr string, gens ...*Generator) *ControlServer {
+	return &ControlServer{
+		addr:     addr,
+		mu:       &sync.Mutex{},
+		gens:     gens,
+		stopOnce: &sync.Once{},
+		l:        GetLogger(nil, "ControlServer"),
+	}
+}
+
+// Add adds a generator to the control server
+func (m *ControlServer) Add(g *Generator) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gens = append(m.gens, g)
+}
+
+// Addr returns the address control server is listening on
+func (m *ControlServer) Addr() string {
+	if m.listener == nil {
+		return m.addr
+	}
+	return m.listener.Addr().String()
+}
+
+// Start starts serving the control API
+func (m *ControlServer) Start() error {
+	if m.srv != nil {
+		return ErrControlServerStarted
+	}
+	ln, err := net.Listen("tcp", m.addr)
+	if err != nil {
+		return err
+	}
+	m.listener = ln
+	m.srv = &http.Server{Handler: m.router()}
+	m.l.Info().Str("Addr", m.Addr()).Msg("Control server started")
+	go func() {
+		if err := m.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			m.l.Err(err).Msg("Control server failed")
+		}
+	}()
+	return nil
+}
+
+// Stop gracefully shuts down the control server
+func (m *ControlServer) Stop() {
+	if m.srv == nil {
+		return
+	}
+	m.stopOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultControlServerShutdownTimeout)
+		defer cancel()
+		if err := m.srv.Shutdown(ctx); err != nil {
+			m.l.Err(err).Msg("Failed to stop control server")
+		}
+		m.l.Info().Msg("Control server exited")
+	})
+}
+
+// generator finds generator by its GenName or index
+func (m *ControlServer) generator(id string) (*Generator, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, g := range m.gens {
+		if g.Cfg.GenName == id {
+			return g, nil
+		}
+	}
+	if idx, err := strconv.Atoi(id); err == nil && idx >= 0 && idx < len(m.gens) {
+		return m.gens[idx], nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrGeneratorNotFound, id)
+}
+
+// withGenerator resolves generator from the request path and passes it to the handler
+func (m *ControlServer) withGenerator(h func(c *gin.Context, g *Generator)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		g, err := m.generator(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h(c, g)
+	}
+}
+
+func (m *ControlServer) router() *gin.Engine {
+	gin.SetMode(gin.ReleaseMode)
+	r := gin.New()
+	r.GET("/generators", func(c *gin.Context) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		res := make([]ControlGeneratorStats, 0)
+		for _, g := range m.gens {
+			res = append(res, ControlGeneratorStats{Name: g.Cfg.GenName, Stats: g.StatsJSON()})
+		}
+		c.JSON(http.StatusOK, res)
+	})
+	r.GET("/generators/:id", m.withGenerator(func(c *gin.Context, g *Generator) {
+		c.JSON(http.StatusOK, ControlGeneratorStats{Name: g.Cfg.GenName, Stats: g.StatsJSON()})
+	}))
+	r.POST("/generators/:id/pause", m.withGenerator(func(c *gin.Context, g *Generator) {
+		g.Pause()
+		c.Status(http.StatusOK)
+	}))
+	r.POST("/generators/:id/resume", m.withGenerator(func(c *gin.Context, g *Generator) {
+		g.Resume()
+		c.Status(http.StatusOK)
+	}))
+	r.POST("/generators/:id/stop", m.withGenerator(func(c *gin.Context, g *Generator) {
+		// stop waits for all in-flight calls, so we are not blocking the request
+		go g.Stop()
+		c.Status(http.StatusAccepted)
+	}))
+	r.POST("/generators/:id/target", m.withGenerator(func(c *gin.Context, g *Generator) {
+		var req ControlTarget
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := g.SetTarget(req.Target); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusOK)
+	}))
+	r.POST("/generators/:id/segments", m.withGenerator(func(c *gin.Context, g *Generator) {
+		var req []ControlSegment
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		segs := make([]*Segment, 0)
+		for _, s := range req {
+			d, err := time.ParseDuration(s.Duration)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			seg := &Segment{From: s.From, Duration: d}
+			if s.To != nil {
+				seg.To, seg.Ramp = *s.To, true
+			}
+			segs = append(segs, seg)
+		}
+		if err := g.AppendSegments(segs...); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusOK)
+	}))
+	return r
+}
@@ -0,0 +1,106 @@
+package wasp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func controlPost(t *testing.T, addr, path string, body interface{}) int {
+	d, err := json.Marshal(body)
+	require.NoError(t, err)
+	resp, err := http.Post(fmt.Sprintf("http://%s%s", addr, path), "application/json", bytes.NewReader(d))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestSmokeControlServer(t *testing.T) {
+	t.Parallel()
+	t.Run("can override RPS and append segments", func(t *testing.T) {
+		t.Parallel()
+		gen, err := NewGenerator(&Config{
+			T:        t,
+			GenName:  "A",
+			LoadType: RPS,
+			Schedule: Plain(5, 2*time.Second),
+			Gun: NewMockGun(&MockGunConfig{
+				CallSleep: 10 * time.Millisecond,
+			}),
+		})
+		require.NoError(t, err)
+		cs := NewControlServer("127.0.0.1:0", gen)
+		require.NoError(t, cs.Start())
+		defer cs.Stop()
+		gen.Run(false)
+
+		resp, err := http.Get(fmt.Sprintf("http://%s/generators", cs.Addr()))
+		require.NoError(t, err)
+		var list []ControlGeneratorStats
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+		_ = resp.Body.Close()
+		require.Len(t, list, 1)
+		require.Equal(t, "A", list[0].Name)
+
+		require.Equal(t, http.StatusOK, controlPost(t, cs.Addr(), "/generators/A/target", ControlTarget{Target: 20}))
+		require.Equal(t, int64(20), gen.Stats().CurrentRPS.Load())
+		require.Equal(t, http.StatusBadRequest, controlPost(t, cs.Addr(), "/generators/A/target", ControlTarget{Target: 0}))
+		require.Equal(t, http.StatusOK, controlPost(t, cs.Addr(), "/generators/A/segments", []ControlSegment{
+			{From: 10, Duration: "1s"},
+		}))
+		require.Equal(t, http.StatusNotFound, controlPost(t, cs.Addr(), "/generators/B/pause", nil))
+
+		_, failed := gen.Wait()
+		require.Equal(t, false, failed)
+		stats := gen.Stats()
+		require.Equal(t, int64(2), stats.LastSegment.Load())
+		require.Equal(t, int64(10), stats.CurrentRPS.Load())
+		require.Equal(t, (3 * time.Second).Nanoseconds(), stats.Duration)
+		require.Equal(t, ErrScheduleFinished, gen.SetTarget(10))
+		require.Equal(t, ErrScheduleFinished, gen.AppendSegments(Plain(1, 1*time.Second)...))
+	})
+	t.Run("can pause, resume and stop profile generators", func(t *testing.T) {
+		t.Parallel()
+		p, err := NewProfile().
+			WithControlServer("127.0.0.1:0").
+			Add(NewGenerator(&Config{
+				T:        t,
+				GenName:  "A",
+				LoadType: RPS,
+				Schedule: Plain(10, 20*time.Second),
+				Gun: NewMockGun(&MockGunConfig{
+					CallSleep: 10 * time.Millisecond,
+				}),
+			})).
+			Add(NewGenerator(&Config{
+				T:        t,
+				GenName:  "B",
+				LoadType: VU,
+				Schedule: Plain(1, 20*time.Second),
+				VU: NewMockVU(&MockVirtualUserConfig{
+					CallSleep: 10 * time.Millisecond,
+				}),
+			})).
+			Run(false)
+		require.NoError(t, err)
+		addr := p.control.Addr()
+		require.Equal(t, http.StatusOK, controlPost(t, addr, "/generators/A/pause", nil))
+		require.Equal(t, true, p.Generators[0].Stats().RunPaused.Load())
+		require.Equal(t, http.StatusOK, controlPost(t, addr, "/generators/A/resume", nil))
+		require.Equal(t, false, p.Generators[0].Stats().RunPaused.Load())
+		require.Equal(t, http.StatusOK, controlPost(t, addr, "/generators/1/target", ControlTarget{Target: 3}))
+		require.Equal(t, int64(3), p.Generators[1].Stats().CurrentVUs.Load())
+		require.Equal(t, http.StatusAccepted, controlPost(t, addr, "/generators/A/stop", nil))
+		require.Equal(t, http.StatusAccepted, controlPost(t, addr, "/generators/B/stop", nil))
+		startedAt := time.Now()
+		p.Wait()
+		require.Less(t, time.Since(startedAt), 5*time.Second)
+		require.Equal(t, true, p.Generators[0].Stats().RunStopped.Load())
+		require.Equal(t, true, p.Generators[1].Stats().RunStopped.Load())
+	})
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"os"
+
 	"github.com/smartcontractkit/wasp/dashboard"
 )
 
 func main() {
 	// just default dashboard, no NFRs, no dashboard extensions
 	// see examples/alerts.go for an example extension
-	d, err := dashboard.NewDashboard(nil, nil)
+	newDashboard := dashboard.NewDashboard
+	// set DATA_SOURCE_TYPE=prometheus to use wasp Prometheus exporter metrics instead of Loki
+	if os.Getenv("DATA_SOURCE_TYPE") == "prometheus" {
+		newDashboard = dashboard.NewPrometheusDashboard
+	}
+	d, err := newDashboard(nil, nil)
 	if err != nil {
 		panic(err)
 	}
@@ -58,6 +58,18 @@ type Dashboard struct {
 
 // NewDashboard creates new dashboard
 func NewDashboard(reqs []WaspAlert, opts []dashboard.Option) (*Dashboard, error) {
+	dash, err := dashboardFromEnv(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := dash.Build(dash.Name, dash.DataSourceName, reqs); err != nil {
+		return nil, fmt.Errorf("failed to build dashboard: %s", err)
+	}
+	return dash, nil
+}
+
+// dashboardFromEnv creates dashboard settings from environment variables
+func dashboardFromEnv(opts []dashboard.Option) (*Dashboard, error) {
 	name := os.Getenv("DASHBOARD_NAME")
 	if name == "" {
 		return nil, fmt.Errorf("DASHBOARD_NAME must be provided")
@@ -78,19 +90,14 @@ func NewDashboard(reqs []WaspAlert, opts []dashboard.Option) (*Dashboard, error)
 	if grafanaToken == "" {
 		return nil, fmt.Errorf("GRAFANA_TOKEN must be provided")
 	}
-	dash := &Dashboard{
+	return &Dashboard{
 		Name:           name,
 		DataSourceName: dsn,
 		Folder:         dbf,
 		GrafanaURL:     grafanaURL,
 		GrafanaToken:   grafanaToken,
 		extendedOpts:   opts,
-	}
-	err := dash.Build(name, dsn, reqs)
-	if err != nil {
-		return nil, fmt.Errorf("failed to build dashboard: %s", err)
-	}
-	return dash, nil
+	}, nil
 }
 
 // Deploy deploys this dashboard to some Grafana folder
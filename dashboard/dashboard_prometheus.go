@@ -0,0 +1,252 @@
+package dashboard
+
+import (
+	"fmt"
+
+	"github.com/K-Phoen/grabana/alert"
+	"github.com/K-Phoen/grabana/dashboard"
+	"github.com/K-Phoen/grabana/row"
+	"github.com/K-Phoen/grabana/target/prometheus"
+	"github.com/K-Phoen/grabana/timeseries"
+	"github.com/K-Phoen/grabana/timeseries/axis"
+	"github.com/K-Phoen/grabana/variable/query"
+)
+
+/* Dashboard variant for wasp Prometheus exporter, uses PromQL instead of LogQL */
+
+// PrometheusSelector selects wasp series by dashboard variables
+const PrometheusSelector = `go_test_name=~"${go_test_name:pipe}", gen_name=~"${gen_name:pipe}"`
+
+// NewPrometheusDashboard creates new dashboard for a Prometheus data source
+func NewPrometheusDashboard(reqs []WaspAlert, opts []dashboard.Option) (*Dashboard, error) {
+	dash, err := dashboardFromEnv(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := dash.BuildPrometheus(dash.Name, dash.DataSourceName, reqs); err != nil {
+		return nil, fmt.Errorf("failed to build dashboard: %s", err)
+	}
+	return dash, nil
+}
+
+// BuildPrometheus creates dashboard instance with PromQL panels
+func (m *Dashboard) BuildPrometheus(dashboardName, datasourceName string, requirements []WaspAlert) error {
+	opts := []dashboard.Option{
+		dashboard.UID(m.Name),
+		dashboard.AutoRefresh("5"),
+		dashboard.Time("now-30m", "now"),
+		dashboard.Tags([]string{"generated", "load-test"}),
+	}
+	opts = append(opts, AddPrometheusVariables(datasourceName)...)
+	opts = append(opts, WASPPrometheusLoadStatsRow(datasourceName))
+	opts = append(opts, timeSeriesWithPrometheusAlerts(datasourceName, requirements)...)
+	opts = append(opts, m.extendedOpts...)
+	b, err := dashboard.New(dashboardName, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to create a dashboard builder: %s", err)
+	}
+	m.builder = b
+	return nil
+}
+
+// AddPrometheusVariables adds variables for wasp exporter labels
+func AddPrometheusVariables(datasourceName string) []dashboard.Option {
+	opts := make([]dashboard.Option, 0)
+	for _, name := range []string{"go_test_name", "gen_name", "call_group"} {
+		opts = append(opts, dashboard.VariableAsQuery(
+			name,
+			query.DataSource(datasourceName),
+			query.Multiple(),
+			query.IncludeAll(),
+			query.Request(fmt.Sprintf("label_values(wasp_response_duration_seconds_count, %s)", name)),
+			query.Sort(query.NumericalAsc),
+		))
+	}
+	return opts
+}
+
+// InlinePrometheusAlertParams is specific params for predefined alerts for wasp dashboard with a Prometheus data source
+func InlinePrometheusAlertParams(queryType, testName, genName string) string {
+	switch queryType {
+	case AlertTypeQuantile99:
+		return fmt.Sprintf(`
+histogram_quantile(0.99, sum(rate(wasp_response_duration_seconds_bucket{go_test_name="%s", gen_name="%s"}[10s])) by (le)) * 1e3`, testName, genName)
+	case AlertTypeErrors:
+		return fmt.Sprintf(`
+sum(wasp_failed_total{go_test_name="%s", gen_name="%s"}) by (go_test_name, gen_name)`, testName, genName)
+	case AlertTypeTimeouts:
+		return fmt.Sprintf(`
+sum(wasp_timeout_total{go_test_name="%s", gen_name="%s"}) by (go_test_name, gen_name)`, testName, genName)
+	default:
+		return ""
+	}
+}
+
+// timeSeriesWithPrometheusAlerts creates timeseries graphs per alert + definition of alert
+func timeSeriesWithPrometheusAlerts(datasourceName string, alertDefs []WaspAlert) []dashboard.Option {
+	dashboardOpts := make([]dashboard.Option, 0)
+	for _, a := range alertDefs {
+		tsOpts := []timeseries.Option{
+			timeseries.Transparent(),
+			timeseries.Span(12),
+			timeseries.Height("200px"),
+			timeseries.DataSource(datasourceName),
+			timeseries.Legend(timeseries.Bottom),
+		}
+		var rowTitle string
+		if a.CustomAlert == nil {
+			rowTitle = fmt.Sprintf("Alert: %s, Requirement: %s", a.Name, a.RequirementGroupName)
+			q := InlinePrometheusAlertParams(a.AlertType, a.TestName, a.GenName)
+			tsOpts = append(tsOpts,
+				timeseries.Alert(
+					a.Name,
+					alert.For(DefaultAlertFor),
+					alert.OnExecutionError(alert.ErrorKO),
+					alert.Description(a.Name),
+					alert.Tags(map[string]string{
+						"service":                  "wasp",
+						DefaultRequirementLabelKey: a.RequirementGroupName,
+					}),
+					alert.WithPrometheusQuery(a.Name, q),
+					alert.If(alert.Last, a.Name, a.AlertIf),
+					alert.EvaluateEvery(DefaultAlertEvaluateEvery),
+				),
+				timeseries.WithPrometheusTarget(q),
+			)
+		} else {
+			rowTitle = fmt.Sprintf("External alert: %s, Requirement: %s", a.Name, a.RequirementGroupName)
+			tsOpts = append(tsOpts, a.CustomAlert)
+		}
+		dashboardOpts = append(dashboardOpts,
+			dashboard.Row(
+				rowTitle,
+				row.Collapse(),
+				row.HideTitle(),
+				row.WithTimeSeries(a.Name, tsOpts...),
+			))
+	}
+	return dashboardOpts
+}
+
+// WASPPrometheusLoadStatsRow is WASPLoadStatsRow for wasp Prometheus exporter metrics
+func WASPPrometheusLoadStatsRow(dataSource string) dashboard.Option {
+	quantile := func(q string) timeseries.Option {
+		return timeseries.WithPrometheusTarget(
+			`histogram_quantile(`+q+`, sum(rate(wasp_response_duration_seconds_bucket{`+PrometheusSelector+`}[$__rate_interval])) by (le, go_test_name, gen_name)) * 1e3`,
+			prometheus.Legend("{{go_test_name}} {{gen_name}} Q "+q),
+		)
+	}
+	return dashboard.Row(
+		"WASP Load Stats",
+		defaultStatWidget(
+			"RPS (Now)",
+			dataSource,
+			`sum(wasp_current_rps{`+PrometheusSelector+`}) by (go_test_name, gen_name)`,
+			`{{go_test_name}} {{gen_name}} RPS`,
+		),
+		defaultStatWidget(
+			"VUs (Now)",
+			dataSource,
+			`sum(wasp_current_vus{`+PrometheusSelector+`}) by (go_test_name, gen_name)`,
+			`{{go_test_name}} {{gen_name}} VUs`,
+		),
+		defaultStatWidget(
+			"Responses/sec (Now)",
+			dataSource,
+			`sum(rate(wasp_response_duration_seconds_count{`+PrometheusSelector+`}[$__rate_interval])) by (go_test_name, gen_name)`,
+			`{{go_test_name}} {{gen_name}} Responses/sec`,
+		),
+		defaultStatWidget(
+			"Successful requests (Total)",
+			dataSource,
+			`sum(wasp_success_total{`+PrometheusSelector+`}) by (go_test_name, gen_name)`,
+			`{{go_test_name}} {{gen_name}} Successful requests`,
+		),
+		defaultStatWidget(
+			"Errored requests (Total)",
+			dataSource,
+			`sum(wasp_failed_total{`+PrometheusSelector+`}) by (go_test_name, gen_name)`,
+			`{{go_test_name}} {{gen_name}} Errored requests`,
+		),
+		defaultStatWidget(
+			"Timed out requests (Total)",
+			dataSource,
+			`sum(wasp_timeout_total{`+PrometheusSelector+`}) by (go_test_name, gen_name)`,
+			`{{go_test_name}} {{gen_name}} Timed out requests`,
+		),
+		row.WithTimeSeries(
+			"RPS/VUs per schedule segments",
+			timeseries.Transparent(),
+			timeseries.Span(6),
+			timeseries.Height("300px"),
+			timeseries.DataSource(dataSource),
+			timeseries.WithPrometheusTarget(
+				`sum(wasp_current_rps{`+PrometheusSelector+`}) by (node_id, go_test_name, gen_name)`,
+				prometheus.Legend("{{go_test_name}} {{gen_name}} RPS"),
+			),
+			timeseries.WithPrometheusTarget(
+				`sum(wasp_current_vus{`+PrometheusSelector+`}) by (node_id, go_test_name, gen_name)`,
+				prometheus.Legend("{{go_test_name}} {{gen_name}} VUs"),
+			),
+			timeseries.WithPrometheusTarget(
+				`max(wasp_current_segment{`+PrometheusSelector+`}) by (go_test_name, gen_name)`,
+				prometheus.Legend("{{go_test_name}} {{gen_name}} segment"),
+			),
+		),
+		row.WithTimeSeries(
+			"Responses/sec (Generator, CallGroup)",
+			timeseries.Transparent(),
+			timeseries.Span(6),
+			timeseries.Height("300px"),
+			timeseries.DataSource(dataSource),
+			timeseries.Axis(
+				axis.Unit("Responses"),
+				axis.Label("Responses"),
+			),
+			timeseries.Legend(timeseries.Bottom),
+			timeseries.WithPrometheusTarget(
+				`sum(rate(wasp_response_duration_seconds_count{`+PrometheusSelector+`, call_group=~"${call_group:pipe}"}[$__rate_interval])) by (go_test_name, gen_name, call_group)`,
+				prometheus.Legend("{{go_test_name}} {{gen_name}} {{call_group}} responses/sec"),
+			),
+			timeseries.WithPrometheusTarget(
+				`sum(rate(wasp_failed_total{`+PrometheusSelector+`}[$__rate_interval])) by (go_test_name, gen_name)`,
+				prometheus.Legend("{{go_test_name}} {{gen_name}} errors/sec"),
+			),
+			timeseries.WithPrometheusTarget(
+				`sum(rate(wasp_timeout_total{`+PrometheusSelector+`}[$__rate_interval])) by (go_test_name, gen_name)`,
+				prometheus.Legend("{{go_test_name}} {{gen_name}} timeouts/sec"),
+			),
+		),
+		row.WithTimeSeries(
+			"Latency quantiles over groups (99, 95, 50)",
+			timeseries.Transparent(),
+			timeseries.Span(6),
+			timeseries.Height("300px"),
+			timeseries.DataSource(dataSource),
+			timeseries.Legend(timeseries.Bottom),
+			timeseries.Axis(
+				axis.Unit("ms"),
+				axis.Label("ms"),
+			),
+			quantile("0.99"),
+			quantile("0.95"),
+			quantile("0.50"),
+		),
+		row.WithTimeSeries(
+			"Responses latencies by types over time (Generator, CallGroup)",
+			timeseries.Transparent(),
+			timeseries.Span(6),
+			timeseries.Height("300px"),
+			timeseries.DataSource(dataSource),
+			timeseries.Axis(
+				axis.Unit("ms"),
+				axis.Label("ms"),
+			),
+			timeseries.Legend(timeseries.Bottom),
+			timeseries.WithPrometheusTarget(
+				`histogram_quantile(0.99, sum(rate(wasp_response_duration_seconds_bucket{`+PrometheusSelector+`, call_group=~"${call_group:pipe}"}[$__rate_interval])) by (le, go_test_name, gen_name, call_group)) * 1e3`,
+				prometheus.Legend("{{go_test_name}} {{gen_name}} {{call_group}} Q 0.99"),
+			),
+		),
+	)
+}
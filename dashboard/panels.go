@@ -35,6 +35,7 @@ func RPSPanel(dataSource string, query map[string]string) row.Option {
 	)
 }
 
+// RPSVUPerScheduleSegmentsPanel shows current RPS/VUs, ramp segments are reported with interpolated values
 func RPSVUPerScheduleSegmentsPanel(dataSource string, query map[string]string) row.Option {
 	queryString := ""
 	for key, value := range query {
@@ -48,7 +49,7 @@ func RPSVUPerScheduleSegmentsPanel(dataSource string, query map[string]string) r
 		timeseries.DataSource(dataSource),
 		timeseries.WithPrometheusTarget(
 			`
-			max_over_time({`+queryString+`go_test_name=~"${go_test_name:pipe}", test_data_type=~"stats", gen_name=~"${gen_name:pipe}"}
+			last_over_time({`+queryString+`go_test_name=~"${go_test_name:pipe}", test_data_type=~"stats", gen_name=~"${gen_name:pipe}"}
 			| json
 			| unwrap current_rps [$__interval]) by (node_id, go_test_name, gen_name)
 			`, prometheus.Legend("{{go_test_name}} {{gen_name}} RPS"),
@@ -63,7 +64,7 @@ func RPSVUPerScheduleSegmentsPanel(dataSource string, query map[string]string) r
 		),
 		timeseries.WithPrometheusTarget(
 			`
-			max_over_time({`+queryString+`go_test_name=~"${go_test_name:pipe}", test_data_type=~"stats", gen_name=~"${gen_name:pipe}"}
+			last_over_time({`+queryString+`go_test_name=~"${go_test_name:pipe}", test_data_type=~"stats", gen_name=~"${gen_name:pipe}"}
 			| json
 			| unwrap current_instances [$__interval]) by (node_id, go_test_name, gen_name)
 			`, prometheus.Legend("{{go_test_name}} {{gen_name}} VUs"),
@@ -0,0 +1,680 @@
+package wasp
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/* File sink writing raw responses and stats to rotating JSONL or CSV segments */
+
+const (
+	DefaultFileSinkSegmentBytes = 100 * 1024 * 1024
+)
+
+var (
+	ErrFileSinkNoDir      = errors.New("file sink dir must be set")
+	ErrFileSinkFormat     = errors.New("file sink format must be jsonl or csv")
+	ErrFileSinkSegment    = errors.New("file sink segment size must be >= 0")
+	ErrFileSinkBadSegment = errors.New("not a file sink segment")
+)
+
+// FileFormat is a format of file sink segments
+type FileFormat string
+
+const (
+	FileFormatJSONL FileFormat = "jsonl"
+	FileFormatCSV   FileFormat = "csv"
+)
+
+// FileRecordType is a type of a file sink record
+type FileRecordType string
+
+const (
+	FileRecordResponse FileRecordType = "responses"
+	FileRecordStats    FileRecordType = "stats"
+)
+
+var (
+	fileSinkResponseHeader = []string{"time", "labels", "group", "duration", "failed", "timeout", "status_code", "path", "error", "started_at", "finished_at", "data", "segment", "intended_at", "corrected_duration", "run_id"}
+	fileSinkStatsHeader    = []string{"time", "labels", "stats", "run_id"}
+)
+
+// FileSinkConfig is a configuration of a file sink
+type FileSinkConfig struct {
+	// Dir is a directory for segments, it is created if needed
+	Dir    string
+	Format FileFormat
+	// MaxSegmentBytes rotates a segment when it has more uncompressed bytes, default is DefaultFileSinkSegmentBytes
+	MaxSegmentBytes int64
+	// Gzip compresses segments
+	Gzip bool
+}
+
+func (m *FileSinkConfig) Validate() error {
+	if m.Dir == "" {
+		return ErrFileSinkNoDir
+	}
+	if m.Format == "" {
+		m.Format = FileFormatJSONL
+	}
+	if m.Format != FileFormatJSONL && m.Format != FileFormatCSV {
+		return ErrFileSinkFormat
+	}
+	if m.MaxSegmentBytes < 0 {
+		return ErrFileSinkSegment
+	}
+	if m.MaxSegmentBytes == 0 {
+		m.MaxSegmentBytes = DefaultFileSinkSegmentBytes
+	}
+	return nil
+}
+
+// FileRecord is one response or stats record with generator labels,
+// RunID is unique for every file sink, so runs written to the same dir can be told apart
+type FileRecord struct {
+	Type     FileRecordType         `json:"type"`
+	Time     time.Time              `json:"time"`
+	RunID    string                 `json:"run_id,omitempty"`
+	Labels   map[string]string      `json:"labels"`
+	Response *Response              `json:"response,omitempty"`
+	Stats    map[string]interface{} `json:"stats,omitempty"`
+}
+
+// FileSink writes recorded responses and stats snapshots of generators to local files,
+// every generator and record type has its own segments: <gen_name>_<type>_<seq>.<format>[.gz].
+// One sink can be shared by several generators, a generator closes only its own segments when it finishes
+type FileSink struct {
+	cfg     *FileSinkConfig
+	runID   string
+	mu      *sync.Mutex
+	writers map[*Generator]map[string]*segmentWriter
+}
+
+// NewFileSink creates a new file sink
+func NewFileSink(cfg *FileSinkConfig) (*FileSink, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileSink{
+		cfg:     cfg,
+		runID:   uuid.NewString(),
+		mu:      &sync.Mutex{},
+		writers: make(map[*Generator]map[string]*segmentWriter),
+	}, nil
+}
+
+func (m *FileSink) Name() string {
+	return "file"
+}
+
+// RunID returns the id written to all the records of this sink
+func (m *FileSink) RunID() string {
+	return m.runID
+}
+
+func (m *FileSink) HandleResponse(g *Generator, r *Response) error {
+	ts := time.Now()
+	if r.FinishedAt != nil {
+		ts = *r.FinishedAt
+	}
+	return m.write(g, &FileRecord{Type: FileRecordResponse, Time: ts, RunID: m.runID, Labels: generatorLabels(g), Response: r})
+}
+
+func (m *FileSink) HandleStats(g *Generator, stats map[string]interface{}) error {
+	return m.write(g, &FileRecord{Type: FileRecordStats, Time: time.Now(), RunID: m.runID, Labels: generatorLabels(g), Stats: stats})
+}
+
+// CloseGenerator flushes and closes the segments of one generator, segments of other generators stay open
+func (m *FileSink) CloseGenerator(g *Generator) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.closeWriters(g)
+}
+
+// Close flushes and closes all the segments
+func (m *FileSink) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var errs []error
+	for g := range m.writers {
+		errs = append(errs, m.closeWriters(g))
+	}
+	return errors.Join(errs...)
+}
+
+// closeWriters closes the segments of a generator, must be called under lock
+func (m *FileSink) closeWriters(g *Generator) error {
+	var errs []error
+	for _, w := range m.writers[g] {
+		errs = append(errs, w.Close())
+	}
+	delete(m.writers, g)
+	return errors.Join(errs...)
+}
+
+func (m *FileSink) write(g *Generator, rec *FileRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name := fmt.Sprintf("%s_%s", reportFileNameRe.ReplaceAllString(g.Cfg.GenName, "_"), rec.Type)
+	if m.writers[g] == nil {
+		m.writers[g] = make(map[string]*segmentWriter)
+	}
+	w, ok := m.writers[g][name]
+	if !ok {
+		w = newSegmentWriter(m.cfg.Dir, name, m.cfg.Format, m.cfg.Gzip, m.cfg.MaxSegmentBytes)
+		m.writers[g][name] = w
+	}
+	if m.cfg.Format == FileFormatJSONL {
+		return w.WriteJSON(rec)
+	}
+	if rec.Type == FileRecordStats {
+		return w.WriteCSV(fileSinkStatsHeader, statsCSVRow(rec))
+	}
+	row, err := responseCSVRow(rec)
+	if err != nil {
+		return err
+	}
+	return w.WriteCSV(fileSinkResponseHeader, row)
+}
+
+func generatorLabels(g *Generator) map[string]string {
+	ls := make(map[string]string, len(g.labels))
+	for k, v := range g.labels {
+		ls[string(k)] = string(v)
+	}
+	return ls
+}
+
+func responseCSVRow(rec *FileRecord) ([]string, error) {
+	r := rec.Response
+	data := ""
+	if r.Data != nil {
+		d, err := json.Marshal(r.Data)
+		if err != nil {
+			return nil, err
+		}
+		data = string(d)
+	}
+	return []string{
+		rec.Time.Format(time.RFC3339Nano),
+		labelsCSV(rec.Labels),
+		r.Group,
+		strconv.FormatInt(int64(r.Duration), 10),
+		strconv.FormatBool(r.Failed),
+		strconv.FormatBool(r.Timeout),
+		r.StatusCode,
+		r.Path,
+		r.Error,
+		timeCSV(r.StartedAt),
+		timeCSV(r.FinishedAt),
+		data,
+		strconv.Itoa(r.Segment),
+		timeCSV(r.IntendedAt),
+		strconv.FormatInt(int64(r.CorrectedDuration), 10),
+		rec.RunID,
+	}, nil
+}
+
+func statsCSVRow(rec *FileRecord) []string {
+	stats, _ := json.Marshal(rec.Stats)
+	return []string{rec.Time.Format(time.RFC3339Nano), labelsCSV(rec.Labels), string(stats), rec.RunID}
+}
+
+func labelsCSV(ls map[string]string) string {
+	d, _ := json.Marshal(ls)
+	return string(d)
+}
+
+func timeCSV(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// segmentWriter writes lines to rotating segments <dir>/<name>_<seq>.<format>[.gz]
+type segmentWriter struct {
+	dir      string
+	name     string
+	format   FileFormat
+	gzip     bool
+	maxBytes int64
+	seq      int
+	written  int64
+	file     *os.File
+	gz       *gzip.Writer
+	buf      *bufio.Writer
+	csv      *csv.Writer
+}
+
+func newSegmentWriter(dir, name string, format FileFormat, gz bool, maxBytes int64) *segmentWriter {
+	return &segmentWriter{dir: dir, name: name, format: format, gzip: gz, maxBytes: maxBytes}
+}
+
+// Write implements io.Writer counting uncompressed bytes of the current segment
+func (m *segmentWriter) Write(p []byte) (int, error) {
+	n, err := m.buf.Write(p)
+	m.written += int64(n)
+	return n, err
+}
+
+// open opens the next free segment, segments of previous runs in the same dir are never overwritten
+func (m *segmentWriter) open() error {
+	ext := "." + string(m.format)
+	if m.gzip {
+		ext += ".gz"
+	}
+	for {
+		m.seq++
+		f, err := os.OpenFile(
+			filepath.Join(m.dir, fmt.Sprintf("%s_%06d%s", m.name, m.seq, ext)),
+			os.O_CREATE|os.O_EXCL|os.O_WRONLY,
+			0o644,
+		)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		m.file = f
+		break
+	}
+	var w io.Writer = m.file
+	if m.gzip {
+		m.gz = gzip.NewWriter(m.file)
+		w = m.gz
+	}
+	m.buf = bufio.NewWriter(w)
+	m.written = 0
+	m.csv = csv.NewWriter(m)
+	return nil
+}
+
+// next rotates the segment if it's full, or opens the first one
+func (m *segmentWriter) next() (bool, error) {
+	if m.file != nil && m.written < m.maxBytes {
+		return false, nil
+	}
+	if err := m.Close(); err != nil {
+		return false, err
+	}
+	return true, m.open()
+}
+
+// WriteJSON writes a value as one JSON line
+func (m *segmentWriter) WriteJSON(v interface{}) error {
+	if _, err := m.next(); err != nil {
+		return err
+	}
+	d, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = m.Write(append(d, '\n'))
+	return err
+}
+
+// WriteCSV writes a CSV row, header is written at the start of every segment
+func (m *segmentWriter) WriteCSV(header []string, row []string) error {
+	opened, err := m.next()
+	if err != nil {
+		return err
+	}
+	if opened {
+		if err := m.csv.Write(header); err != nil {
+			return err
+		}
+	}
+	if err := m.csv.Write(row); err != nil {
+		return err
+	}
+	m.csv.Flush()
+	return m.csv.Error()
+}
+
+// Close flushes and closes the current segment
+func (m *segmentWriter) Close() error {
+	if m.file == nil {
+		return nil
+	}
+	var errs []error
+	errs = append(errs, m.buf.Flush())
+	if m.gz != nil {
+		errs = append(errs, m.gz.Close())
+	}
+	errs = append(errs, m.file.Close())
+	m.file, m.gz, m.buf, m.csv = nil, nil, nil, nil
+	return errors.Join(errs...)
+}
+
+/* Reading file sink segments */
+
+// openSegment opens a segment for reading, gzip segments are decompressed
+func openSegment(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &gzipReadCloser{Reader: gz, f: f}, nil
+}
+
+type gzipReadCloser struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (m *gzipReadCloser) Close() error {
+	return errors.Join(m.Reader.Close(), m.f.Close())
+}
+
+// segmentFormat returns the format of a segment from its name
+func segmentFormat(path string) (FileFormat, error) {
+	switch filepath.Ext(strings.TrimSuffix(path, ".gz")) {
+	case ".jsonl":
+		return FileFormatJSONL, nil
+	case ".csv":
+		return FileFormatCSV, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrFileSinkBadSegment, path)
+	}
+}
+
+// ReadFileRecords reads all the file sink segments in dir in order of writing and calls fn for every record
+func ReadFileRecords(dir string, fn func(rec *FileRecord) error) error {
+	paths := make([]string, 0)
+	for _, pattern := range []string{"*.jsonl", "*.jsonl.gz", "*.csv", "*.csv.gz"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return err
+		}
+		paths = append(paths, matches...)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := readSegment(path, fn); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func readSegment(path string, fn func(rec *FileRecord) error) error {
+	format, err := segmentFormat(path)
+	if err != nil {
+		return err
+	}
+	r, err := openSegment(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if format == FileFormatJSONL {
+		dec := json.NewDecoder(r)
+		for {
+			rec := &FileRecord{}
+			if err := dec.Decode(rec); err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return err
+			}
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+	}
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rec, err := parseCSVRecord(header, row)
+		if err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
+func parseCSVRecord(header, row []string) (*FileRecord, error) {
+	col := make(map[string]string, len(header))
+	for i, h := range header {
+		col[h] = row[i]
+	}
+	ts, err := time.Parse(time.RFC3339Nano, col["time"])
+	if err != nil {
+		return nil, err
+	}
+	rec := &FileRecord{Time: ts, RunID: col["run_id"]}
+	if err := json.Unmarshal([]byte(col["labels"]), &rec.Labels); err != nil {
+		return nil, err
+	}
+	if _, ok := col["stats"]; ok {
+		rec.Type = FileRecordStats
+		return rec, json.Unmarshal([]byte(col["stats"]), &rec.Stats)
+	}
+	rec.Type = FileRecordResponse
+	d, err := strconv.ParseInt(col["duration"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	res := &Response{
+		Group:      col["group"],
+		Duration:   time.Duration(d),
+		Failed:     col["failed"] == "true",
+		Timeout:    col["timeout"] == "true",
+		StatusCode: col["status_code"],
+		Path:       col["path"],
+		Error:      col["error"],
+	}
+	for _, t := range []struct {
+		col string
+		dst **time.Time
+	}{{"started_at", &res.StartedAt}, {"finished_at", &res.FinishedAt}, {"intended_at", &res.IntendedAt}} {
+		if col[t.col] == "" {
+			continue
+		}
+		v, err := time.Parse(time.RFC3339Nano, col[t.col])
+		if err != nil {
+			return nil, err
+		}
+		*t.dst = &v
+	}
+	if col["segment"] != "" {
+		if res.Segment, err = strconv.Atoi(col["segment"]); err != nil {
+			return nil, err
+		}
+	}
+	if col["corrected_duration"] != "" {
+		cd, err := strconv.ParseInt(col["corrected_duration"], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		res.CorrectedDuration = time.Duration(cd)
+	}
+	if col["data"] != "" {
+		if err := json.Unmarshal([]byte(col["data"]), &res.Data); err != nil {
+			return nil, err
+		}
+	}
+	rec.Response = res
+	return rec, nil
+}
+
+// FileRun is data of one generator read from file sink segments or queried from Loki
+type FileRun struct {
+	Name string
+	// RunID is an id of the file sink that wrote the run, empty for Loki runs and segments written without it
+	RunID     string
+	Labels    map[string]string
+	Responses []*Response
+	Stats     []map[string]interface{}
+}
+
+// ReadFileRuns reads all the file sink segments in dir grouped by run id and generator name,
+// runs of different file sinks written to the same dir are never merged
+func ReadFileRuns(dir string) ([]*FileRun, error) {
+	type runKey struct{ id, name string }
+	runs := make([]*FileRun, 0)
+	byKey := make(map[runKey]*FileRun)
+	err := ReadFileRecords(dir, func(rec *FileRecord) error {
+		key := runKey{id: rec.RunID, name: rec.Labels["gen_name"]}
+		run, ok := byKey[key]
+		if !ok {
+			run = &FileRun{Name: key.name, RunID: key.id, Labels: rec.Labels}
+			byKey[key] = run
+			runs = append(runs, run)
+		}
+		if rec.Type == FileRecordStats {
+			run.Stats = append(run.Stats, rec.Stats)
+		} else {
+			run.Responses = append(run.Responses, rec.Response)
+		}
+		return nil
+	})
+	return runs, err
+}
+
+// Latencies returns latency histograms of the recorded responses
+func (m *FileRun) Latencies() *Latencies {
+	l := NewLatencies()
+	for _, r := range m.Responses {
+		l.Record(r)
+	}
+	return l
+}
+
+// Report creates a summary of the run, counters are taken from the last stats snapshot if present,
+// latencies and errors are calculated from the recorded responses
+func (m *FileRun) Report(percentiles []float64) *GeneratorReport {
+	l := m.Latencies()
+	r := &GeneratorReport{
+		Name:      m.Name,
+		Requests:  l.All.Count(),
+		Latency:   newLatencyReport(l.All, percentiles),
+		Groups:    make([]*GroupReport, 0),
+		Segments:  make([]*SegmentReport, 0),
+		TopErrors: make([]*ErrorReport, 0),
+	}
+	if l.SendLateness.Count() > 0 {
+		r.CorrectedLatency = newLatencyReport(l.Corrected, percentiles)
+		r.SendLateness = newLatencyReport(l.SendLateness, percentiles)
+	}
+	errs := make(map[string]int)
+	for _, res := range m.Responses {
+		switch {
+		case res.Timeout:
+			r.Timeouts++
+		case res.Failed:
+			r.Failed++
+		default:
+			r.Success++
+		}
+		if res.Error != "" {
+			errs[res.Error]++
+		}
+	}
+	if len(m.Stats) > 0 {
+		// the same generator can run on several nodes, counters are summed from the last snapshot of every node
+		lastByNode := make(map[interface{}]map[string]interface{})
+		for _, s := range m.Stats {
+			lastByNode[s["node_id"]] = s
+		}
+		var requests, failed, timeouts, dropped int64
+		for _, last := range lastByNode {
+			if latency, ok := last["latency"].(map[string]interface{}); ok {
+				requests += statsInt(latency["count"])
+			}
+			failed += statsInt(last["failed"])
+			timeouts += statsInt(last["callTimeout"])
+			dropped += statsInt(last["dropped"])
+			runFailed, _ := last["run_failed"].(bool)
+			r.RunFailed = r.RunFailed || runFailed
+		}
+		r.Requests = requests
+		r.Success = max(0, requests-failed)
+		r.Failed = failed - timeouts
+		r.Timeouts = timeouts
+		r.Dropped = dropped
+	}
+	for _, name := range l.Groups() {
+		r.Groups = append(r.Groups, newGroupReport(l, name, percentiles))
+	}
+	for e, c := range errs {
+		r.TopErrors = append(r.TopErrors, &ErrorReport{Error: e, Count: c})
+	}
+	sort.Slice(r.TopErrors, func(i, j int) bool {
+		if r.TopErrors[i].Count == r.TopErrors[j].Count {
+			return r.TopErrors[i].Error < r.TopErrors[j].Error
+		}
+		return r.TopErrors[i].Count > r.TopErrors[j].Count
+	})
+	if len(r.TopErrors) > DefaultReportTopErrors {
+		r.TopErrors = r.TopErrors[:DefaultReportTopErrors]
+	}
+	return r
+}
+
+// NewFileReport creates a report of runs read from file sink segments
+func NewFileReport(name string, percentiles []float64, runs ...*FileRun) *Report {
+	r := &Report{
+		Name:        name,
+		GeneratedAt: time.Now(),
+		Generators:  make([]*GeneratorReport, 0),
+	}
+	for _, run := range runs {
+		r.Generators = append(r.Generators, run.Report(percentiles))
+	}
+	return r
+}
+
+// statsInt converts a stats number decoded from JSON
+func statsInt(v interface{}) int64 {
+	switch n := v.(type) {
+	case float64:
+		return int64(n)
+	case int64:
+		return n
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,176 @@
+package wasp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSmokeFileSink(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		name   string
+		format FileFormat
+		gzip   bool
+	}{
+		{"jsonl", FileFormatJSONL, false},
+		{"jsonl gzip", FileFormatJSONL, true},
+		{"csv", FileFormatCSV, false},
+		{"csv gzip", FileFormatCSV, true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			dir := t.TempDir()
+			sink, err := NewFileSink(&FileSinkConfig{
+				Dir:             dir,
+				Format:          tc.format,
+				Gzip:            tc.gzip,
+				MaxSegmentBytes: 2048,
+			})
+			require.NoError(t, err)
+			gen, err := NewGenerator(&Config{
+				T:                 t,
+				GenName:           "file",
+				LoadType:          RPS,
+				Schedule:          Plain(50, 1*time.Second),
+				StatsPollInterval: 200 * time.Millisecond,
+				Labels:            map[string]string{"branch": "main"},
+				SamplerConfig:     &SamplerConfig{SuccessfulCallResultRecordRatio: 0},
+				Sinks:             []*SinkConfig{{Sink: sink}},
+				Gun: NewMockGun(&MockGunConfig{
+					FailRatio: 50,
+					CallSleep: 10 * time.Millisecond,
+				}),
+			})
+			require.NoError(t, err)
+			gen.Run(true)
+
+			ext := "." + string(tc.format)
+			if tc.gzip {
+				ext += ".gz"
+			}
+			segments, err := filepath.Glob(filepath.Join(dir, "file_responses_*"+ext))
+			require.NoError(t, err)
+			require.Greater(t, len(segments), 1)
+
+			runs, err := ReadFileRuns(dir)
+			require.NoError(t, err)
+			require.Len(t, runs, 1)
+			run := runs[0]
+			require.Equal(t, "file", run.Name)
+			require.Equal(t, "main", run.Labels["branch"])
+			require.Equal(t, t.Name(), run.Labels["go_test_name"])
+			// only failed responses are sampled
+			require.Equal(t, gen.Stats().SamplesRecorded.Load(), int64(len(run.Responses)))
+			for _, r := range run.Responses {
+				require.True(t, r.Failed)
+				require.Equal(t, "error", r.Error)
+				require.Equal(t, "failedCallData", r.Data)
+				require.NotNil(t, r.FinishedAt)
+				require.GreaterOrEqual(t, r.Duration, 10*time.Millisecond)
+			}
+			require.GreaterOrEqual(t, len(run.Stats), 5)
+
+			expected := gen.Report()
+			r := run.Report(DefaultPercentiles)
+			require.Equal(t, expected.Requests, r.Requests)
+			require.Equal(t, expected.Success, r.Success)
+			require.Equal(t, expected.Failed, r.Failed)
+			require.Equal(t, expected.TopErrors, r.TopErrors)
+			require.Equal(t, int64(len(run.Responses)), r.Latency.Count)
+		})
+	}
+	t.Run("segments of previous runs are kept", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		runIDs := make([]string, 0)
+		for i := 0; i < 2; i++ {
+			sink, err := NewFileSink(&FileSinkConfig{Dir: dir})
+			require.NoError(t, err)
+			runIDs = append(runIDs, sink.RunID())
+			gen, err := NewGenerator(&Config{
+				T:        t,
+				LoadType: RPS,
+				Schedule: Plain(10, 200*time.Millisecond),
+				Sinks:    []*SinkConfig{{Sink: sink}},
+				Gun:      NewMockGun(&MockGunConfig{}),
+			})
+			require.NoError(t, err)
+			gen.Run(true)
+		}
+		for _, name := range []string{DefaultGenName + "_stats_000001.jsonl", DefaultGenName + "_stats_000002.jsonl"} {
+			_, err := os.Stat(filepath.Join(dir, name))
+			require.NoError(t, err)
+		}
+		// runs of the same generator are not merged
+		runs, err := ReadFileRuns(dir)
+		require.NoError(t, err)
+		require.Len(t, runs, 2)
+		require.NotEqual(t, runIDs[0], runIDs[1])
+		for i, run := range runs {
+			require.Equal(t, DefaultGenName, run.Name)
+			require.Equal(t, runIDs[i], run.RunID)
+			require.NotEmpty(t, run.Responses)
+		}
+		_, err = Compare(runs, runs[:1], nil)
+		require.ErrorIs(t, err, ErrCompareDuplicate)
+	})
+	t.Run("generators sharing a sink close only their own segments", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		sink, err := NewFileSink(&FileSinkConfig{Dir: dir, Gzip: true})
+		require.NoError(t, err)
+		gens := make([]*Generator, 0)
+		for _, gc := range []struct {
+			name string
+			dur  time.Duration
+		}{{"short", 300 * time.Millisecond}, {"long", 1 * time.Second}} {
+			gen, err := NewGenerator(&Config{
+				T:        t,
+				GenName:  gc.name,
+				LoadType: RPS,
+				Schedule: Plain(20, gc.dur),
+				Sinks:    []*SinkConfig{{Sink: sink}},
+				Gun:      NewMockGun(&MockGunConfig{}),
+			})
+			require.NoError(t, err)
+			gen.Run(false)
+			gens = append(gens, gen)
+		}
+		for _, gen := range gens {
+			gen.Wait()
+		}
+		// the long generator kept writing to its first segment after the short one finished
+		segments, err := filepath.Glob(filepath.Join(dir, "long_responses_*.jsonl.gz"))
+		require.NoError(t, err)
+		require.Len(t, segments, 1)
+		runs, err := ReadFileRuns(dir)
+		require.NoError(t, err)
+		require.Len(t, runs, 2)
+		for _, run := range runs {
+			gen := gens[0]
+			if run.Name == "long" {
+				gen = gens[1]
+			}
+			require.Equal(t, gen.Stats().SamplesRecorded.Load(), int64(len(run.Responses)))
+		}
+	})
+	t.Run("validation", func(t *testing.T) {
+		t.Parallel()
+		for _, tc := range []struct {
+			cfg *FileSinkConfig
+			err error
+		}{
+			{&FileSinkConfig{}, ErrFileSinkNoDir},
+			{&FileSinkConfig{Dir: t.TempDir(), Format: "parquet"}, ErrFileSinkFormat},
+			{&FileSinkConfig{Dir: t.TempDir(), MaxSegmentBytes: -1}, ErrFileSinkSegment},
+		} {
+			_, err := NewFileSink(tc.cfg)
+			require.ErrorIs(t, err, tc.err)
+		}
+	})
+}
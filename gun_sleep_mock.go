@@ -1,7 +1,9 @@
 package wasp
 
 import (
+	"context"
 	"math/rand"
+	"sync/atomic"
 	"time"
 )
 
@@ -54,14 +56,34 @@ func (m *MockGun) Call(l *Generator) *Response {
 	return &Response{Data: "successCallData"}
 }
 
+// MockGunCtx is a context-aware mock gun, call sleeps for CallSleep or until context is cancelled
+type MockGunCtx struct {
+	cfg       *MockGunConfig
+	Cancelled atomic.Int64
+}
+
+// NewMockGunCtx create a context-aware mock gun
+func NewMockGunCtx(cfg *MockGunConfig) *MockGunCtx {
+	return &MockGunCtx{cfg: cfg}
+}
+
+// Call implements example context-aware gun call
+func (m *MockGunCtx) Call(ctx context.Context, _ *Generator) *Response {
+	select {
+	case <-ctx.Done():
+		m.Cancelled.Add(1)
+		return nil
+	case <-time.After(m.cfg.CallSleep):
+		return &Response{Data: "successCallData"}
+	}
+}
+
 func convertResponsesData(g *Generator) ([]string, []*Response, []*Response) {
-	g.responsesData.okDataMu.Lock()
-	defer g.responsesData.okDataMu.Unlock()
-	g.responsesData.failResponsesMu.Lock()
-	defer g.responsesData.failResponsesMu.Unlock()
+	data := g.GetData()
 	ok := make([]string, 0)
-	for _, d := range g.responsesData.OKData.Data {
+	data.OKData().Range(func(d any) bool {
 		ok = append(ok, d.(string))
-	}
-	return ok, g.responsesData.OKResponses.Data, g.responsesData.FailResponses.Data
+		return true
+	})
+	return ok, data.OKResponses().Snapshot(), data.FailResponses().Snapshot()
 }
@@ -0,0 +1,297 @@
+package wasp
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+/* In-process HDR latency histograms */
+
+const (
+	// DefaultHistogramMaxLatency is the highest latency tracked precisely, higher values are recorded as this value
+	DefaultHistogramMaxLatency = 1 * time.Hour
+	// DefaultHistogramSignificantFigures is the precision of recorded values
+	DefaultHistogramSignificantFigures = 3
+)
+
+var (
+	// DefaultPercentiles are percentiles exposed in stats
+	DefaultPercentiles = []float64{50, 90, 95, 99}
+
+	ErrInvalidPercentile = errors.New("percentiles must be > 0 and <= 100")
+)
+
+// latencyShardBuffer is the amount of values a histogram shard buffers before it moves them to the histogram
+const latencyShardBuffer = 256
+
+// latencyShard buffers recorded values, every shard has its own lock
+type latencyShard struct {
+	mu     sync.Mutex
+	values []int64
+	_      cacheLinePad
+}
+
+// LatencyHistogram is a concurrent-safe HDR histogram of call durations with microsecond resolution.
+// Values are buffered in shards picked per P and moved to the histogram in batches, so concurrent calls rarely wait for each other,
+// all the buffered values are moved before the histogram is read
+type LatencyHistogram struct {
+	mu     *sync.Mutex
+	h      *hdrhistogram.Histogram
+	shards []latencyShard
+}
+
+// NewLatencyHistogram creates a new latency histogram
+func NewLatencyHistogram() *LatencyHistogram {
+	return &LatencyHistogram{
+		mu:     &sync.Mutex{},
+		h:      hdrhistogram.New(1, DefaultHistogramMaxLatency.Microseconds(), DefaultHistogramSignificantFigures),
+		shards: make([]latencyShard, shardCount()),
+	}
+}
+
+// Record records a call duration
+func (m *LatencyHistogram) Record(d time.Duration) {
+	v := min(max(d.Microseconds(), 1), DefaultHistogramMaxLatency.Microseconds())
+	if len(m.shards) == 1 {
+		// with one P there is no contention to avoid, buffering only adds work
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		_ = m.h.RecordValue(v)
+		return
+	}
+	s := &m.shards[shardIndex(len(m.shards))]
+	s.mu.Lock()
+	if s.values == nil {
+		s.values = make([]int64, 0, latencyShardBuffer)
+	}
+	s.values = append(s.values, v)
+	if len(s.values) < latencyShardBuffer {
+		s.mu.Unlock()
+		return
+	}
+	var batch [latencyShardBuffer]int64
+	n := copy(batch[:], s.values)
+	s.values = s.values[:0]
+	s.mu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, v := range batch[:n] {
+		_ = m.h.RecordValue(v)
+	}
+}
+
+// flush moves buffered values of all the shards to the histogram, must be called under m.mu
+func (m *LatencyHistogram) flush() {
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.Lock()
+		for _, v := range s.values {
+			_ = m.h.RecordValue(v)
+		}
+		s.values = s.values[:0]
+		s.mu.Unlock()
+	}
+}
+
+// Merge adds all the values from another histogram
+func (m *LatencyHistogram) Merge(other *LatencyHistogram) {
+	other.mu.Lock()
+	other.flush()
+	snapshot := hdrhistogram.Import(other.h.Export())
+	other.mu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flush()
+	m.h.Merge(snapshot)
+}
+
+// mergeTo adds all the values to another histogram without copying them
+func (m *LatencyHistogram) mergeTo(h *hdrhistogram.Histogram) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flush()
+	h.Merge(m.h)
+}
+
+// Percentile returns p-th percentile, p is in (0, 100]
+func (m *LatencyHistogram) Percentile(p float64) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flush()
+	return time.Duration(m.h.ValueAtPercentile(p)) * time.Microsecond
+}
+
+// Count returns the amount of recorded values
+func (m *LatencyHistogram) Count() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flush()
+	return m.h.TotalCount()
+}
+
+// Min returns the lowest recorded value
+func (m *LatencyHistogram) Min() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flush()
+	return time.Duration(m.h.Min()) * time.Microsecond
+}
+
+// Max returns the highest recorded value
+func (m *LatencyHistogram) Max() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flush()
+	return time.Duration(m.h.Max()) * time.Microsecond
+}
+
+// Mean returns the mean of recorded values
+func (m *LatencyHistogram) Mean() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flush()
+	return time.Duration(m.h.Mean() * float64(time.Microsecond))
+}
+
+// Summary returns count, min, max, mean and percentiles in nanoseconds, keys are "p50", "p99.9", etc.
+func (m *LatencyHistogram) Summary(percentiles []float64) map[string]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flush()
+	res := map[string]int64{
+		"count": m.h.TotalCount(),
+		"min":   m.h.Min() * int64(time.Microsecond),
+		"max":   m.h.Max() * int64(time.Microsecond),
+		"mean":  int64(m.h.Mean() * float64(time.Microsecond)),
+	}
+	for _, p := range percentiles {
+		res[PercentileKey(p)] = m.h.ValueAtPercentile(p) * int64(time.Microsecond)
+	}
+	return res
+}
+
+// PercentileKey formats a percentile as a stats key, ex.: p99, p99.9
+func PercentileKey(p float64) string {
+	return "p" + strconv.FormatFloat(p, 'f', -1, 64)
+}
+
+// ResultCounts are counts of call results of a Response.Group
+type ResultCounts struct {
+	Success  atomic.Int64 `json:"success"`
+	Failed   atomic.Int64 `json:"failed"`
+	Timeouts atomic.Int64 `json:"timeouts"`
+}
+
+// record counts a response, timed out responses are not counted as failed
+func (m *ResultCounts) record(res *Response) {
+	switch {
+	case res.Timeout:
+		m.Timeouts.Add(1)
+	case res.Failed:
+		m.Failed.Add(1)
+	default:
+		m.Success.Add(1)
+	}
+}
+
+// latencyGroup is a histogram and result counts of a Response.Group
+type latencyGroup struct {
+	latency *LatencyHistogram
+	counts  *ResultCounts
+}
+
+// Latencies are latency histograms of all the calls and of every Response.Group
+type Latencies struct {
+	All *LatencyHistogram
+	// Corrected are durations of all the calls from their intended send time, see Response.Corrected
+	Corrected *LatencyHistogram
+	// SendLateness is how late RPS calls were sent comparing to the schedule, high values mean the generator is the bottleneck
+	SendLateness *LatencyHistogram
+	// groups are *latencyGroup by name, they are created once and read by every call without a lock
+	groups *sync.Map
+}
+
+// NewLatencies creates new latency histograms
+func NewLatencies() *Latencies {
+	return &Latencies{
+		All:          NewLatencyHistogram(),
+		Corrected:    NewLatencyHistogram(),
+		SendLateness: NewLatencyHistogram(),
+		groups:       &sync.Map{},
+	}
+}
+
+// Record records response duration in the overall histogram and in the histogram of its group,
+// corrected duration and send lateness are recorded for all the calls
+func (m *Latencies) Record(res *Response) {
+	m.All.Record(res.Duration)
+	m.Corrected.Record(res.Corrected())
+	if res.CorrectedDuration > 0 {
+		m.SendLateness.Record(res.SendLateness())
+	}
+	if res.Group == "" {
+		return
+	}
+	g := m.group(res.Group)
+	g.latency.Record(res.Duration)
+	g.counts.record(res)
+}
+
+// group returns a group, creating it if needed
+func (m *Latencies) group(name string) *latencyGroup {
+	if g, ok := m.groups.Load(name); ok {
+		return g.(*latencyGroup)
+	}
+	g, _ := m.groups.LoadOrStore(name, &latencyGroup{latency: NewLatencyHistogram(), counts: &ResultCounts{}})
+	return g.(*latencyGroup)
+}
+
+// Group returns a histogram of a response group, creating it if needed
+func (m *Latencies) Group(name string) *LatencyHistogram {
+	return m.group(name).latency
+}
+
+// GroupCounts returns result counts of a response group, creating them if needed
+func (m *Latencies) GroupCounts(name string) *ResultCounts {
+	return m.group(name).counts
+}
+
+// Groups returns sorted names of all the recorded groups
+func (m *Latencies) Groups() []string {
+	names := make([]string, 0)
+	m.groups.Range(func(name, _ any) bool {
+		names = append(names, name.(string))
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
+// GroupsSummary returns a summary of every group histogram, see LatencyHistogram.Summary
+func (m *Latencies) GroupsSummary(percentiles []float64) map[string]map[string]int64 {
+	res := make(map[string]map[string]int64)
+	for _, name := range m.Groups() {
+		res[name] = m.Group(name).Summary(percentiles)
+	}
+	return res
+}
+
+// Merge adds all the values of other latencies, groups are merged by name
+func (m *Latencies) Merge(other *Latencies) {
+	m.All.Merge(other.All)
+	m.Corrected.Merge(other.Corrected)
+	m.SendLateness.Merge(other.SendLateness)
+	for _, name := range other.Groups() {
+		m.Group(name).Merge(other.Group(name))
+		c, oc := m.GroupCounts(name), other.GroupCounts(name)
+		c.Success.Add(oc.Success.Load())
+		c.Failed.Add(oc.Failed.Load())
+		c.Timeouts.Add(oc.Timeouts.Load())
+	}
+}
@@ -0,0 +1,96 @@
+package wasp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSmokeLatencyHistogram(t *testing.T) {
+	t.Parallel()
+	h := NewLatencyHistogram()
+	for i := 1; i <= 100; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+	require.Equal(t, int64(100), h.Count())
+	require.Equal(t, 1*time.Millisecond, h.Min())
+	require.InDelta(t, 100*time.Millisecond, h.Max(), float64(100*time.Microsecond))
+	require.InDelta(t, 50*time.Millisecond, h.Percentile(50), float64(50*time.Microsecond))
+	require.InDelta(t, 99*time.Millisecond, h.Percentile(99), float64(100*time.Microsecond))
+	require.InDelta(t, 50500*time.Microsecond, h.Mean(), float64(100*time.Microsecond))
+
+	other := NewLatencyHistogram()
+	other.Record(2 * time.Hour)
+	h.Merge(other)
+	require.Equal(t, int64(101), h.Count())
+	require.InDelta(t, DefaultHistogramMaxLatency, h.Max(), float64(time.Second))
+
+	s := h.Summary([]float64{50, 99.9})
+	require.Equal(t, int64(101), s["count"])
+	require.Contains(t, s, "p50")
+	require.Contains(t, s, "p99.9")
+}
+
+func TestSmokeLatenciesWithSampler(t *testing.T) {
+	t.Parallel()
+	gen, err := NewGenerator(&Config{
+		T:           t,
+		LoadType:    RPS,
+		Schedule:    Plain(100, 1*time.Second),
+		Percentiles: []float64{50, 99},
+		SamplerConfig: &SamplerConfig{
+			SuccessfulCallResultRecordRatio: 0,
+		},
+		Gun: NewMockGun(&MockGunConfig{
+			CallSleep: 20 * time.Millisecond,
+		}),
+	})
+	require.NoError(t, err)
+	_, failed := gen.Run(true)
+	require.Equal(t, false, failed)
+	stats := gen.Stats()
+	require.Equal(t, int64(0), stats.Success.Load())
+	require.GreaterOrEqual(t, stats.Latencies.All.Count(), int64(95))
+	require.GreaterOrEqual(t, stats.Latencies.All.Percentile(50), 20*time.Millisecond)
+	require.Less(t, stats.Latencies.All.Percentile(50), 100*time.Millisecond)
+	latency := gen.StatsJSON()["latency"].(map[string]int64)
+	require.Contains(t, latency, "p50")
+	require.Contains(t, latency, "p99")
+	require.NotContains(t, latency, "p95")
+}
+
+// groupVU sends responses of two call groups with different durations
+type groupVU struct {
+	*VUControl
+}
+
+func (m *groupVU) Clone(_ *Generator) VirtualUser { return &groupVU{VUControl: NewVUControl()} }
+func (m *groupVU) Setup(_ *Generator) error       { return nil }
+func (m *groupVU) Teardown(_ *Generator) error    { return nil }
+
+func (m *groupVU) Call(l *Generator) {
+	time.Sleep(30 * time.Millisecond)
+	l.ResponsesChan <- &Response{Group: "fast", Duration: 10 * time.Millisecond}
+	l.ResponsesChan <- &Response{Group: "slow", Duration: 20 * time.Millisecond}
+}
+
+func TestSmokeLatencyGroups(t *testing.T) {
+	t.Parallel()
+	gen, err := NewGenerator(&Config{
+		T:        t,
+		LoadType: VU,
+		Schedule: Plain(1, 1*time.Second),
+		VU:       &groupVU{VUControl: NewVUControl()},
+	})
+	require.NoError(t, err)
+	_, failed := gen.Run(true)
+	require.Equal(t, false, failed)
+	latencies := gen.Stats().Latencies
+	require.Equal(t, []string{"fast", "slow"}, latencies.Groups())
+	require.Equal(t, latencies.Group("fast").Count(), latencies.Group("slow").Count())
+	require.Equal(t, latencies.All.Count(), 2*latencies.Group("fast").Count())
+	require.InDelta(t, 10*time.Millisecond, latencies.Group("fast").Percentile(99), float64(100*time.Microsecond))
+	require.InDelta(t, 20*time.Millisecond, latencies.Group("slow").Percentile(99), float64(100*time.Microsecond))
+	require.Len(t, gen.StatsJSON()["latency_groups"], 2)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"errors"
@@ -24,6 +25,7 @@ import (
 type LokiLogWrapper struct {
 	MaxErrors int
 	errors    []error
+	errorsMu  *sync.Mutex
 	client    *LokiClient
 }
 
@@ -31,6 +33,7 @@ func NewLokiLogWrapper(maxErrors int) *LokiLogWrapper {
 	return &LokiLogWrapper{
 		MaxErrors: maxErrors,
 		errors:    make([]error, 0),
+		errorsMu:  &sync.Mutex{},
 	}
 }
 
@@ -38,7 +41,16 @@ func (m *LokiLogWrapper) SetClient(c *LokiClient) {
 	m.client = c
 }
 
+// exceeded returns true if there were more than MaxErrors errors, -1 ignores errors
+func (m *LokiLogWrapper) exceeded() bool {
+	m.errorsMu.Lock()
+	defer m.errorsMu.Unlock()
+	return m.MaxErrors != -1 && len(m.errors) > m.MaxErrors
+}
+
 func (m *LokiLogWrapper) Log(kvars ...interface{}) error {
+	m.errorsMu.Lock()
+	defer m.errorsMu.Unlock()
 	if len(m.errors) > m.MaxErrors {
 		return nil
 	}
@@ -64,12 +76,17 @@ func (m *LokiLogWrapper) Log(kvars ...interface{}) error {
 // LokiClient is a Loki/Promtail client wrapper
 type LokiClient struct {
 	logWrapper *LokiLogWrapper
+	// spool pushes entries itself instead of the promtail client if LokiConfig.Spool is set
+	spool *lokiSpool
 	lokiClient.Client
 }
 
 // Handle handles adding a new label set and a message to the batch
+// if spool is set, entries are spooled instead of failing when Loki is unavailable
 func (m *LokiClient) Handle(ls model.LabelSet, t time.Time, s string) error {
-	if m.logWrapper.MaxErrors != -1 && len(m.logWrapper.errors) > m.logWrapper.MaxErrors {
+	if m.spool == nil && m.logWrapper.exceeded() {
+		m.logWrapper.errorsMu.Lock()
+		defer m.logWrapper.errorsMu.Unlock()
 		return fmt.Errorf("can't send data to Loki, errors: %v", m.logWrapper.errors)
 	}
 	log.Trace().
@@ -77,7 +94,11 @@ func (m *LokiClient) Handle(ls model.LabelSet, t time.Time, s string) error {
 		Time("Time", t).
 		Str("Data", s).
 		Msg("Sending data to Loki")
-	m.Client.Chan() <- lokiAPI.Entry{Labels: ls, Entry: lokiProto.Entry{Timestamp: t, Line: s}}
+	e := lokiAPI.Entry{Labels: ls, Entry: lokiProto.Entry{Timestamp: t, Line: s}}
+	if m.spool != nil {
+		return m.spool.handle(e)
+	}
+	m.Client.Chan() <- e
 	return nil
 }
 
@@ -95,6 +116,25 @@ func (m *LokiClient) StopNow() {
 	m.Client.StopNow()
 }
 
+// Close stops the client, if spool is set the last batch is pushed and the spool is replayed,
+// for at most LokiSpoolConfig.FlushTimeout
+func (m *LokiClient) Close() error {
+	var err error
+	if m.spool != nil {
+		err = m.spool.Close()
+	}
+	m.StopNow()
+	return err
+}
+
+// SpoolStats returns spool counters, nil if spool is not set
+func (m *LokiClient) SpoolStats() *LokiSpoolStats {
+	if m.spool == nil {
+		return nil
+	}
+	return m.spool.stats
+}
+
 // LokiConfig is simplified subset of a Promtail client configuration
 type LokiConfig struct {
 	// URL url to Loki endpoint
@@ -127,6 +167,8 @@ type LokiConfig struct {
 	MaxStreams              int
 	MaxLineSize             int
 	MaxLineSizeTruncate     bool
+	// Spool persists entries on disk when Loki is unavailable and replays them when it recovers
+	Spool *LokiSpoolConfig
 }
 
 // DefaultLokiConfig is reasonable common settings for Loki batches
@@ -151,6 +193,9 @@ func NewEnvLokiConfig() *LokiConfig {
 	d.URL = os.Getenv("LOKI_URL")
 	d.Token = os.Getenv("LOKI_TOKEN")
 	d.BasicAuth = os.Getenv("LOKI_BASIC_AUTH")
+	if dir := os.Getenv("LOKI_SPOOL_DIR"); dir != "" {
+		d.Spool = &LokiSpoolConfig{Dir: dir}
+	}
 	return d
 }
 
@@ -174,12 +219,17 @@ func NewLokiConfig(endpoint *string, tenant *string, basicAuth *string, token *s
 
 // NewLokiClient creates a new Promtail client
 func NewLokiClient(extCfg *LokiConfig) (*LokiClient, error) {
-	_, err := http.Get(extCfg.URL)
-	if err != nil {
-		return nil, err
+	if extCfg.Spool != nil {
+		if err := extCfg.Spool.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	_, unavailable := http.Get(extCfg.URL)
+	if unavailable != nil && extCfg.Spool == nil {
+		return nil, unavailable
 	}
 	serverURL := dskit.URLValue{}
-	err = serverURL.Set(extCfg.URL)
+	err := serverURL.Set(extCfg.URL)
 	if err != nil {
 		return nil, err
 	}
@@ -221,6 +271,13 @@ func NewLokiClient(extCfg *LokiConfig) (*LokiClient, error) {
 		logWrapper: ll,
 		Client:     c,
 	}
+	if extCfg.Spool != nil {
+		lc.spool, err = newLokiSpool(extCfg, cfg.Client, unavailable == nil)
+		if err != nil {
+			c.StopNow()
+			return nil, err
+		}
+	}
 	ll.SetClient(lc)
 	return lc, nil
 }
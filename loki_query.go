@@ -0,0 +1,380 @@
+package wasp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+/* Loki query client to read responses and stats back for post-run analysis */
+
+const (
+	// DefaultLokiQueryLimit is Loki default max_entries_limit_per_query
+	DefaultLokiQueryLimit = 5000
+	// DefaultLokiQuerySplit is a time range of one query_range request
+	DefaultLokiQuerySplit = 1 * time.Hour
+	lokiPushPath          = "/loki/api/v1/push"
+	lokiQueryRangePath    = "/loki/api/v1/query_range"
+)
+
+var (
+	ErrLokiQueryNoURL = errors.New("loki url must be set")
+	ErrLokiQueryRange = errors.New("loki query start must be set and before end")
+	ErrLokiQueryLimit = errors.New("loki query limit and split must be >= 0")
+	// ErrLokiQueryTimestamp is returned if one timestamp has more entries than Loki allows to query at once
+	ErrLokiQueryTimestamp = errors.New("loki has more entries with the same timestamp than its entries limit")
+)
+
+// LokiQuery selects streams of generators by labels and time range
+type LokiQuery struct {
+	// TestName is go_test_name label
+	TestName string
+	// GenName is gen_name label, all the generators of the test if empty
+	GenName string
+	// Labels are additional labels, ex.: branch, commit
+	Labels map[string]string
+	Start  time.Time
+	// End is now if empty
+	End time.Time
+	// Limit is the amount of entries of one request, it is decreased if the server limit is lower, default is DefaultLokiQueryLimit
+	Limit int
+	// Split is a time range of one request, it is decreased if the server limit is lower, default is DefaultLokiQuerySplit
+	Split time.Duration
+}
+
+func (m *LokiQuery) Validate() error {
+	if m.End.IsZero() {
+		m.End = time.Now()
+	}
+	if m.Start.IsZero() || !m.Start.Before(m.End) {
+		return ErrLokiQueryRange
+	}
+	if m.Limit < 0 || m.Split < 0 {
+		return ErrLokiQueryLimit
+	}
+	if m.Limit == 0 {
+		m.Limit = DefaultLokiQueryLimit
+	}
+	if m.Split == 0 {
+		m.Split = DefaultLokiQuerySplit
+	}
+	return nil
+}
+
+// selector returns LogQL stream selector for a data type, labels are sorted
+func (m *LokiQuery) selector(dataType string) string {
+	ls := map[string]string{"test_data_type": dataType}
+	for k, v := range m.Labels {
+		ls[k] = v
+	}
+	if m.TestName != "" {
+		ls["go_test_name"] = m.TestName
+	}
+	if m.GenName != "" {
+		ls["gen_name"] = m.GenName
+	}
+	names := make([]string, 0, len(ls))
+	for k := range ls {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	matchers := make([]string, 0, len(names))
+	for _, k := range names {
+		matchers = append(matchers, fmt.Sprintf("%s=%s", k, strconv.Quote(ls[k])))
+	}
+	return "{" + strings.Join(matchers, ", ") + "}"
+}
+
+// LokiEntry is one queried log line with its stream labels
+type LokiEntry struct {
+	Labels map[string]string
+	Time   time.Time
+	Line   string
+}
+
+// lokiQueryRangeResponse is a query_range response of a streams query
+type lokiQueryRangeResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// LokiQueryClient reads generator data back from Loki
+type LokiQueryClient struct {
+	cfg     *LokiConfig
+	baseURL string
+	client  *http.Client
+}
+
+// NewLokiQueryClient creates a query client from the same config the generator pushes with,
+// query URL is the push URL without /loki/api/v1/push
+func NewLokiQueryClient(cfg *LokiConfig) (*LokiQueryClient, error) {
+	if cfg.URL == "" {
+		return nil, ErrLokiQueryNoURL
+	}
+	if cfg.BasicAuth != "" && len(strings.Split(cfg.BasicAuth, ":")) != 2 {
+		return nil, errors.New("basic auth should be in login:password format")
+	}
+	return &LokiQueryClient{
+		cfg:     cfg,
+		baseURL: strings.TrimSuffix(strings.TrimSuffix(cfg.URL, "/"), lokiPushPath),
+		client:  &http.Client{Timeout: cfg.Timeout},
+	}, nil
+}
+
+// QueryRange runs a LogQL streams query from start to end, fn is called for every entry in time order.
+// Requests are paginated by Limit and split by Split, both are decreased if Loki rejects them because of its limits.
+// A page full of entries with one timestamp is fetched again with a larger limit, ErrLokiQueryTimestamp is returned if Loki rejects it
+func (m *LokiQueryClient) QueryRange(ctx context.Context, query string, start, end time.Time, limit int, split time.Duration, fn func(e *LokiEntry) error) error {
+	for from := start; from.Before(end); {
+		to := from.Add(split)
+		if to.After(end) {
+			to = end
+		}
+		entries, err := m.queryRange(ctx, query, from, to, limit)
+		var le *lokiLimitError
+		switch {
+		case errors.As(err, &le) && le.entries && limit > 1:
+			limit /= 2
+			log.Debug().Int("Limit", limit).Msg("Loki entries limit exceeded, decreasing query limit")
+			continue
+		case errors.As(err, &le) && !le.entries && split > time.Millisecond:
+			split /= 2
+			log.Debug().Dur("Split", split).Msg("Loki query range limit exceeded, decreasing query range")
+			continue
+		case err != nil:
+			return err
+		}
+		if len(entries) < limit {
+			for _, e := range entries {
+				if err := fn(e); err != nil {
+					return err
+				}
+			}
+			from = to
+			continue
+		}
+		// full page, entries with the last timestamp can continue on the next page, they are fetched again
+		last := entries[len(entries)-1].Time
+		if entries[0].Time.Equal(last) {
+			entries, err = m.queryTimestamp(ctx, query, last, limit)
+			if err != nil {
+				return err
+			}
+			last = last.Add(time.Nanosecond)
+		}
+		for _, e := range entries {
+			if !e.Time.Before(last) {
+				break
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		from = last
+	}
+	return nil
+}
+
+// queryTimestamp requests all the entries of one timestamp doubling the limit until the page is not full
+func (m *LokiQueryClient) queryTimestamp(ctx context.Context, query string, ts time.Time, limit int) ([]*LokiEntry, error) {
+	for {
+		limit *= 2
+		log.Debug().Time("Time", ts).Int("Limit", limit).Msg("More Loki entries with the same timestamp than the query limit, increasing query limit")
+		entries, err := m.queryRange(ctx, query, ts, ts.Add(time.Nanosecond), limit)
+		var le *lokiLimitError
+		switch {
+		case errors.As(err, &le):
+			return nil, fmt.Errorf("%w, timestamp: %s, %s", ErrLokiQueryTimestamp, ts.Format(time.RFC3339Nano), le.msg)
+		case err != nil:
+			return nil, err
+		case len(entries) < limit:
+			return entries, nil
+		}
+	}
+}
+
+// lokiLimitError is returned when Loki rejects a query because of its entries or time range limits
+type lokiLimitError struct {
+	entries bool
+	msg     string
+}
+
+func (m *lokiLimitError) Error() string {
+	return m.msg
+}
+
+// queryRange requests one page of entries in [start, end) sorted by time
+func (m *LokiQueryClient) queryRange(ctx context.Context, query string, start, end time.Time, limit int) ([]*LokiEntry, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", strconv.FormatInt(start.UnixNano(), 10))
+	params.Set("end", strconv.FormatInt(end.UnixNano(), 10))
+	params.Set("limit", strconv.Itoa(limit))
+	params.Set("direction", "forward")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.baseURL+lokiQueryRangePath+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range m.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	if m.cfg.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", m.cfg.TenantID)
+	}
+	if m.cfg.BasicAuth != "" {
+		logpass := strings.Split(m.cfg.BasicAuth, ":")
+		req.SetBasicAuth(logpass[0], logpass[1])
+	}
+	if m.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+m.cfg.Token)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(body))
+		switch {
+		case strings.Contains(msg, "max entries limit"):
+			return nil, &lokiLimitError{entries: true, msg: msg}
+		case strings.Contains(msg, "time range exceeds"):
+			return nil, &lokiLimitError{msg: msg}
+		}
+		return nil, fmt.Errorf("loki query failed, status: %d, body: %s", resp.StatusCode, msg)
+	}
+	res := &lokiQueryRangeResponse{}
+	if err := json.Unmarshal(body, res); err != nil {
+		return nil, err
+	}
+	if res.Data.ResultType != "streams" {
+		return nil, fmt.Errorf("loki query result type must be streams, got: %s", res.Data.ResultType)
+	}
+	entries := make([]*LokiEntry, 0)
+	for _, s := range res.Data.Result {
+		for _, v := range s.Values {
+			ns, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, &LokiEntry{Labels: s.Stream, Time: time.Unix(0, ns), Line: v[1]})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries, nil
+}
+
+// query validates the query and runs it for a data type
+func (m *LokiQueryClient) query(ctx context.Context, q *LokiQuery, dataType string, fn func(e *LokiEntry) error) error {
+	if err := q.Validate(); err != nil {
+		return err
+	}
+	return m.QueryRange(ctx, q.selector(dataType), q.Start, q.End, q.Limit, q.Split, fn)
+}
+
+// Responses queries recorded responses, FinishedAt is the entry time and StartedAt is calculated from Duration
+func (m *LokiQueryClient) Responses(ctx context.Context, q *LokiQuery) ([]*Response, error) {
+	responses := make([]*Response, 0)
+	err := m.query(ctx, q, "responses", func(e *LokiEntry) error {
+		r, err := decodeLokiResponse(e)
+		if err != nil {
+			return err
+		}
+		responses = append(responses, r)
+		return nil
+	})
+	return responses, err
+}
+
+// Stats queries stats snapshots, they are StatsJSON of generators
+func (m *LokiQueryClient) Stats(ctx context.Context, q *LokiQuery) ([]map[string]interface{}, error) {
+	stats := make([]map[string]interface{}, 0)
+	err := m.query(ctx, q, "stats", func(e *LokiEntry) error {
+		s := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(e.Line), &s); err != nil {
+			return err
+		}
+		stats = append(stats, s)
+		return nil
+	})
+	return stats, err
+}
+
+// Runs queries responses and stats grouped by generator name, use FileRun.Report or NewFileReport to summarize them
+func (m *LokiQueryClient) Runs(ctx context.Context, q *LokiQuery) ([]*FileRun, error) {
+	runs := make([]*FileRun, 0)
+	byName := make(map[string]*FileRun)
+	run := func(e *LokiEntry) *FileRun {
+		name := e.Labels["gen_name"]
+		r, ok := byName[name]
+		if !ok {
+			labels := make(map[string]string, len(e.Labels))
+			for k, v := range e.Labels {
+				if k != "test_data_type" && k != CallGroupLabel {
+					labels[k] = v
+				}
+			}
+			r = &FileRun{Name: name, Labels: labels}
+			byName[name] = r
+			runs = append(runs, r)
+		}
+		return r
+	}
+	err := m.query(ctx, q, "responses", func(e *LokiEntry) error {
+		r, err := decodeLokiResponse(e)
+		if err != nil {
+			return err
+		}
+		fr := run(e)
+		fr.Responses = append(fr.Responses, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = m.query(ctx, q, "stats", func(e *LokiEntry) error {
+		s := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(e.Line), &s); err != nil {
+			return err
+		}
+		fr := run(e)
+		fr.Stats = append(fr.Stats, s)
+		return nil
+	})
+	return runs, err
+}
+
+func decodeLokiResponse(e *LokiEntry) (*Response, error) {
+	r := &Response{}
+	if err := json.Unmarshal([]byte(e.Line), r); err != nil {
+		return nil, err
+	}
+	// times are removed before pushing, entry time is FinishedAt
+	finishedAt := e.Time
+	startedAt := finishedAt.Add(-r.Duration)
+	r.FinishedAt = &finishedAt
+	r.StartedAt = &startedAt
+	return r, nil
+}
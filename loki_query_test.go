@@ -0,0 +1,193 @@
+package wasp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/grafana/loki/pkg/logql/syntax"
+	"github.com/stretchr/testify/require"
+)
+
+// queryRange serves forward streams queries of pushed entries in [start, end)
+func (m *fakeLoki) queryRange(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	matchers, err := syntax.ParseMatchers(q.Get("query"), true)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	start, _ := strconv.ParseInt(q.Get("start"), 10, 64)
+	end, _ := strconv.ParseInt(q.Get("end"), 10, 64)
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if m.maxEntries > 0 && limit > m.maxEntries {
+		http.Error(w, fmt.Sprintf("max entries limit per query exceeded, limit > max_entries_limit (%d > %d)", limit, m.maxEntries), http.StatusBadRequest)
+		return
+	}
+	if m.maxRange > 0 && time.Duration(end-start) > m.maxRange {
+		http.Error(w, fmt.Sprintf("the query time range exceeds the limit (query length: %s, limit: %s)", time.Duration(end-start), m.maxRange), http.StatusBadRequest)
+		return
+	}
+	m.mu.Lock()
+	selected := make([]*LokiEntry, 0)
+	for _, e := range m.entries {
+		matches := e.Time.UnixNano() >= start && e.Time.UnixNano() < end
+		for _, mt := range matchers {
+			matches = matches && mt.Matches(e.Labels[mt.Name])
+		}
+		if matches {
+			selected = append(selected, e)
+		}
+	}
+	m.mu.Unlock()
+	sort.SliceStable(selected, func(i, j int) bool { return selected[i].Time.Before(selected[j].Time) })
+	if len(selected) > limit {
+		selected = selected[:limit]
+	}
+	res := &lokiQueryRangeResponse{Status: "success"}
+	res.Data.ResultType = "streams"
+	streams := make(map[string]int)
+	for _, e := range selected {
+		key := fmt.Sprint(e.Labels)
+		i, ok := streams[key]
+		if !ok {
+			i = len(res.Data.Result)
+			streams[key] = i
+			res.Data.Result = append(res.Data.Result, struct {
+				Stream map[string]string `json:"stream"`
+				Values [][2]string       `json:"values"`
+			}{Stream: e.Labels})
+		}
+		res.Data.Result[i].Values = append(res.Data.Result[i].Values, [2]string{strconv.FormatInt(e.Time.UnixNano(), 10), e.Line})
+	}
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+func TestSmokeLokiQuery(t *testing.T) {
+	t.Parallel()
+	t.Run("responses and stats are read back with pagination and server limits", func(t *testing.T) {
+		t.Parallel()
+		loki := newFakeLoki(t)
+		loki.maxEntries = 16
+		loki.maxRange = 400 * time.Millisecond
+		lokiCfg := DefaultLokiConfig()
+		lokiCfg.URL = loki.URL + lokiPushPath
+		lokiCfg.BatchWait = 100 * time.Millisecond
+		start := time.Now()
+		gen, err := NewGenerator(&Config{
+			T:                 t,
+			GenName:           "query",
+			LoadType:          RPS,
+			Schedule:          Plain(50, 1*time.Second),
+			StatsPollInterval: 200 * time.Millisecond,
+			Labels:            map[string]string{"branch": "main"},
+			SamplerConfig:     &SamplerConfig{SuccessfulCallResultRecordRatio: 100},
+			LokiConfig:        lokiCfg,
+			Gun: NewMockGun(&MockGunConfig{
+				FailRatio: 30,
+				CallSleep: 10 * time.Millisecond,
+			}),
+		})
+		require.NoError(t, err)
+		gen.Run(true)
+
+		c, err := NewLokiQueryClient(lokiCfg)
+		require.NoError(t, err)
+		q := &LokiQuery{TestName: t.Name(), Labels: map[string]string{"branch": "main"}, Start: start}
+		responses, err := c.Responses(context.Background(), q)
+		require.NoError(t, err)
+		require.Equal(t, int(gen.Stats().Latencies.All.Count()), len(responses))
+		for i, r := range responses {
+			require.Equal(t, r.Duration, r.FinishedAt.Sub(*r.StartedAt))
+			if i > 0 {
+				require.False(t, r.FinishedAt.Before(*responses[i-1].FinishedAt))
+			}
+		}
+
+		runs, err := c.Runs(context.Background(), q)
+		require.NoError(t, err)
+		require.Len(t, runs, 1)
+		run := runs[0]
+		require.Equal(t, "query", run.Name)
+		require.Equal(t, "main", run.Labels["branch"])
+		require.Len(t, run.Responses, len(responses))
+		require.GreaterOrEqual(t, len(run.Stats), 5)
+		expected := gen.Report()
+		r := run.Report(DefaultPercentiles)
+		require.Equal(t, expected.Requests, r.Requests)
+		require.Equal(t, expected.Success, r.Success)
+		require.Equal(t, expected.Failed, r.Failed)
+		require.Equal(t, expected.TopErrors, r.TopErrors)
+
+		other, err := c.Responses(context.Background(), &LokiQuery{TestName: t.Name(), GenName: "other", Start: start})
+		require.NoError(t, err)
+		require.Empty(t, other)
+	})
+	t.Run("entries with the same timestamp span pages", func(t *testing.T) {
+		t.Parallel()
+		loki := newFakeLoki(t)
+		ts := time.Now()
+		for i := 0; i < 25; i++ {
+			loki.entries = append(loki.entries, &LokiEntry{
+				Labels: map[string]string{"test_data_type": "stats"},
+				Time:   ts.Add(time.Duration(i/10) * time.Millisecond),
+				Line:   fmt.Sprintf(`{"i": %d}`, i),
+			})
+		}
+		c, err := NewLokiQueryClient(&LokiConfig{URL: loki.URL + lokiPushPath})
+		require.NoError(t, err)
+		stats, err := c.Stats(context.Background(), &LokiQuery{Start: ts, End: ts.Add(time.Second), Limit: 15})
+		require.NoError(t, err)
+		require.Len(t, stats, 25)
+		for i, s := range stats {
+			require.Equal(t, float64(i), s["i"])
+		}
+	})
+	t.Run("entries with the same timestamp are not skipped", func(t *testing.T) {
+		t.Parallel()
+		loki := newFakeLoki(t)
+		ts := time.Now()
+		for i := 0; i < 40; i++ {
+			loki.entries = append(loki.entries, &LokiEntry{
+				Labels: map[string]string{"test_data_type": "stats"},
+				Time:   ts.Add(time.Duration(i/35) * time.Millisecond),
+				Line:   fmt.Sprintf(`{"i": %d}`, i),
+			})
+		}
+		c, err := NewLokiQueryClient(&LokiConfig{URL: loki.URL + lokiPushPath})
+		require.NoError(t, err)
+		stats, err := c.Stats(context.Background(), &LokiQuery{Start: ts, End: ts.Add(time.Second), Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, stats, 40)
+		for i, s := range stats {
+			require.Equal(t, float64(i), s["i"])
+		}
+		// Loki doesn't allow to query all the entries of the timestamp at once
+		loki.maxEntries = 20
+		_, err = c.Stats(context.Background(), &LokiQuery{Start: ts, End: ts.Add(time.Second), Limit: 10})
+		require.ErrorIs(t, err, ErrLokiQueryTimestamp)
+	})
+	t.Run("validation", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewLokiQueryClient(&LokiConfig{})
+		require.ErrorIs(t, err, ErrLokiQueryNoURL)
+		c, err := NewLokiQueryClient(&LokiConfig{URL: "http://localhost:3100/loki/api/v1/push"})
+		require.NoError(t, err)
+		for _, tc := range []struct {
+			q   *LokiQuery
+			err error
+		}{
+			{&LokiQuery{}, ErrLokiQueryRange},
+			{&LokiQuery{Start: time.Now().Add(time.Hour)}, ErrLokiQueryRange},
+			{&LokiQuery{Start: time.Now().Add(-time.Hour), Limit: -1}, ErrLokiQueryLimit},
+		} {
+			_, err := c.Responses(context.Background(), tc.q)
+			require.ErrorIs(t, err, tc.err)
+		}
+	})
+}
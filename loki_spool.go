@@ -0,0 +1,483 @@
+package wasp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/grafana/dskit/backoff"
+	lokiAPI "github.com/grafana/loki/clients/pkg/promtail/api"
+	lokiProto "github.com/grafana/loki/pkg/logproto"
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/rs/zerolog/log"
+)
+
+/* Disk spool for Loki entries that can't be pushed */
+
+const (
+	DefaultLokiSpoolMaxBytes      = 1 << 30
+	DefaultLokiSpoolSegmentBytes  = 16 << 20
+	DefaultLokiSpoolRetryInterval = 5 * time.Second
+	DefaultLokiSpoolFlushTimeout  = 1 * time.Minute
+	lokiSpoolSegmentName          = "loki"
+	// lokiSpoolMinBatchWait is used if LokiConfig.BatchWait is not set
+	lokiSpoolMinBatchWait = 10 * time.Millisecond
+	// lokiPushContentType is snappy compressed protobuf, the same as promtail pushes
+	lokiPushContentType = "application/x-protobuf"
+)
+
+var (
+	ErrLokiSpoolNoDir = errors.New("loki spool dir must be set")
+	ErrLokiSpoolSize  = errors.New("loki spool max bytes and segment bytes must be >= 0")
+)
+
+// LokiSpoolConfig is a configuration of a write-ahead spool used when Loki is unavailable
+type LokiSpoolConfig struct {
+	// Dir is a spool directory, entries left by previous runs are replayed first
+	Dir string
+	// MaxBytes is a spool size cap, new entries are dropped when it's reached, default is DefaultLokiSpoolMaxBytes
+	MaxBytes int64
+	// SegmentBytes is a size of one spool segment, default is DefaultLokiSpoolSegmentBytes
+	SegmentBytes int64
+	// RetryInterval is an interval of Loki availability checks, default is DefaultLokiSpoolRetryInterval
+	RetryInterval time.Duration
+	// FlushTimeout limits replay and flush at the end of the run, default is DefaultLokiSpoolFlushTimeout
+	FlushTimeout time.Duration
+}
+
+func (m *LokiSpoolConfig) Validate() error {
+	if m.Dir == "" {
+		return ErrLokiSpoolNoDir
+	}
+	if m.MaxBytes < 0 || m.SegmentBytes < 0 {
+		return ErrLokiSpoolSize
+	}
+	if m.MaxBytes == 0 {
+		m.MaxBytes = DefaultLokiSpoolMaxBytes
+	}
+	if m.SegmentBytes == 0 {
+		m.SegmentBytes = DefaultLokiSpoolSegmentBytes
+	}
+	if m.RetryInterval == 0 {
+		m.RetryInterval = DefaultLokiSpoolRetryInterval
+	}
+	if m.FlushTimeout == 0 {
+		m.FlushTimeout = DefaultLokiSpoolFlushTimeout
+	}
+	return nil
+}
+
+// LokiSpoolStats are spool counters
+type LokiSpoolStats struct {
+	// Spooled entries written to disk
+	Spooled atomic.Int64 `json:"spooled"`
+	// Replayed entries pushed to Loki from disk and acknowledged
+	Replayed atomic.Int64 `json:"replayed"`
+	// Dropped entries because the spool was full
+	Dropped atomic.Int64 `json:"dropped"`
+	// Rejected entries Loki refused with a client error, they are not retried
+	Rejected atomic.Int64 `json:"rejected"`
+	// Bytes currently stored on disk
+	Bytes atomic.Int64 `json:"bytes"`
+}
+
+// lokiSpoolEntry is one spooled Loki entry
+type lokiSpoolEntry struct {
+	Labels model.LabelSet `json:"labels"`
+	Time   time.Time      `json:"ts"`
+	Line   string         `json:"line"`
+}
+
+// lokiSpool pushes entries to Loki in batches, persists them while Loki is down and replays them in order when it recovers.
+// Entries are acknowledged by the result of their push, a batch that failed is spooled before the entries added after it
+type lokiSpool struct {
+	cfg     *LokiSpoolConfig
+	lokiCfg *LokiConfig
+	client  *http.Client
+	stats   *LokiSpoolStats
+	mu      *sync.Mutex
+	up      bool
+	// batch are entries waiting for the next push while Loki is up, batchBytes is the size of their lines
+	batch      []lokiAPI.Entry
+	batchBytes int
+	flush      chan struct{}
+	writer     *segmentWriter
+	pending    []string
+	// offset is the amount of pushed entries of the first pending segment
+	offset int
+	stop   chan struct{}
+	wg     *sync.WaitGroup
+}
+
+func newLokiSpool(cfg *LokiConfig, httpCfg config.HTTPClientConfig, up bool) (*lokiSpool, error) {
+	client, err := config.NewClientFromConfig(httpCfg, "wasp", config.WithHTTP2Disabled())
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.Spool.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	pending, err := filepath.Glob(filepath.Join(cfg.Spool.Dir, lokiSpoolSegmentName+"_*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(pending)
+	m := &lokiSpool{
+		cfg:     cfg.Spool,
+		lokiCfg: cfg,
+		client:  client,
+		stats:   &LokiSpoolStats{},
+		mu:      &sync.Mutex{},
+		up:      up && len(pending) == 0,
+		flush:   make(chan struct{}, 1),
+		writer:  newSegmentWriter(cfg.Spool.Dir, lokiSpoolSegmentName, FileFormatJSONL, false, cfg.Spool.SegmentBytes),
+		pending: pending,
+		stop:    make(chan struct{}),
+		wg:      &sync.WaitGroup{},
+	}
+	for _, p := range pending {
+		var seq int
+		if _, err := fmt.Sscanf(filepath.Base(p), lokiSpoolSegmentName+"_%06d.jsonl", &seq); err == nil && seq > m.writer.seq {
+			// new segments must be replayed after the segments of previous runs
+			m.writer.seq = seq
+		}
+		if fi, err := os.Stat(p); err == nil {
+			m.stats.Bytes.Add(fi.Size())
+		}
+	}
+	if !m.up {
+		log.Warn().Str("Dir", m.cfg.Dir).Int("Segments", len(pending)).Msg("Loki is unavailable or spool is not empty, spooling entries")
+	}
+	m.wg.Add(1)
+	go m.run()
+	return m, nil
+}
+
+// handle adds an entry to the next batch or spools it if Loki is down
+func (m *lokiSpool) handle(e lokiAPI.Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.up {
+		return m.write(e)
+	}
+	m.batch = append(m.batch, e)
+	m.batchBytes += len(e.Line)
+	if m.batchBytes >= m.lokiCfg.BatchSize {
+		select {
+		case m.flush <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// write writes an entry to the current segment, must be called under lock
+func (m *lokiSpool) write(e lokiAPI.Entry) error {
+	d, err := json.Marshal(&lokiSpoolEntry{Labels: e.Labels, Time: e.Timestamp, Line: e.Line})
+	if err != nil {
+		return err
+	}
+	size := int64(len(d) + 1)
+	if m.stats.Bytes.Load()+size > m.cfg.MaxBytes {
+		m.stats.Dropped.Add(1)
+		return nil
+	}
+	var prev string
+	if m.writer.file != nil {
+		prev = m.writer.file.Name()
+	}
+	opened, err := m.writer.next()
+	if err != nil {
+		return err
+	}
+	if opened && prev != "" {
+		m.pending = append(m.pending, prev)
+	}
+	if _, err := m.writer.Write(append(d, '\n')); err != nil {
+		return err
+	}
+	m.stats.Spooled.Add(1)
+	m.stats.Bytes.Add(size)
+	return nil
+}
+
+// pushBatch pushes the current batch, if it still fails after MaxErrors retries Loki is marked down,
+// the batch is spooled and the entries added during the push after it
+func (m *lokiSpool) pushBatch(done <-chan struct{}) {
+	m.mu.Lock()
+	if !m.up || len(m.batch) == 0 {
+		m.mu.Unlock()
+		return
+	}
+	batch := m.batch
+	m.batch, m.batchBytes = nil, 0
+	m.mu.Unlock()
+	err := m.pushRetrying(batch, done)
+	if err == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.up = false
+	for _, e := range append(batch, m.batch...) {
+		if err := m.write(e); err != nil {
+			log.Err(err).Msg("Failed to spool Loki entry")
+		}
+	}
+	m.batch, m.batchBytes = nil, 0
+	log.Warn().Err(err).Str("Dir", m.cfg.Dir).Int("Batch", len(batch)).Msg("Loki is unavailable, spooling entries")
+}
+
+// pushRetrying pushes entries and retries according to BackoffConfig, returns the error after MaxErrors failed retries
+func (m *lokiSpool) pushRetrying(entries []lokiAPI.Entry, done <-chan struct{}) error {
+	ctx, cancel := doneContext(done)
+	defer cancel()
+	b := backoff.New(ctx, m.lokiCfg.BackoffConfig)
+	for {
+		err := m.push(entries)
+		if err == nil || ctx.Err() != nil || b.NumRetries() >= max(0, m.lokiCfg.MaxErrors) {
+			return err
+		}
+		log.Warn().Err(err).Int("Retry", b.NumRetries()+1).Msg("Failed to push entries to Loki, retrying")
+		b.Wait()
+	}
+}
+
+// push sends entries to Loki in one request, the way promtail does. Entries refused with a client error
+// other than 429 are counted as rejected and not retried, the other failures are returned.
+// A push is never cancelled, Loki could accept the entries without us knowing it
+func (m *lokiSpool) push(entries []lokiAPI.Entry) error {
+	req := &lokiProto.PushRequest{}
+	streams := make(map[string]int)
+	for _, e := range entries {
+		ls := e.Labels.String()
+		i, ok := streams[ls]
+		if !ok {
+			i = len(req.Streams)
+			streams[ls] = i
+			req.Streams = append(req.Streams, lokiProto.Stream{Labels: ls})
+		}
+		req.Streams[i].Entries = append(req.Streams[i].Entries, e.Entry)
+	}
+	buf, err := req.Marshal()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if m.lokiCfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.lokiCfg.Timeout)
+		defer cancel()
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, m.lokiCfg.URL, bytes.NewReader(snappy.Encode(nil, buf)))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", lokiPushContentType)
+	if m.lokiCfg.TenantID != "" {
+		httpReq.Header.Set("X-Scope-OrgID", m.lokiCfg.TenantID)
+	}
+	for k, v := range m.lokiCfg.Headers {
+		if httpReq.Header.Get(k) == "" {
+			httpReq.Header.Set(k, v)
+		}
+	}
+	resp, err := m.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests:
+		m.stats.Rejected.Add(int64(len(entries)))
+		log.Error().Int("Status", resp.StatusCode).Int("Entries", len(entries)).Msg("Loki rejected entries")
+		return nil
+	default:
+		return fmt.Errorf("loki push returned HTTP status %s", resp.Status)
+	}
+}
+
+// available checks Loki the same way NewLokiClient does, server errors mean Loki is down
+func (m *lokiSpool) available() bool {
+	c := &http.Client{Timeout: m.cfg.RetryInterval}
+	resp, err := c.Get(m.lokiCfg.URL)
+	if err != nil {
+		return false
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	return resp.StatusCode < http.StatusInternalServerError
+}
+
+// run pushes batches every BatchWait or when BatchSize is reached while Loki is up,
+// and replays the spool every RetryInterval while Loki is down
+func (m *lokiSpool) run() {
+	defer m.wg.Done()
+	batchTicker := time.NewTicker(max(lokiSpoolMinBatchWait, m.lokiCfg.BatchWait))
+	defer batchTicker.Stop()
+	retryTicker := time.NewTicker(m.cfg.RetryInterval)
+	defer retryTicker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-m.flush:
+			m.pushBatch(m.stop)
+		case <-batchTicker.C:
+			m.pushBatch(m.stop)
+		case <-retryTicker.C:
+			m.mu.Lock()
+			up := m.up
+			m.mu.Unlock()
+			if !up && m.available() {
+				m.replay(m.stop)
+			}
+		}
+	}
+}
+
+// replay pushes spooled segments in order in batches of BatchSize, a segment is removed only after Loki accepted all its entries,
+// an interrupted replay continues from the first entry that was not pushed. Switches back to Loki when the spool is empty
+func (m *lokiSpool) replay(done <-chan struct{}) {
+	for {
+		m.mu.Lock()
+		if len(m.pending) == 0 && m.writer.file != nil {
+			path := m.writer.file.Name()
+			if err := m.writer.Close(); err != nil {
+				log.Err(err).Str("Segment", path).Msg("Failed to close Loki spool segment")
+			}
+			m.pending = append(m.pending, path)
+		}
+		if len(m.pending) == 0 {
+			m.up = true
+			m.mu.Unlock()
+			log.Info().Str("Dir", m.cfg.Dir).Msg("Loki spool is replayed")
+			return
+		}
+		path := m.pending[0]
+		m.mu.Unlock()
+
+		entries, err := readLokiSpoolSegment(path)
+		if err != nil {
+			log.Err(err).Str("Segment", path).Msg("Failed to read Loki spool segment, skipping")
+		}
+		for m.offset < len(entries) {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			batch := m.replayBatch(entries[m.offset:])
+			if err := m.push(batch); err != nil {
+				log.Warn().Err(err).Str("Segment", path).Msg("Loki is unavailable, replay is postponed")
+				return
+			}
+			m.offset += len(batch)
+			m.stats.Replayed.Add(int64(len(batch)))
+		}
+		m.offset = 0
+		var size int64
+		if fi, err := os.Stat(path); err == nil {
+			size = fi.Size()
+		}
+		if err := os.Remove(path); err != nil {
+			log.Err(err).Str("Segment", path).Msg("Failed to remove Loki spool segment")
+		}
+		m.mu.Lock()
+		m.pending = m.pending[1:]
+		m.mu.Unlock()
+		m.stats.Bytes.Add(-size)
+	}
+}
+
+// replayBatch returns the first spooled entries up to BatchSize bytes, at least one
+func (m *lokiSpool) replayBatch(entries []*lokiSpoolEntry) []lokiAPI.Entry {
+	batch := make([]lokiAPI.Entry, 0)
+	var size int
+	for _, e := range entries {
+		if len(batch) > 0 && size+len(e.Line) > m.lokiCfg.BatchSize {
+			break
+		}
+		size += len(e.Line)
+		batch = append(batch, lokiAPI.Entry{Labels: e.Labels, Entry: lokiProto.Entry{Timestamp: e.Time, Line: e.Line}})
+	}
+	return batch
+}
+
+// Close pushes the last batch, replays what is left if Loki is available and closes the current segment,
+// for at most FlushTimeout. Entries that can't be pushed stay on disk for the next run
+func (m *lokiSpool) Close() error {
+	close(m.stop)
+	m.wg.Wait()
+	done := make(chan struct{})
+	t := time.AfterFunc(m.cfg.FlushTimeout, func() { close(done) })
+	defer t.Stop()
+	m.pushBatch(done)
+	m.mu.Lock()
+	up := m.up
+	m.mu.Unlock()
+	if !up && m.available() {
+		m.replay(done)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if d := m.stats.Dropped.Load(); d > 0 {
+		log.Warn().Int64("Dropped", d).Msg("Loki spool was full, entries were dropped")
+	}
+	if !m.up {
+		log.Warn().
+			Str("Dir", m.cfg.Dir).
+			Int64("Bytes", m.stats.Bytes.Load()).
+			Msg("Loki is unavailable, spool is kept and will be replayed by the next run")
+	}
+	return m.writer.Close()
+}
+
+// doneContext returns a context that is cancelled when done is closed
+func doneContext(done <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+func readLokiSpoolSegment(path string) ([]*lokiSpoolEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	entries := make([]*lokiSpoolEntry, 0)
+	dec := json.NewDecoder(f)
+	for {
+		e := &lokiSpoolEntry{}
+		if err := dec.Decode(e); err != nil {
+			if errors.Is(err, io.EOF) {
+				return entries, nil
+			}
+			// a segment can be truncated if the previous run crashed
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+}
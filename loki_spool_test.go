@@ -0,0 +1,228 @@
+package wasp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/grafana/dskit/backoff"
+	lokiProto "github.com/grafana/loki/pkg/logproto"
+	"github.com/grafana/loki/pkg/logql/syntax"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLoki receives pushed lines and serves query_range, it responds with 500 when failing and with 400 to pushes when rejecting
+type fakeLoki struct {
+	*httptest.Server
+	failing   atomic.Bool
+	rejecting atomic.Bool
+	mu        *sync.Mutex
+	lines     []string
+	entries   []*LokiEntry
+	// query limits, like max_entries_limit_per_query and max_query_length
+	maxEntries int
+	maxRange   time.Duration
+}
+
+func newFakeLoki(t *testing.T) *fakeLoki {
+	m := &fakeLoki{mu: &sync.Mutex{}}
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Path == lokiQueryRangePath {
+			m.queryRange(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if m.rejecting.Load() {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		body, err = snappy.Decode(nil, body)
+		require.NoError(t, err)
+		req := &lokiProto.PushRequest{}
+		require.NoError(t, req.Unmarshal(body))
+		m.mu.Lock()
+		for _, s := range req.Streams {
+			ls, err := syntax.ParseLabels(s.Labels)
+			require.NoError(t, err)
+			for _, e := range s.Entries {
+				m.lines = append(m.lines, e.Line)
+				m.entries = append(m.entries, &LokiEntry{Labels: ls.Map(), Time: e.Timestamp, Line: e.Line})
+			}
+		}
+		m.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *fakeLoki) received() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string{}, m.lines...)
+}
+
+func spoolLokiConfig(url string, spool *LokiSpoolConfig) *LokiConfig {
+	cfg := DefaultLokiConfig()
+	cfg.URL = url + "/loki/api/v1/push"
+	cfg.MaxErrors = 1
+	cfg.BatchWait = 50 * time.Millisecond
+	cfg.Timeout = 1 * time.Second
+	cfg.BackoffConfig = backoff.Config{MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	spool.RetryInterval = 100 * time.Millisecond
+	spool.FlushTimeout = 5 * time.Second
+	cfg.Spool = spool
+	return cfg
+}
+
+func pushLines(t *testing.T, c *LokiClient, from, to int) {
+	for i := from; i < to; i++ {
+		require.NoError(t, c.Handle(model.LabelSet{"test": "spool"}, time.Now(), strconv.Itoa(i)))
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func requireLines(t *testing.T, lines []string, n int) {
+	require.Len(t, lines, n)
+	for i, l := range lines {
+		require.Equal(t, strconv.Itoa(i), l)
+	}
+}
+
+func TestSmokeLokiSpool(t *testing.T) {
+	t.Parallel()
+	t.Run("entries are spooled while Loki is down and replayed in order", func(t *testing.T) {
+		t.Parallel()
+		loki := newFakeLoki(t)
+		dir := t.TempDir()
+		c, err := NewLokiClient(spoolLokiConfig(loki.URL, &LokiSpoolConfig{Dir: dir, SegmentBytes: 300}))
+		require.NoError(t, err)
+
+		pushLines(t, c, 0, 10)
+		loki.failing.Store(true)
+		pushLines(t, c, 10, 60)
+		require.Greater(t, c.SpoolStats().Spooled.Load(), int64(0))
+		loki.failing.Store(false)
+		pushLines(t, c, 60, 70)
+		require.NoError(t, c.Close())
+
+		requireLines(t, loki.received(), 70)
+		stats := c.SpoolStats()
+		require.Equal(t, stats.Spooled.Load(), stats.Replayed.Load())
+		require.Equal(t, int64(0), stats.Dropped.Load())
+		require.Equal(t, int64(0), stats.Bytes.Load())
+		segments, err := filepath.Glob(filepath.Join(dir, "*"))
+		require.NoError(t, err)
+		require.Empty(t, segments)
+	})
+	t.Run("spool is kept when Loki is unavailable and replayed by the next run", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		down := httptest.NewServer(http.NotFoundHandler())
+		down.Close()
+		c, err := NewLokiClient(spoolLokiConfig(down.URL, &LokiSpoolConfig{Dir: dir, SegmentBytes: 100}))
+		require.NoError(t, err)
+		pushLines(t, c, 0, 20)
+		require.NoError(t, c.Close())
+		require.Equal(t, int64(20), c.SpoolStats().Spooled.Load())
+		segments, err := filepath.Glob(filepath.Join(dir, "loki_*.jsonl"))
+		require.NoError(t, err)
+		require.Greater(t, len(segments), 1)
+
+		loki := newFakeLoki(t)
+		c, err = NewLokiClient(spoolLokiConfig(loki.URL, &LokiSpoolConfig{Dir: dir}))
+		require.NoError(t, err)
+		pushLines(t, c, 20, 25)
+		require.NoError(t, c.Close())
+		requireLines(t, loki.received(), 25)
+		require.Equal(t, int64(25), c.SpoolStats().Replayed.Load())
+	})
+	t.Run("entries in flight are spooled when Loki fails", func(t *testing.T) {
+		t.Parallel()
+		loki := newFakeLoki(t)
+		loki.failing.Store(true)
+		dir := t.TempDir()
+		cfg := spoolLokiConfig(loki.URL, &LokiSpoolConfig{Dir: dir})
+		cfg.Spool.FlushTimeout = 200 * time.Millisecond
+		c, err := NewLokiClient(cfg)
+		require.NoError(t, err)
+		// the first batch fails after it was taken from the spool, it's spooled before the entries added meanwhile
+		pushLines(t, c, 0, 20)
+		require.NoError(t, c.Close())
+		require.Equal(t, int64(20), c.SpoolStats().Spooled.Load())
+		require.Empty(t, loki.received())
+
+		loki.failing.Store(false)
+		c, err = NewLokiClient(spoolLokiConfig(loki.URL, &LokiSpoolConfig{Dir: dir}))
+		require.NoError(t, err)
+		require.NoError(t, c.Close())
+		requireLines(t, loki.received(), 20)
+	})
+	t.Run("entries rejected by Loki are not spooled", func(t *testing.T) {
+		t.Parallel()
+		loki := newFakeLoki(t)
+		loki.rejecting.Store(true)
+		dir := t.TempDir()
+		c, err := NewLokiClient(spoolLokiConfig(loki.URL, &LokiSpoolConfig{Dir: dir}))
+		require.NoError(t, err)
+		pushLines(t, c, 0, 20)
+		loki.rejecting.Store(false)
+		pushLines(t, c, 20, 30)
+		require.NoError(t, c.Close())
+		stats := c.SpoolStats()
+		require.Equal(t, int64(0), stats.Spooled.Load())
+		require.Equal(t, int64(30), stats.Rejected.Load()+int64(len(loki.received())))
+		require.Greater(t, stats.Rejected.Load(), int64(0))
+		segments, err := filepath.Glob(filepath.Join(dir, "*"))
+		require.NoError(t, err)
+		require.Empty(t, segments)
+	})
+	t.Run("entries are dropped when the spool is full", func(t *testing.T) {
+		t.Parallel()
+		down := httptest.NewServer(http.NotFoundHandler())
+		down.Close()
+		cfg := spoolLokiConfig(down.URL, &LokiSpoolConfig{Dir: t.TempDir(), MaxBytes: 500})
+		cfg.Spool.FlushTimeout = 100 * time.Millisecond
+		c, err := NewLokiClient(cfg)
+		require.NoError(t, err)
+		pushLines(t, c, 0, 50)
+		require.NoError(t, c.Close())
+		stats := c.SpoolStats()
+		require.Greater(t, stats.Dropped.Load(), int64(0))
+		require.Equal(t, int64(50), stats.Spooled.Load()+stats.Dropped.Load())
+		require.LessOrEqual(t, stats.Bytes.Load(), int64(500))
+	})
+	t.Run("validation", func(t *testing.T) {
+		t.Parallel()
+		for _, tc := range []struct {
+			cfg *LokiSpoolConfig
+			err error
+		}{
+			{&LokiSpoolConfig{}, ErrLokiSpoolNoDir},
+			{&LokiSpoolConfig{Dir: t.TempDir(), MaxBytes: -1}, ErrLokiSpoolSize},
+		} {
+			cfg := DefaultLokiConfig()
+			cfg.URL = "http://localhost:3100/loki/api/v1/push"
+			cfg.Spool = tc.cfg
+			_, err := NewLokiClient(cfg)
+			require.ErrorIs(t, err, tc.err)
+		}
+	})
+}
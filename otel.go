@@ -0,0 +1,248 @@
+package wasp
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/* OpenTelemetry export of call spans and load metrics */
+
+const (
+	DefaultOTelServiceName     = "wasp"
+	DefaultOTelMetricInterval  = 10 * time.Second
+	DefaultOTelShutdownTimeout = 10 * time.Second
+	// OTelInstrumentationName is the name of wasp tracer and meter
+	OTelInstrumentationName = "github.com/smartcontractkit/wasp"
+)
+
+var (
+	ErrOTelProtocol   = errors.New("otel protocol must be grpc or http")
+	ErrOTelNoEndpoint = errors.New("otel endpoint must be set")
+)
+
+// OTelProtocol is an OTLP transport
+type OTelProtocol string
+
+const (
+	OTelGRPC OTelProtocol = "grpc"
+	OTelHTTP OTelProtocol = "http"
+)
+
+// traceContext propagates W3C trace context
+var traceContext = propagation.TraceContext{}
+
+// OTelConfig is a configuration of OTLP traces and metrics export
+type OTelConfig struct {
+	// Protocol is OTLP transport, default is OTelGRPC
+	Protocol OTelProtocol
+	// Endpoint is a collector "host:port"
+	Endpoint string
+	Insecure bool
+	Headers  map[string]string
+	// ServiceName is a service.name resource attribute, default is DefaultOTelServiceName
+	ServiceName string
+	// MetricInterval is an interval of metrics export, default is DefaultOTelMetricInterval
+	MetricInterval time.Duration
+	// SpanExporter replaces OTLP span exporter, ex.: tracetest.NewInMemoryExporter()
+	SpanExporter sdktrace.SpanExporter
+	// MetricReader replaces OTLP periodic metric reader, ex.: sdkmetric.NewManualReader()
+	MetricReader sdkmetric.Reader
+}
+
+func (m *OTelConfig) Validate() error {
+	if m.Protocol == "" {
+		m.Protocol = OTelGRPC
+	}
+	if m.Protocol != OTelGRPC && m.Protocol != OTelHTTP {
+		return ErrOTelProtocol
+	}
+	if m.Endpoint == "" && (m.SpanExporter == nil || m.MetricReader == nil) {
+		return ErrOTelNoEndpoint
+	}
+	if m.ServiceName == "" {
+		m.ServiceName = DefaultOTelServiceName
+	}
+	if m.MetricInterval == 0 {
+		m.MetricInterval = DefaultOTelMetricInterval
+	}
+	return nil
+}
+
+// otelExporter exports spans of generator calls and metrics of generator stats
+type otelExporter struct {
+	tp     *sdktrace.TracerProvider
+	mp     *sdkmetric.MeterProvider
+	tracer trace.Tracer
+	attrs  []attribute.KeyValue
+}
+
+func newOTelExporter(cfg *OTelConfig, g *Generator) (*otelExporter, error) {
+	ctx := context.Background()
+	spans, reader := cfg.SpanExporter, cfg.MetricReader
+	if spans == nil {
+		var err error
+		spans, err = newOTLPSpanExporter(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if reader == nil {
+		metrics, err := newOTLPMetricExporter(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		reader = sdkmetric.NewPeriodicReader(metrics, sdkmetric.WithInterval(cfg.MetricInterval))
+	}
+	res := resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName))
+	m := &otelExporter{
+		tp: sdktrace.NewTracerProvider(sdktrace.WithBatcher(spans), sdktrace.WithResource(res)),
+		mp: sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader), sdkmetric.WithResource(res)),
+		attrs: []attribute.KeyValue{
+			attribute.String("go_test_name", g.testName()),
+			attribute.String("gen_name", g.Cfg.GenName),
+			attribute.String("node_id", g.Cfg.nodeID),
+		},
+	}
+	m.tracer = m.tp.Tracer(OTelInstrumentationName)
+	if err := m.registerMetrics(g); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func newOTLPSpanExporter(ctx context.Context, cfg *OTelConfig) (sdktrace.SpanExporter, error) {
+	if cfg.Protocol == OTelHTTP {
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint), otlptracehttp.WithHeaders(cfg.Headers)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, opts...)
+	}
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint), otlptracegrpc.WithHeaders(cfg.Headers)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	return otlptracegrpc.New(ctx, opts...)
+}
+
+func newOTLPMetricExporter(ctx context.Context, cfg *OTelConfig) (sdkmetric.Exporter, error) {
+	if cfg.Protocol == OTelHTTP {
+		opts := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpoint(cfg.Endpoint), otlpmetrichttp.WithHeaders(cfg.Headers)}
+		if cfg.Insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+		return otlpmetrichttp.New(ctx, opts...)
+	}
+	opts := []otlpmetricgrpc.Option{otlpmetricgrpc.WithEndpoint(cfg.Endpoint), otlpmetricgrpc.WithHeaders(cfg.Headers)}
+	if cfg.Insecure {
+		opts = append(opts, otlpmetricgrpc.WithInsecure())
+	}
+	return otlpmetricgrpc.New(ctx, opts...)
+}
+
+// registerMetrics registers observable instruments reading generator stats on every export
+func (m *otelExporter) registerMetrics(g *Generator) error {
+	meter := m.mp.Meter(OTelInstrumentationName)
+	type observable struct {
+		name  string
+		desc  string
+		value func() int64
+	}
+	counters := []observable{
+		{"wasp.success", "Successful calls", g.stats.Success.Load},
+		{"wasp.failed", "Failed calls, including timeouts", g.stats.Failed.Load},
+		{"wasp.call_timeout", "Timed out calls", g.stats.CallTimeout.Load},
+		{"wasp.dropped", "Calls dropped because of MaxInFlight", g.stats.Dropped.Load},
+		{"wasp.samples_recorded", "Recorded responses", g.stats.SamplesRecorded.Load},
+		{"wasp.samples_skipped", "Responses skipped by the sampler", g.stats.SamplesSkipped.Load},
+	}
+	gauges := []observable{
+		{"wasp.current_rps", "Scheduled RPS", g.stats.CurrentRPS.Load},
+		{"wasp.current_vus", "Scheduled VUs", g.stats.CurrentVUs.Load},
+		{"wasp.current_segment", "Current schedule segment", g.stats.CurrentSegment.Load},
+	}
+	instruments := make([]metric.Observable, 0)
+	values := make(map[metric.Int64Observable]func() int64)
+	for _, c := range counters {
+		inst, err := meter.Int64ObservableCounter(c.name, metric.WithDescription(c.desc))
+		if err != nil {
+			return err
+		}
+		instruments = append(instruments, inst)
+		values[inst] = c.value
+	}
+	for _, c := range gauges {
+		inst, err := meter.Int64ObservableGauge(c.name, metric.WithDescription(c.desc))
+		if err != nil {
+			return err
+		}
+		instruments = append(instruments, inst)
+		values[inst] = c.value
+	}
+	opt := metric.WithAttributes(m.attrs...)
+	_, err := meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		for inst, value := range values {
+			o.ObserveInt64(inst, value(), opt)
+		}
+		return nil
+	}, instruments...)
+	return err
+}
+
+// startCall starts a span of a gun call, span context is passed to the gun
+func (m *otelExporter) startCall(ctx context.Context, name string) (context.Context, trace.Span) {
+	return m.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(m.attrs...))
+}
+
+// endCall ends a span with response attributes, res is nil if the call was cancelled
+func (m *otelExporter) endCall(span trace.Span, res *Response) {
+	if res != nil {
+		span.SetAttributes(
+			attribute.String("call_group", res.Group),
+			attribute.String("status_code", res.StatusCode),
+			attribute.Bool("failed", res.Failed),
+			attribute.Bool("timeout", res.Timeout),
+		)
+		if res.Error != "" {
+			span.SetAttributes(attribute.String("error", res.Error))
+		}
+		if res.Failed || res.Timeout || res.Error != "" {
+			span.SetStatus(codes.Error, res.Error)
+		}
+	}
+	span.End()
+}
+
+// Shutdown flushes spans and metrics
+func (m *otelExporter) Shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultOTelShutdownTimeout)
+	defer cancel()
+	return errors.Join(m.tp.Shutdown(ctx), m.mp.Shutdown(ctx))
+}
+
+// TraceParent returns W3C traceparent of a call context passed to GunCtx or VirtualUserCtx, empty if OTel is off
+func TraceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	traceContext.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// InjectTraceContext injects W3C trace context of a call context into a carrier,
+// ex.: InjectTraceContext(ctx, propagation.HeaderCarrier(req.Header))
+func InjectTraceContext(ctx context.Context, carrier propagation.TextMapCarrier) {
+	traceContext.Inject(ctx, carrier)
+}
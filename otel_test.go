@@ -0,0 +1,289 @@
+package wasp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// keptSpans keeps spans after the tracer provider is shut down
+type keptSpans struct {
+	*tracetest.InMemoryExporter
+}
+
+func (m keptSpans) Shutdown(context.Context) error { return nil }
+
+// traceParentGun records W3C traceparent of every call
+type traceParentGun struct {
+	*MockGun
+	mu      *sync.Mutex
+	parents []string
+}
+
+func (m *traceParentGun) Call(ctx context.Context, l *Generator) *Response {
+	m.mu.Lock()
+	m.parents = append(m.parents, TraceParent(ctx))
+	m.mu.Unlock()
+	return m.MockGun.Call(l)
+}
+
+// otlpCollector is an in-memory OTLP collector for traces and metrics
+type otlpCollector struct {
+	collectortrace.UnimplementedTraceServiceServer
+	collectormetrics.UnimplementedMetricsServiceServer
+	mu       *sync.Mutex
+	services map[string]bool
+	spans    int
+	success  int64
+}
+
+func newOTLPCollector() *otlpCollector {
+	return &otlpCollector{mu: &sync.Mutex{}, services: make(map[string]bool)}
+}
+
+func (m *otlpCollector) Export(_ context.Context, req *collectortrace.ExportTraceServiceRequest) (*collectortrace.ExportTraceServiceResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, a := range rs.Resource.Attributes {
+			if a.Key == "service.name" {
+				m.services[a.Value.GetStringValue()] = true
+			}
+		}
+		for _, ss := range rs.ScopeSpans {
+			m.spans += len(ss.Spans)
+		}
+	}
+	return &collectortrace.ExportTraceServiceResponse{}, nil
+}
+
+// metricsService serves OTLP metrics, Export names of trace and metrics services are the same
+type metricsService struct {
+	*otlpCollector
+}
+
+func (m metricsService) Export(_ context.Context, req *collectormetrics.ExportMetricsServiceRequest) (*collectormetrics.ExportMetricsServiceResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, rm := range req.ResourceMetrics {
+		for _, sm := range rm.ScopeMetrics {
+			for _, metric := range sm.Metrics {
+				if metric.Name != "wasp.success" {
+					continue
+				}
+				for _, dp := range metric.GetSum().DataPoints {
+					m.success = dp.GetAsInt()
+				}
+			}
+		}
+	}
+	return &collectormetrics.ExportMetricsServiceResponse{}, nil
+}
+
+func (m *otlpCollector) startGRPC(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	collectortrace.RegisterTraceServiceServer(srv, m)
+	collectormetrics.RegisterMetricsServiceServer(srv, metricsService{m})
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(srv.Stop)
+	return l.Addr().String()
+}
+
+func (m *otlpCollector) startHTTP(t *testing.T) string {
+	handle := func(export func(body []byte) (proto.Message, error)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			var res proto.Message
+			if err == nil {
+				res, err = export(body)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			out, _ := proto.Marshal(res)
+			w.Header().Set("Content-Type", "application/x-protobuf")
+			_, _ = w.Write(out)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/traces", handle(func(body []byte) (proto.Message, error) {
+		req := &collectortrace.ExportTraceServiceRequest{}
+		if err := proto.Unmarshal(body, req); err != nil {
+			return nil, err
+		}
+		return m.Export(context.Background(), req)
+	}))
+	mux.HandleFunc("/v1/metrics", handle(func(body []byte) (proto.Message, error) {
+		req := &collectormetrics.ExportMetricsServiceRequest{}
+		if err := proto.Unmarshal(body, req); err != nil {
+			return nil, err
+		}
+		return metricsService{m}.Export(context.Background(), req)
+	}))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestSmokeOTel(t *testing.T) {
+	t.Parallel()
+	t.Run("a span per call, trace context is passed to the gun", func(t *testing.T) {
+		t.Parallel()
+		spans := keptSpans{tracetest.NewInMemoryExporter()}
+		gun := &traceParentGun{
+			MockGun: NewMockGun(&MockGunConfig{FailRatio: 50, CallSleep: 10 * time.Millisecond}),
+			mu:      &sync.Mutex{},
+		}
+		gen, err := NewGenerator(&Config{
+			T:        t,
+			GenName:  "otel",
+			LoadType: RPS,
+			Schedule: Plain(20, 1*time.Second),
+			OTel: &OTelConfig{
+				SpanExporter: spans,
+				MetricReader: sdkmetric.NewManualReader(),
+			},
+			GunCtx: gun,
+		})
+		require.NoError(t, err)
+		gen.Run(true)
+
+		stubs := spans.GetSpans()
+		require.Equal(t, int(gen.Stats().Latencies.All.Count()), len(stubs))
+		require.Len(t, gun.parents, len(stubs))
+		traceIDs := make(map[string]bool)
+		var failed int64
+		for _, s := range stubs {
+			require.Equal(t, "wasp.call", s.Name)
+			attrs := attribute.NewSet(s.Attributes...)
+			v, _ := attrs.Value("gen_name")
+			require.Equal(t, "otel", v.AsString())
+			v, _ = attrs.Value("go_test_name")
+			require.Equal(t, t.Name(), v.AsString())
+			v, _ = attrs.Value("failed")
+			if v.AsBool() {
+				failed++
+				require.Equal(t, codes.Error, s.Status.Code)
+				require.Equal(t, "error", s.Status.Description)
+			}
+			traceIDs[s.SpanContext.TraceID().String()] = true
+		}
+		require.Equal(t, gen.Stats().Failed.Load(), failed)
+		for _, p := range gun.parents {
+			// 00-<trace id>-<span id>-<flags>
+			parts := strings.Split(p, "-")
+			require.Len(t, parts, 4)
+			require.True(t, traceIDs[parts[1]])
+		}
+	})
+	t.Run("VU calls are exported as spans", func(t *testing.T) {
+		t.Parallel()
+		spans := keptSpans{tracetest.NewInMemoryExporter()}
+		gen, err := NewGenerator(&Config{
+			T:        t,
+			LoadType: VU,
+			Schedule: Plain(1, 1*time.Second),
+			OTel: &OTelConfig{
+				SpanExporter: spans,
+				MetricReader: sdkmetric.NewManualReader(),
+			},
+			VU: NewMockVU(&MockVirtualUserConfig{CallSleep: 50 * time.Millisecond}),
+		})
+		require.NoError(t, err)
+		gen.Run(true)
+
+		// one span per call, the last call can be cancelled before its response is sent
+		responses := int(gen.Stats().Latencies.All.Count())
+		require.Greater(t, responses, 0)
+		require.GreaterOrEqual(t, len(spans.GetSpans()), responses)
+		require.LessOrEqual(t, len(spans.GetSpans()), responses+1)
+		var finished, cancelled int
+		for _, s := range spans.GetSpans() {
+			require.Equal(t, "wasp.vu.call", s.Name)
+			attrs := attribute.NewSet(s.Attributes...)
+			v, ok := attrs.Value("failed")
+			require.True(t, ok)
+			require.False(t, v.AsBool())
+			if s.Status.Code == codes.Error {
+				require.Equal(t, ErrCallCancelled.Error(), s.Status.Description)
+				cancelled++
+			}
+			if s.EndTime.Sub(s.StartTime) >= 50*time.Millisecond {
+				finished++
+			}
+		}
+		require.GreaterOrEqual(t, finished, responses)
+		require.LessOrEqual(t, cancelled, 1)
+	})
+	for _, protocol := range []OTelProtocol{OTelGRPC, OTelHTTP} {
+		protocol := protocol
+		t.Run("export to OTLP collector over "+string(protocol), func(t *testing.T) {
+			t.Parallel()
+			c := newOTLPCollector()
+			endpoint := c.startHTTP(t)
+			if protocol == OTelGRPC {
+				endpoint = c.startGRPC(t)
+			}
+			gen, err := NewGenerator(&Config{
+				T:        t,
+				LoadType: RPS,
+				Schedule: Plain(20, 1*time.Second),
+				OTel: &OTelConfig{
+					Protocol:       protocol,
+					Endpoint:       endpoint,
+					Insecure:       true,
+					ServiceName:    "checkout",
+					MetricInterval: 100 * time.Millisecond,
+				},
+				Gun: NewMockGun(&MockGunConfig{CallSleep: 10 * time.Millisecond}),
+			})
+			require.NoError(t, err)
+			gen.Run(true)
+
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			require.True(t, c.services["checkout"])
+			require.Equal(t, int(gen.Stats().Latencies.All.Count()), c.spans)
+			require.Equal(t, gen.Stats().Success.Load(), c.success)
+		})
+	}
+	t.Run("validation", func(t *testing.T) {
+		t.Parallel()
+		for _, tc := range []struct {
+			cfg *OTelConfig
+			err error
+		}{
+			{&OTelConfig{}, ErrOTelNoEndpoint},
+			{&OTelConfig{SpanExporter: tracetest.NewInMemoryExporter()}, ErrOTelNoEndpoint},
+			{&OTelConfig{Endpoint: "localhost:4317", Protocol: "thrift"}, ErrOTelProtocol},
+		} {
+			_, err := NewGenerator(&Config{
+				T:        t,
+				LoadType: RPS,
+				Schedule: Plain(1, 1*time.Second),
+				Gun:      NewMockGun(&MockGunConfig{}),
+				OTel:     tc.cfg,
+			})
+			require.ErrorIs(t, err, tc.err)
+		}
+	})
+}
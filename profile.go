@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"testing"
 	"time"
 
 	"github.com/google/uuid"
@@ -24,6 +25,13 @@ type Profile struct {
 	grafanaOpts  GrafanaOpts
 	startTime    time.Time
 	endTime      time.Time
+	control      *ControlServer
+	prom         *PrometheusExporter
+	reportDir    string
+	thresholds   []*Threshold
+	thresholdsT  *testing.T
+	abortOnce    *sync.Once
+	waitOnce     *sync.Once
 }
 
 // Run runs all generators and wait until they finish
@@ -38,6 +46,22 @@ func (m *Profile) Run(wait bool) (*Profile, error) {
 	if len(m.grafanaOpts.AnnotateDashboardUID) > 0 {
 		m.annotateRunStartOnGrafana()
 	}
+	if m.control != nil {
+		for _, g := range m.Generators {
+			m.control.Add(g)
+		}
+		if err := m.control.Start(); err != nil {
+			return m, err
+		}
+	}
+	if m.prom != nil {
+		for _, g := range m.Generators {
+			m.prom.Add(g)
+		}
+		if err := m.prom.Start(); err != nil {
+			return m, err
+		}
+	}
 	for _, g := range m.Generators {
 		g.Run(false)
 	}
@@ -162,6 +186,31 @@ func (m *Profile) Resume() {
 	}
 }
 
+// Stop stops all generators, waiting for all calls for either finish or timeout
+func (m *Profile) Stop() {
+	wg := &sync.WaitGroup{}
+	for _, g := range m.Generators {
+		g := g
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Stop()
+		}()
+	}
+	wg.Wait()
+}
+
+// abort records the reason in all generators stats and stops the profile, called when an abort rule with StopProfile is breached
+func (m *Profile) abort(reason string) {
+	m.abortOnce.Do(func() {
+		log.Warn().Str("ProfileID", m.ProfileID).Str("Reason", reason).Msg("Profile was aborted")
+		for _, g := range m.Generators {
+			g.setAbortReason(reason)
+		}
+		m.Stop()
+	})
+}
+
 // Wait waits until all generators have finished the workload
 func (m *Profile) Wait() {
 	for _, g := range m.Generators {
@@ -173,6 +222,23 @@ func (m *Profile) Wait() {
 		}()
 	}
 	m.testEndedWg.Wait()
+	if m.control != nil {
+		m.control.Stop()
+	}
+	if m.prom != nil {
+		m.prom.Stop()
+	}
+	// thresholds and the report are checked once, Wait can be called again after Run(true)
+	m.waitOnce.Do(func() {
+		if len(m.thresholds) > 0 {
+			reportThresholds(m.thresholdsT, GetLogger(m.thresholdsT, "Profile"), m.CheckThresholds())
+		}
+		if m.reportDir != "" {
+			if err := m.Report().WriteFiles(m.reportDir); err != nil {
+				log.Err(err).Msg("Failed to write profile report")
+			}
+		}
+	})
 }
 
 // NewProfile creates new VU or Gun profile from parts
@@ -181,6 +247,8 @@ func NewProfile() *Profile {
 		ProfileID:   uuid.NewString()[0:5],
 		Generators:  make([]*Generator, 0),
 		testEndedWg: &sync.WaitGroup{},
+		abortOnce:   &sync.Once{},
+		waitOnce:    &sync.Once{},
 	}
 }
 
@@ -189,10 +257,48 @@ func (m *Profile) Add(g *Generator, err error) *Profile {
 		m.bootstrapErr = err
 		return m
 	}
+	g.onProfileAbort = m.abort
 	m.Generators = append(m.Generators, g)
 	return m
 }
 
+// WithControlServer serves runtime control API for all the profile generators on addr while the profile is running
+func (m *Profile) WithControlServer(addr string) *Profile {
+	m.control = NewControlServer(addr)
+	return m
+}
+
+// WithPrometheus serves Prometheus metrics of all the profile generators while the profile is running
+func (m *Profile) WithPrometheus(cfg *PrometheusConfig) *Profile {
+	prom, err := NewPrometheusExporter(cfg)
+	if err != nil {
+		m.bootstrapErr = err
+		return m
+	}
+	m.prom = prom
+	return m
+}
+
+// WithReport writes JSON, Markdown and HTML summary reports of all the profile generators to dir when the profile finishes
+func (m *Profile) WithReport(dir string) *Profile {
+	m.reportDir = dir
+	return m
+}
+
+// WithThresholds checks thresholds against merged results of all the profile generators when the profile finishes,
+// t is failed if any threshold fails, it can be nil
+func (m *Profile) WithThresholds(t *testing.T, thresholds ...*Threshold) *Profile {
+	for _, th := range thresholds {
+		if err := th.Validate(); err != nil {
+			m.bootstrapErr = err
+			return m
+		}
+	}
+	m.thresholdsT = t
+	m.thresholds = thresholds
+	return m
+}
+
 type GrafanaOpts struct {
 	GrafanaURL                   string        `toml:"grafana_url"`
 	GrafanaToken                 string        `toml:"grafana_token_secret"`
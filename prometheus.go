@@ -0,0 +1,247 @@
+package wasp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog"
+)
+
+/* Embedded Prometheus exporter, an alternative to Loki telemetry */
+
+const (
+	DefaultPrometheusPath            = "/metrics"
+	DefaultPrometheusShutdownTimeout = 5 * time.Second
+)
+
+var (
+	ErrPrometheusStarted = errors.New("prometheus exporter is already started")
+	ErrPrometheusNoAddr  = errors.New("prometheus exporter address must be set")
+)
+
+var (
+	promResponseLabels  = []string{"go_test_name", "gen_name", "call_group", "node_id"}
+	promGeneratorLabels = []string{"go_test_name", "gen_name", "node_id"}
+
+	promCurrentRPSDesc = prometheus.NewDesc("wasp_current_rps", "Scheduled RPS", promGeneratorLabels, nil)
+	promCurrentVUsDesc = prometheus.NewDesc("wasp_current_vus", "Scheduled VUs", promGeneratorLabels, nil)
+	promSegmentDesc    = prometheus.NewDesc("wasp_current_segment", "Current schedule segment", promGeneratorLabels, nil)
+)
+
+// PrometheusConfig is a configuration of an embedded Prometheus exporter
+type PrometheusConfig struct {
+	// Addr is a "host:port" to serve metrics on
+	Addr string
+	// Path is a metrics path, default is DefaultPrometheusPath
+	Path string
+	// Buckets are latency histogram buckets in seconds, default is prometheus.DefBuckets
+	Buckets []float64
+	// ScrapeWait keeps serving metrics after the run ends, so Prometheus can scrape the final values
+	ScrapeWait time.Duration
+}
+
+func (m *PrometheusConfig) Validate() error {
+	if m.Addr == "" {
+		return ErrPrometheusNoAddr
+	}
+	if m.Path == "" {
+		m.Path = DefaultPrometheusPath
+	}
+	if m.Buckets == nil {
+		m.Buckets = prometheus.DefBuckets
+	}
+	return nil
+}
+
+// PrometheusExporter serves metrics of one or many generators on /metrics
+type PrometheusExporter struct {
+	cfg      *PrometheusConfig
+	mu       *sync.Mutex
+	gens     []*Generator
+	registry *prometheus.Registry
+	success  *prometheus.CounterVec
+	failed   *prometheus.CounterVec
+	timeouts *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+	lateness *prometheus.HistogramVec
+	srv      *http.Server
+	listener net.Listener
+	stopOnce *sync.Once
+	l        zerolog.Logger
+}
+
+// NewPrometheusExporter creates a new exporter for generators
+func NewPrometheusExporter(cfg *PrometheusConfig, gens ...*Generator) (*PrometheusExporter, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	m := &PrometheusExporter{
+		cfg:      cfg,
+		mu:       &sync.Mutex{},
+		registry: prometheus.NewRegistry(),
+		success: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wasp_success_total",
+			Help: "Successful calls",
+		}, promResponseLabels),
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wasp_failed_total",
+			Help: "Failed calls",
+		}, promResponseLabels),
+		timeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "wasp_timeout_total",
+			Help: "Timed out calls",
+		}, promResponseLabels),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "wasp_response_duration_seconds",
+			Help:    "Call duration",
+			Buckets: cfg.Buckets,
+		}, promResponseLabels),
+		lateness: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "wasp_send_lateness_seconds",
+			Help:    "How late RPS calls were sent comparing to the schedule",
+			Buckets: cfg.Buckets,
+		}, promGeneratorLabels),
+		stopOnce: &sync.Once{},
+		l:        GetLogger(nil, "PrometheusExporter"),
+	}
+	m.registry.MustRegister(m.success, m.failed, m.timeouts, m.latency, m.lateness, m)
+	for _, g := range gens {
+		m.Add(g)
+	}
+	return m, nil
+}
+
+// Add adds a generator to the exporter
+func (m *PrometheusExporter) Add(g *Generator) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gens = append(m.gens, g)
+	g.promExporters = append(g.promExporters, m)
+}
+
+// Registry returns the exporter registry
+func (m *PrometheusExporter) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Addr returns the address exporter is listening on
+func (m *PrometheusExporter) Addr() string {
+	if m.listener == nil {
+		return m.cfg.Addr
+	}
+	return m.listener.Addr().String()
+}
+
+// Start starts serving metrics
+func (m *PrometheusExporter) Start() error {
+	if m.srv != nil {
+		return ErrPrometheusStarted
+	}
+	ln, err := net.Listen("tcp", m.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	m.listener = ln
+	mux := http.NewServeMux()
+	mux.Handle(m.cfg.Path, promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	m.srv = &http.Server{Handler: mux}
+	m.l.Info().Str("Addr", m.Addr()).Str("Path", m.cfg.Path).Msg("Prometheus exporter started")
+	go func() {
+		if err := m.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			m.l.Err(err).Msg("Prometheus exporter failed")
+		}
+	}()
+	return nil
+}
+
+// Stop waits PrometheusConfig.ScrapeWait and gracefully shuts down the exporter
+func (m *PrometheusExporter) Stop() {
+	if m.srv == nil {
+		return
+	}
+	m.stopOnce.Do(func() {
+		time.Sleep(m.cfg.ScrapeWait)
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultPrometheusShutdownTimeout)
+		defer cancel()
+		if err := m.srv.Shutdown(ctx); err != nil {
+			m.l.Err(err).Msg("Failed to stop prometheus exporter")
+		}
+		m.l.Info().Msg("Prometheus exporter exited")
+	})
+}
+
+// observe records a call of a generator
+func (m *PrometheusExporter) observe(g *Generator, res *Response) {
+	lvs := []string{g.testName(), g.Cfg.GenName, res.Group, g.Cfg.nodeID}
+	switch {
+	case res.Timeout:
+		m.timeouts.WithLabelValues(lvs...).Inc()
+	case res.Failed:
+		m.failed.WithLabelValues(lvs...).Inc()
+	default:
+		m.success.WithLabelValues(lvs...).Inc()
+	}
+	m.latency.WithLabelValues(lvs...).Observe(res.Duration.Seconds())
+	if res.CorrectedDuration > 0 {
+		m.lateness.WithLabelValues(g.testName(), g.Cfg.GenName, g.Cfg.nodeID).Observe(res.SendLateness().Seconds())
+	}
+}
+
+// Describe implements prometheus.Collector for generators gauges
+func (m *PrometheusExporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- promCurrentRPSDesc
+	ch <- promCurrentVUsDesc
+	ch <- promSegmentDesc
+}
+
+// Collect implements prometheus.Collector, gauges are read from generators stats on every scrape,
+// RPS and VUs of generators with the same labels are summed
+func (m *PrometheusExporter) Collect(ch chan<- prometheus.Metric) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	type gauges struct {
+		lvs               []string
+		rps, vus, segment float64
+	}
+	keys := make([][3]string, 0)
+	byLabels := make(map[[3]string]*gauges)
+	for _, g := range m.gens {
+		key := [3]string{g.testName(), g.Cfg.GenName, g.Cfg.nodeID}
+		v, ok := byLabels[key]
+		if !ok {
+			v = &gauges{lvs: key[:]}
+			byLabels[key] = v
+			keys = append(keys, key)
+		}
+		v.rps += float64(g.stats.CurrentRPS.Load())
+		v.vus += float64(g.stats.CurrentVUs.Load())
+		v.segment = max(v.segment, float64(g.stats.CurrentSegment.Load()))
+	}
+	for _, key := range keys {
+		v := byLabels[key]
+		ch <- prometheus.MustNewConstMetric(promCurrentRPSDesc, prometheus.GaugeValue, v.rps, v.lvs...)
+		ch <- prometheus.MustNewConstMetric(promCurrentVUsDesc, prometheus.GaugeValue, v.vus, v.lvs...)
+		ch <- prometheus.MustNewConstMetric(promSegmentDesc, prometheus.GaugeValue, v.segment, v.lvs...)
+	}
+}
+
+// observePrometheus records a call in all the exporters of a generator
+func (g *Generator) observePrometheus(res *Response) {
+	for _, e := range g.promExporters {
+		e.observe(g, res)
+	}
+}
+
+// testName returns the name of the test generator runs in, if any
+func (g *Generator) testName() string {
+	if g.Cfg.T == nil {
+		return ""
+	}
+	return g.Cfg.T.Name()
+}
@@ -0,0 +1,87 @@
+package wasp
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSmokePrometheusExporter(t *testing.T) {
+	t.Parallel()
+	t.Run("generator metrics", func(t *testing.T) {
+		t.Parallel()
+		gen, err := NewGenerator(&Config{
+			T:          t,
+			GenName:    "prom",
+			LoadType:   RPS,
+			Schedule:   Plain(20, 1*time.Second),
+			Prometheus: &PrometheusConfig{Addr: "127.0.0.1:0"},
+			Gun: NewMockGun(&MockGunConfig{
+				FailRatio: 50,
+				CallSleep: 10 * time.Millisecond,
+			}),
+		})
+		require.NoError(t, err)
+		gen.Run(false)
+		time.Sleep(500 * time.Millisecond)
+		resp, err := http.Get(fmt.Sprintf("http://%s/metrics", gen.prom.Addr()))
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Contains(t, string(body), fmt.Sprintf(`wasp_current_rps{gen_name="prom",go_test_name="%s",node_id=""} 20`, t.Name()))
+		require.Contains(t, string(body), "wasp_response_duration_seconds_bucket")
+		gen.Wait()
+
+		prom := gen.prom
+		success := testutil.ToFloat64(prom.success.WithLabelValues(t.Name(), "prom", "", ""))
+		failed := testutil.ToFloat64(prom.failed.WithLabelValues(t.Name(), "prom", "", ""))
+		require.Greater(t, success, 0.0)
+		require.Greater(t, failed, 0.0)
+		require.Equal(t, float64(gen.stats.Latencies.All.Count()), success+failed)
+		_, err = http.Get(fmt.Sprintf("http://%s/metrics", prom.Addr()))
+		require.Error(t, err)
+	})
+	t.Run("profile metrics with call groups", func(t *testing.T) {
+		t.Parallel()
+		p := NewProfile().WithPrometheus(&PrometheusConfig{Addr: "127.0.0.1:0"})
+		for i := 0; i < 2; i++ {
+			p.Add(NewGenerator(&Config{
+				T:        t,
+				LoadType: VU,
+				Schedule: Plain(1, 1*time.Second),
+				VU:       &groupVU{VUControl: NewVUControl()},
+			}))
+		}
+		_, err := p.Run(true)
+		require.NoError(t, err)
+		// both generators have the same labels, so their calls are counted in the same series
+		require.Equal(t, 2, testutil.CollectAndCount(p.prom.success))
+		require.Equal(t, 3, testutil.CollectAndCount(p.prom, "wasp_current_vus", "wasp_current_rps", "wasp_current_segment"))
+		var calls int64
+		for _, g := range p.Generators {
+			calls += g.stats.Latencies.All.Count()
+		}
+		fast := testutil.ToFloat64(p.prom.success.WithLabelValues(t.Name(), DefaultGenName, "fast", ""))
+		slow := testutil.ToFloat64(p.prom.success.WithLabelValues(t.Name(), DefaultGenName, "slow", ""))
+		require.Equal(t, float64(calls), fast+slow)
+	})
+	t.Run("validation", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewGenerator(&Config{
+			T:          t,
+			LoadType:   RPS,
+			Schedule:   Plain(1, 1*time.Second),
+			Gun:        NewMockGun(&MockGunConfig{}),
+			Prometheus: &PrometheusConfig{},
+		})
+		require.ErrorIs(t, err, ErrPrometheusNoAddr)
+		_, err = NewProfile().WithPrometheus(&PrometheusConfig{}).Run(true)
+		require.ErrorIs(t, err, ErrPrometheusNoAddr)
+	})
+}
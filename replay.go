@@ -0,0 +1,329 @@
+package wasp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Trace-driven replay of recorded arrival timestamps */
+
+const (
+	// DefaultReplaySegmentDuration is a length of schedule segments derived from a trace, used only for reporting
+	DefaultReplaySegmentDuration = 10 * time.Second
+)
+
+var (
+	ErrReplayEmptyTrace = errors.New("replay trace has no arrivals")
+	ErrReplaySpeed      = errors.New("replay speed must be >= 0")
+	ErrReplayVolume     = errors.New("replay volume must be >= 0")
+	ErrReplayLoadType   = errors.New("replay can only be used with wasp.RPS load type")
+	ErrTraceFormat      = errors.New("unknown trace format, use .csv or .jsonl")
+)
+
+// Trace is a recorded sequence of arrivals, offsets are relative to the first arrival and sorted
+type Trace struct {
+	Offsets []time.Duration
+}
+
+// NewTrace creates a trace from absolute arrival timestamps
+func NewTrace(timestamps []time.Time) *Trace {
+	if len(timestamps) == 0 {
+		return &Trace{Offsets: make([]time.Duration, 0)}
+	}
+	sorted := make([]time.Time, len(timestamps))
+	copy(sorted, timestamps)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+	offsets := make([]time.Duration, 0, len(sorted))
+	for _, ts := range sorted {
+		offsets = append(offsets, ts.Sub(sorted[0]))
+	}
+	return &Trace{Offsets: offsets}
+}
+
+// Duration returns the offset of the last arrival
+func (m *Trace) Duration() time.Duration {
+	if len(m.Offsets) == 0 {
+		return 0
+	}
+	return m.Offsets[len(m.Offsets)-1]
+}
+
+// LoadTrace reads a trace from a .csv or .jsonl file
+func LoadTrace(path string) (*Trace, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return ReadTraceCSV(f)
+	case ".jsonl", ".ndjson":
+		return ReadTraceJSONL(f)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrTraceFormat, path)
+	}
+}
+
+// ReadTraceCSV reads a trace from CSV, every row is either "timestamp" or "timestamp,count",
+// rows with a count are per-second counts spread evenly inside the second, header row is optional
+// timestamps are RFC3339 or unix seconds with an optional fraction
+func ReadTraceCSV(r io.Reader) (*Trace, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	timestamps := make([]time.Time, 0)
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		ts, err := parseTraceTimestamp(rec[0])
+		if err != nil {
+			if line == 1 {
+				// header
+				continue
+			}
+			return nil, fmt.Errorf("trace line %d: %w", line, err)
+		}
+		count := int64(1)
+		if len(rec) > 1 {
+			count, err = strconv.ParseInt(strings.TrimSpace(rec[1]), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("trace line %d: %w", line, err)
+			}
+			timestamps = appendPerSecond(timestamps, ts, count)
+			continue
+		}
+		timestamps = append(timestamps, ts)
+	}
+	return NewTrace(timestamps), nil
+}
+
+// traceRecord is a JSONL trace record, timestamp is RFC3339 string or unix seconds
+type traceRecord struct {
+	Timestamp json.RawMessage `json:"timestamp"`
+	Count     *int64          `json:"count"`
+}
+
+// ReadTraceJSONL reads a trace from JSONL, every line is {"timestamp": ...} or {"timestamp": ..., "count": N},
+// records with a count are per-second counts spread evenly inside the second
+// timestamps are RFC3339 strings or unix seconds with an optional fraction
+func ReadTraceJSONL(r io.Reader) (*Trace, error) {
+	sc := bufio.NewScanner(r)
+	timestamps := make([]time.Time, 0)
+	for line := 1; sc.Scan(); line++ {
+		b := bytes.TrimSpace(sc.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		var rec traceRecord
+		if err := json.Unmarshal(b, &rec); err != nil {
+			return nil, fmt.Errorf("trace line %d: %w", line, err)
+		}
+		raw := string(rec.Timestamp)
+		if strings.HasPrefix(raw, "\"") {
+			if err := json.Unmarshal(rec.Timestamp, &raw); err != nil {
+				return nil, fmt.Errorf("trace line %d: %w", line, err)
+			}
+		}
+		ts, err := parseTraceTimestamp(raw)
+		if err != nil {
+			return nil, fmt.Errorf("trace line %d: %w", line, err)
+		}
+		if rec.Count != nil {
+			timestamps = appendPerSecond(timestamps, ts, *rec.Count)
+			continue
+		}
+		timestamps = append(timestamps, ts)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return NewTrace(timestamps), nil
+}
+
+// appendPerSecond spreads "count" arrivals evenly inside the second starting at ts
+func appendPerSecond(timestamps []time.Time, ts time.Time, count int64) []time.Time {
+	for i := int64(0); i < count; i++ {
+		timestamps = append(timestamps, ts.Add(time.Duration(i)*time.Second/time.Duration(count)))
+	}
+	return timestamps
+}
+
+func parseTraceTimestamp(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if ts, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return ts, nil
+	}
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q, use RFC3339 or unix seconds", s)
+	}
+	whole, frac := math.Modf(secs)
+	return time.Unix(int64(whole), int64(math.Round(frac*1e9))), nil
+}
+
+// ReplayConfig replays a trace instead of pacing calls with a rate limiter
+type ReplayConfig struct {
+	Trace *Trace
+	// Speed is a time scale, 2 replays the trace 2x faster, default is 1
+	Speed float64
+	// Volume is a calls multiplier, 3 fires 3 calls for every arrival, 0.5 fires every second arrival, default is 1
+	Volume float64
+	// SegmentDuration is a length of schedule segments derived from the trace, used only for reporting current RPS
+	SegmentDuration time.Duration
+}
+
+func (m *ReplayConfig) Validate() error {
+	if m.Trace == nil || len(m.Trace.Offsets) == 0 {
+		return ErrReplayEmptyTrace
+	}
+	if m.Speed < 0 {
+		return ErrReplaySpeed
+	}
+	if m.Speed == 0 {
+		m.Speed = 1
+	}
+	if m.Volume < 0 {
+		return ErrReplayVolume
+	}
+	if m.Volume == 0 {
+		m.Volume = 1
+	}
+	if m.SegmentDuration == 0 {
+		m.SegmentDuration = DefaultReplaySegmentDuration
+	}
+	// Volume < 1 can drop all the arrivals of a short trace
+	if len(m.Offsets()) == 0 {
+		return ErrReplayEmptyTrace
+	}
+	return nil
+}
+
+// Offsets returns arrival offsets scaled by Speed and Volume
+func (m *ReplayConfig) Offsets() []time.Duration {
+	offsets := make([]time.Duration, 0, int(float64(len(m.Trace.Offsets))*m.Volume))
+	for i, o := range m.Trace.Offsets {
+		calls := int(math.Floor(float64(i+1)*m.Volume) - math.Floor(float64(i)*m.Volume))
+		scaled := time.Duration(float64(o) / m.Speed)
+		for c := 0; c < calls; c++ {
+			offsets = append(offsets, scaled)
+		}
+	}
+	return offsets
+}
+
+// Schedule derives plain segments with an average rate per "unit" for every SegmentDuration of the scaled trace
+// the last segment ends after the last arrival
+func (m *ReplayConfig) Schedule(unit time.Duration) []*Segment {
+	offsets := m.Offsets()
+	bins := int(offsets[len(offsets)-1]/m.SegmentDuration) + 1
+	counts := make([]int64, bins)
+	for _, o := range offsets {
+		counts[int(o/m.SegmentDuration)]++
+	}
+	segments := make([]*Segment, 0)
+	for _, c := range counts {
+		rate := clampSegmentValue(int64(math.Round(float64(c) * float64(unit) / float64(m.SegmentDuration))))
+		if len(segments) > 0 && segments[len(segments)-1].From == rate {
+			segments[len(segments)-1].Duration += m.SegmentDuration
+			continue
+		}
+		segments = append(segments, &Segment{From: rate, Duration: m.SegmentDuration})
+	}
+	return segments
+}
+
+// replayLimiter is a ratelimit.Limiter that blocks until the next arrival of a trace,
+// the trace starts on the first Take, when the trace is over it blocks until ctx is done
+type replayLimiter struct {
+	mu      sync.Mutex
+	ctx     context.Context
+	offsets []time.Duration
+	idx     int
+	start   time.Time
+	// pausedAt is when the generator was paused, zero if it is running
+	pausedAt time.Time
+}
+
+func newReplayLimiter(ctx context.Context, offsets []time.Duration) *replayLimiter {
+	return &replayLimiter{ctx: ctx, offsets: offsets}
+}
+
+// Take blocks until the next arrival
+func (m *replayLimiter) Take() time.Time {
+	_, released := m.take()
+	return released
+}
+
+// TakeIntended blocks until the next arrival and returns its time in the trace
+func (m *replayLimiter) TakeIntended() time.Time {
+	intended, _ := m.take()
+	return intended
+}
+
+// pause remembers when the generator was paused
+func (m *replayLimiter) pause() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.pausedAt.IsZero() {
+		m.pausedAt = time.Now()
+	}
+}
+
+// resume shifts the rest of the trace by the paused duration, so arrivals missed during the pause are not sent at once
+func (m *replayLimiter) resume() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.start.IsZero() && !m.pausedAt.IsZero() {
+		m.start = m.start.Add(time.Since(m.pausedAt))
+	}
+	m.pausedAt = time.Time{}
+}
+
+// take returns when the arrival is in the trace and when it was released
+func (m *replayLimiter) take() (time.Time, time.Time) {
+	m.mu.Lock()
+	if m.start.IsZero() {
+		m.start = time.Now()
+	}
+	if m.idx >= len(m.offsets) {
+		m.mu.Unlock()
+		<-m.ctx.Done()
+		now := time.Now()
+		return now, now
+	}
+	at := m.start.Add(m.offsets[m.idx])
+	m.idx++
+	m.mu.Unlock()
+	d := time.Until(at)
+	if d <= 0 {
+		return at, time.Now()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-m.ctx.Done():
+		return at, time.Now()
+	case <-t.C:
+		return at, at
+	}
+}
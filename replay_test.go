@@ -0,0 +1,118 @@
+package wasp
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSmokeReadTrace(t *testing.T) {
+	t.Parallel()
+	expected := []time.Duration{0, 500 * time.Millisecond, 1 * time.Second, 1500 * time.Millisecond, 2 * time.Second}
+	t.Run("csv timestamps", func(t *testing.T) {
+		t.Parallel()
+		tr, err := ReadTraceCSV(strings.NewReader("timestamp\n1700000001.5\n2023-11-14T22:13:20Z\n1700000001\n1700000000.5\n1700000002\n"))
+		require.NoError(t, err)
+		require.Equal(t, expected, tr.Offsets)
+	})
+	t.Run("csv per-second counts", func(t *testing.T) {
+		t.Parallel()
+		tr, err := ReadTraceCSV(strings.NewReader("second,count\n1700000000,2\n1700000001,2\n1700000002,1\n"))
+		require.NoError(t, err)
+		require.Equal(t, expected, tr.Offsets)
+	})
+	t.Run("jsonl", func(t *testing.T) {
+		t.Parallel()
+		tr, err := ReadTraceJSONL(strings.NewReader(`{"timestamp": "2023-11-14T22:13:20Z"}
+{"timestamp": 1700000000.5}
+
+{"timestamp": "2023-11-14T22:13:21Z", "count": 2}
+{"timestamp": 1700000002}
+`))
+		require.NoError(t, err)
+		require.Equal(t, expected, tr.Offsets)
+	})
+	t.Run("invalid timestamp", func(t *testing.T) {
+		t.Parallel()
+		_, err := ReadTraceCSV(strings.NewReader("1700000000\nyesterday\n"))
+		require.ErrorContains(t, err, "trace line 2")
+	})
+}
+
+func TestSmokeReplayScaling(t *testing.T) {
+	t.Parallel()
+	r := &ReplayConfig{
+		Trace:  &Trace{Offsets: []time.Duration{0, 1 * time.Second, 2 * time.Second, 3 * time.Second}},
+		Speed:  2,
+		Volume: 1.5,
+	}
+	require.NoError(t, r.Validate())
+	require.Equal(t, []time.Duration{
+		0,
+		500 * time.Millisecond, 500 * time.Millisecond,
+		1 * time.Second,
+		1500 * time.Millisecond, 1500 * time.Millisecond,
+	}, r.Offsets())
+	require.Equal(t, []*Segment{{From: 1, Duration: DefaultReplaySegmentDuration}}, r.Schedule(1*time.Second))
+	require.Equal(t, ErrReplayEmptyTrace, (&ReplayConfig{}).Validate())
+	require.Equal(t, ErrReplaySpeed, (&ReplayConfig{Trace: r.Trace, Speed: -1}).Validate())
+	require.Equal(t, ErrReplayEmptyTrace, (&ReplayConfig{Trace: &Trace{Offsets: []time.Duration{0}}, Volume: 0.5}).Validate())
+	_, err := NewGenerator(&Config{
+		T:        t,
+		LoadType: RPS,
+		Replay:   &ReplayConfig{Trace: &Trace{Offsets: []time.Duration{0, time.Second, 2 * time.Second}}, Volume: 0.3},
+		Gun:      NewMockGun(&MockGunConfig{}),
+	})
+	require.ErrorIs(t, err, ErrReplayEmptyTrace)
+}
+
+func TestSmokeReplay(t *testing.T) {
+	t.Parallel()
+	offsets := make([]time.Duration, 0)
+	for i := 0; i < 20; i++ {
+		offsets = append(offsets, time.Duration(i)*80*time.Millisecond)
+	}
+	gen, err := NewGenerator(&Config{
+		T:        t,
+		LoadType: RPS,
+		Replay: &ReplayConfig{
+			Trace:           &Trace{Offsets: offsets},
+			Speed:           2,
+			Volume:          2,
+			SegmentDuration: 500 * time.Millisecond,
+		},
+		Gun: NewMockGun(&MockGunConfig{
+			CallSleep: 10 * time.Millisecond,
+		}),
+	})
+	require.NoError(t, err)
+	startedAt := time.Now()
+	_, failed := gen.Run(true)
+	require.Equal(t, false, failed)
+	require.Less(t, time.Since(startedAt), 3*time.Second)
+	require.Equal(t, int64(40), gen.Stats().Success.Load())
+	require.Equal(t, []*Segment{
+		{From: 52, Duration: 500 * time.Millisecond},
+		{From: 28, Duration: 500 * time.Millisecond},
+	}, gen.Cfg.Schedule)
+}
+
+func TestSmokeReplayPauseResume(t *testing.T) {
+	t.Parallel()
+	l := newReplayLimiter(context.Background(), []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond})
+	start := l.Take()
+	l.pause()
+	time.Sleep(500 * time.Millisecond)
+	l.resume()
+	prev := time.Now()
+	for i := 1; i < 4; i++ {
+		intended := l.TakeIntended()
+		// arrivals missed during the pause are shifted, they are not released at once
+		require.GreaterOrEqual(t, time.Since(prev), 80*time.Millisecond)
+		require.GreaterOrEqual(t, intended.Sub(start), 500*time.Millisecond+time.Duration(i)*100*time.Millisecond)
+		prev = time.Now()
+	}
+}
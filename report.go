@@ -0,0 +1,416 @@
+package wasp
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+/* End-of-run summary reports in JSON, Markdown and HTML */
+
+const (
+	// DefaultReportTopErrors is the amount of most frequent errors in a report
+	DefaultReportTopErrors = 10
+)
+
+// segmentRun is a schedule segment that was run by a generator
+type segmentRun struct {
+	index      int
+	segment    *Segment
+	startedAt  time.Time
+	finishedAt time.Time
+	requests   atomic.Int64
+}
+
+// Report is a summary of a finished Profile or Generator
+type Report struct {
+	Name        string             `json:"name"`
+	GeneratedAt time.Time          `json:"generated_at"`
+	Generators  []*GeneratorReport `json:"generators"`
+}
+
+// GeneratorReport is a summary of one generator, success is calculated from all the calls, not only recorded samples,
+// corrected latency and send lateness are reported for RPS generators, see Response.Corrected
+type GeneratorReport struct {
+	Name             string           `json:"name"`
+	LoadType         ScheduleType     `json:"load_type"`
+	Requests         int64            `json:"requests"`
+	Success          int64            `json:"success"`
+	Failed           int64            `json:"failed"`
+	Timeouts         int64            `json:"timeouts"`
+	Dropped          int64            `json:"dropped"`
+	RunFailed        bool             `json:"run_failed"`
+	Latency          *LatencyReport   `json:"latency"`
+	CorrectedLatency *LatencyReport   `json:"corrected_latency,omitempty"`
+	SendLateness     *LatencyReport   `json:"send_lateness,omitempty"`
+	Groups           []*GroupReport   `json:"groups"`
+	Segments         []*SegmentReport `json:"segments"`
+	TopErrors        []*ErrorReport   `json:"top_errors"`
+}
+
+// LatencyReport is a latency histogram summary in milliseconds
+type LatencyReport struct {
+	Count       int64               `json:"count"`
+	MinMs       float64             `json:"min_ms"`
+	MeanMs      float64             `json:"mean_ms"`
+	MaxMs       float64             `json:"max_ms"`
+	Percentiles []*PercentileReport `json:"percentiles"`
+}
+
+// PercentileReport is a latency percentile in milliseconds
+type PercentileReport struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value_ms"`
+}
+
+// GroupReport is a summary of one Response.Group, timeouts are not counted as failed
+type GroupReport struct {
+	Name     string         `json:"name"`
+	Success  int64          `json:"success"`
+	Failed   int64          `json:"failed"`
+	Timeouts int64          `json:"timeouts"`
+	Latency  *LatencyReport `json:"latency"`
+}
+
+func newGroupReport(l *Latencies, name string, percentiles []float64) *GroupReport {
+	c := l.GroupCounts(name)
+	return &GroupReport{
+		Name:     name,
+		Success:  c.Success.Load(),
+		Failed:   c.Failed.Load(),
+		Timeouts: c.Timeouts.Load(),
+		Latency:  newLatencyReport(l.Group(name), percentiles),
+	}
+}
+
+// SegmentReport compares scheduled and achieved load of a segment, ramps are scheduled with their average value
+type SegmentReport struct {
+	Index             int     `json:"index"`
+	From              int64   `json:"from"`
+	To                int64   `json:"to,omitempty"`
+	ScheduledDuration float64 `json:"scheduled_duration_s"`
+	ActualDuration    float64 `json:"actual_duration_s"`
+	Requests          int64   `json:"requests"`
+	ScheduledRPS      float64 `json:"scheduled_rps,omitempty"`
+	ScheduledVUs      float64 `json:"scheduled_vus,omitempty"`
+	AchievedRPS       float64 `json:"achieved_rps"`
+}
+
+// ErrorReport is an error with the amount of its occurrences
+type ErrorReport struct {
+	Error string `json:"error"`
+	Count int    `json:"count"`
+}
+
+// NewReport creates a report of generators
+func NewReport(name string, gens ...*Generator) *Report {
+	r := &Report{
+		Name:        name,
+		GeneratedAt: time.Now(),
+		Generators:  make([]*GeneratorReport, 0),
+	}
+	for _, g := range gens {
+		r.Generators = append(r.Generators, g.Report())
+	}
+	return r
+}
+
+// Report creates a report of all the profile generators
+func (m *Profile) Report() *Report {
+	return NewReport(fmt.Sprintf("profile_%s", m.ProfileID), m.Generators...)
+}
+
+// Report creates a summary of the generator
+func (g *Generator) Report() *GeneratorReport {
+	stats := g.stats
+	requests := stats.Latencies.All.Count()
+	r := &GeneratorReport{
+		Name:      g.Cfg.GenName,
+		LoadType:  g.Cfg.LoadType,
+		Requests:  requests,
+		Success:   max(0, requests-stats.Failed.Load()),
+		Failed:    stats.Failed.Load() - stats.CallTimeout.Load(),
+		Timeouts:  stats.CallTimeout.Load(),
+		Dropped:   stats.Dropped.Load(),
+		RunFailed: stats.RunFailed.Load(),
+		Latency:   newLatencyReport(stats.Latencies.All, g.Cfg.Percentiles),
+		Groups:    make([]*GroupReport, 0),
+		Segments:  g.segmentReports(),
+		TopErrors: g.topErrors(DefaultReportTopErrors),
+	}
+	if g.Cfg.LoadType == RPS {
+		r.CorrectedLatency = newLatencyReport(stats.Latencies.Corrected, g.Cfg.Percentiles)
+		r.SendLateness = newLatencyReport(stats.Latencies.SendLateness, g.Cfg.Percentiles)
+	}
+	for _, name := range stats.Latencies.Groups() {
+		r.Groups = append(r.Groups, newGroupReport(stats.Latencies, name, g.Cfg.Percentiles))
+	}
+	return r
+}
+
+func newLatencyReport(h *LatencyHistogram, percentiles []float64) *LatencyReport {
+	r := &LatencyReport{
+		Count:       h.Count(),
+		MinMs:       durationMs(h.Min()),
+		MeanMs:      durationMs(h.Mean()),
+		MaxMs:       durationMs(h.Max()),
+		Percentiles: make([]*PercentileReport, 0),
+	}
+	for _, p := range percentiles {
+		r.Percentiles = append(r.Percentiles, &PercentileReport{Name: PercentileKey(p), Value: durationMs(h.Percentile(p))})
+	}
+	return r
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// segmentReports compares scheduled and achieved load for every segment that was run
+func (g *Generator) segmentReports() []*SegmentReport {
+	g.scheduleMu.Lock()
+	defer g.scheduleMu.Unlock()
+	res := make([]*SegmentReport, 0)
+	for i, sr := range g.segmentRuns {
+		finishedAt := sr.finishedAt
+		if finishedAt.IsZero() {
+			finishedAt = time.Now()
+		}
+		actual := finishedAt.Sub(sr.startedAt)
+		scheduled := float64(sr.segment.From)
+		if sr.segment.IsRamp() {
+			scheduled = float64(sr.segment.From+sr.segment.To) / 2
+		}
+		r := &SegmentReport{
+			Index:             i + 1,
+			From:              sr.segment.From,
+			To:                sr.segment.To,
+			ScheduledDuration: sr.segment.Duration.Seconds(),
+			ActualDuration:    actual.Seconds(),
+			Requests:          sr.requests.Load(),
+		}
+		if actual > 0 {
+			r.AchievedRPS = float64(r.Requests) / actual.Seconds()
+		}
+		if g.Cfg.LoadType == RPS {
+			r.ScheduledRPS = scheduled * float64(time.Second) / float64(g.Cfg.RateLimitUnitDuration)
+		} else {
+			r.ScheduledVUs = scheduled
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+// topErrors returns the most frequent errors
+func (g *Generator) topErrors(n int) []*ErrorReport {
+	counts := make(map[string]int)
+	for _, e := range g.Errors() {
+		counts[e]++
+	}
+	res := make([]*ErrorReport, 0, len(counts))
+	for e, c := range counts {
+		res = append(res, &ErrorReport{Error: e, Count: c})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count == res[j].Count {
+			return res[i].Error < res[j].Error
+		}
+		return res[i].Count > res[j].Count
+	})
+	if len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+// JSON renders the report as indented JSON
+func (m *Report) JSON() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// Markdown renders the report as Markdown tables
+func (m *Report) Markdown() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("# Load test report: %s\n\nGenerated at: %s\n", m.Name, m.GeneratedAt.Format(time.RFC3339)))
+	for _, g := range m.Generators {
+		sb.WriteString(fmt.Sprintf("\n## Generator: %s (%s)\n\n", g.Name, g.LoadType))
+		sb.WriteString("| Requests | Success | Failed | Timeouts | Dropped | Run failed |\n")
+		sb.WriteString("|---|---|---|---|---|---|\n")
+		sb.WriteString(fmt.Sprintf("| %d | %d | %d | %d | %d | %t |\n", g.Requests, g.Success, g.Failed, g.Timeouts, g.Dropped, g.RunFailed))
+
+		sb.WriteString("\n### Latency, ms\n\n")
+		sb.WriteString("| Group | Requests | Min | Mean |")
+		for _, p := range g.Latency.Percentiles {
+			sb.WriteString(fmt.Sprintf(" %s |", p.Name))
+		}
+		sb.WriteString(" Max |\n|---|---|---|---|")
+		sb.WriteString(strings.Repeat("---|", len(g.Latency.Percentiles)+1))
+		sb.WriteString("\n")
+		sb.WriteString(markdownLatencyRow("all", g.Latency))
+		if g.CorrectedLatency != nil {
+			sb.WriteString(markdownLatencyRow("all, corrected", g.CorrectedLatency))
+			sb.WriteString(markdownLatencyRow("send lateness", g.SendLateness))
+		}
+		for _, gr := range g.Groups {
+			sb.WriteString(markdownLatencyRow(gr.Name, gr.Latency))
+		}
+
+		if len(g.Groups) > 0 {
+			sb.WriteString("\n### Groups\n\n")
+			sb.WriteString("| Group | Success | Failed | Timeouts |\n|---|---|---|---|\n")
+			for _, gr := range g.Groups {
+				sb.WriteString(fmt.Sprintf("| %s | %d | %d | %d |\n", escapeMarkdownCell(gr.Name), gr.Success, gr.Failed, gr.Timeouts))
+			}
+		}
+
+		sb.WriteString("\n### Segments\n\n")
+		sb.WriteString("| # | From | To | Duration, s | Actual duration, s | Requests | Scheduled | Achieved RPS |\n")
+		sb.WriteString("|---|---|---|---|---|---|---|---|\n")
+		for _, s := range g.Segments {
+			sb.WriteString(fmt.Sprintf("| %d | %d | %d | %.2f | %.2f | %d | %s | %.2f |\n",
+				s.Index, s.From, s.To, s.ScheduledDuration, s.ActualDuration, s.Requests, s.Scheduled(), s.AchievedRPS))
+		}
+
+		if len(g.TopErrors) > 0 {
+			sb.WriteString("\n### Top errors\n\n")
+			sb.WriteString("| Count | Error |\n|---|---|\n")
+			for _, e := range g.TopErrors {
+				sb.WriteString(fmt.Sprintf("| %d | %s |\n", e.Count, escapeMarkdownCell(e.Error)))
+			}
+		}
+	}
+	return sb.String()
+}
+
+// Scheduled formats scheduled RPS or VUs
+func (m *SegmentReport) Scheduled() string {
+	if m.ScheduledVUs > 0 {
+		return fmt.Sprintf("%.2f VUs", m.ScheduledVUs)
+	}
+	return fmt.Sprintf("%.2f RPS", m.ScheduledRPS)
+}
+
+func markdownLatencyRow(name string, l *LatencyReport) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("| %s | %d | %.2f | %.2f |", escapeMarkdownCell(name), l.Count, l.MinMs, l.MeanMs))
+	for _, p := range l.Percentiles {
+		sb.WriteString(fmt.Sprintf(" %.2f |", p.Value))
+	}
+	sb.WriteString(fmt.Sprintf(" %.2f |\n", l.MaxMs))
+	return sb.String()
+}
+
+func escapeMarkdownCell(s string) string {
+	return strings.NewReplacer("|", "\\|", "\r", " ", "\n", " ").Replace(s)
+}
+
+var reportHTMLTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"ms": func(v float64) string { return fmt.Sprintf("%.2f", v) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Load test report: {{.Name}}</title>
+<style>
+body { font-family: -apple-system, Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: right; }
+th { background: #f2f2f2; }
+td.text { text-align: left; }
+.failed { color: #c0392b; font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>Load test report: {{.Name}}</h1>
+<p>Generated at: {{.GeneratedAt.Format "2006-01-02T15:04:05Z07:00"}}</p>
+{{- range .Generators}}
+<h2>Generator: {{.Name}} ({{.LoadType}})</h2>
+<table>
+<tr><th>Requests</th><th>Success</th><th>Failed</th><th>Timeouts</th><th>Dropped</th><th>Run failed</th></tr>
+<tr><td>{{.Requests}}</td><td>{{.Success}}</td><td>{{.Failed}}</td><td>{{.Timeouts}}</td><td>{{.Dropped}}</td><td{{if .RunFailed}} class="failed"{{end}}>{{.RunFailed}}</td></tr>
+</table>
+<h3>Latency, ms</h3>
+<table>
+<tr><th>Group</th><th>Requests</th><th>Min</th><th>Mean</th>{{range .Latency.Percentiles}}<th>{{.Name}}</th>{{end}}<th>Max</th></tr>
+<tr><td class="text">all</td><td>{{.Latency.Count}}</td><td>{{ms .Latency.MinMs}}</td><td>{{ms .Latency.MeanMs}}</td>{{range .Latency.Percentiles}}<td>{{ms .Value}}</td>{{end}}<td>{{ms .Latency.MaxMs}}</td></tr>
+{{- with .CorrectedLatency}}
+<tr><td class="text">all, corrected</td><td>{{.Count}}</td><td>{{ms .MinMs}}</td><td>{{ms .MeanMs}}</td>{{range .Percentiles}}<td>{{ms .Value}}</td>{{end}}<td>{{ms .MaxMs}}</td></tr>
+{{- end}}
+{{- with .SendLateness}}
+<tr><td class="text">send lateness</td><td>{{.Count}}</td><td>{{ms .MinMs}}</td><td>{{ms .MeanMs}}</td>{{range .Percentiles}}<td>{{ms .Value}}</td>{{end}}<td>{{ms .MaxMs}}</td></tr>
+{{- end}}
+{{- range .Groups}}
+<tr><td class="text">{{.Name}}</td><td>{{.Latency.Count}}</td><td>{{ms .Latency.MinMs}}</td><td>{{ms .Latency.MeanMs}}</td>{{range .Latency.Percentiles}}<td>{{ms .Value}}</td>{{end}}<td>{{ms .Latency.MaxMs}}</td></tr>
+{{- end}}
+</table>
+{{- if .Groups}}
+<h3>Groups</h3>
+<table>
+<tr><th>Group</th><th>Success</th><th>Failed</th><th>Timeouts</th></tr>
+{{- range .Groups}}
+<tr><td class="text">{{.Name}}</td><td>{{.Success}}</td><td>{{.Failed}}</td><td>{{.Timeouts}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+<h3>Segments</h3>
+<table>
+<tr><th>#</th><th>From</th><th>To</th><th>Duration, s</th><th>Actual duration, s</th><th>Requests</th><th>Scheduled</th><th>Achieved RPS</th></tr>
+{{- range .Segments}}
+<tr><td>{{.Index}}</td><td>{{.From}}</td><td>{{.To}}</td><td>{{ms .ScheduledDuration}}</td><td>{{ms .ActualDuration}}</td><td>{{.Requests}}</td><td>{{.Scheduled}}</td><td>{{ms .AchievedRPS}}</td></tr>
+{{- end}}
+</table>
+{{- if .TopErrors}}
+<h3>Top errors</h3>
+<table>
+<tr><th>Count</th><th>Error</th></tr>
+{{- range .TopErrors}}
+<tr><td>{{.Count}}</td><td class="text">{{.Error}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+// HTML renders the report as a self-contained HTML page
+func (m *Report) HTML() (string, error) {
+	var sb strings.Builder
+	if err := reportHTMLTemplate.Execute(&sb, m); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+var reportFileNameRe = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// WriteFiles writes the report to dir as <name>.json, <name>.md and <name>.html
+func (m *Report) WriteFiles(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	base := filepath.Join(dir, reportFileNameRe.ReplaceAllString(m.Name, "_"))
+	js, err := m.JSON()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(base+".json", js, 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(base+".md", []byte(m.Markdown()), 0o600); err != nil {
+		return err
+	}
+	html, err := m.HTML()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(base+".html", []byte(html), 0o600)
+}
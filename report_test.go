@@ -0,0 +1,155 @@
+package wasp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSmokeGeneratorReport(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	gen, err := NewGenerator(&Config{
+		T:           t,
+		GenName:     "report_gen",
+		LoadType:    RPS,
+		Schedule:    Combine(Plain(20, 1*time.Second), Plain(40, 1*time.Second)),
+		Percentiles: []float64{50, 99},
+		ReportDir:   dir,
+		SamplerConfig: &SamplerConfig{
+			SuccessfulCallResultRecordRatio: 0,
+		},
+		Gun: NewMockGun(&MockGunConfig{
+			FailRatio: 50,
+			CallSleep: 10 * time.Millisecond,
+		}),
+	})
+	require.NoError(t, err)
+	_, failed := gen.Run(true)
+	require.Equal(t, true, failed)
+
+	r := gen.Report()
+	require.Equal(t, "report_gen", r.Name)
+	require.Equal(t, r.Requests, r.Success+r.Failed+r.Timeouts)
+	require.Greater(t, r.Success, int64(0))
+	require.Greater(t, r.Failed, int64(0))
+	require.Equal(t, []*ErrorReport{{Error: "error", Count: int(r.Failed)}}, r.TopErrors)
+	require.Len(t, r.Latency.Percentiles, 2)
+	require.Equal(t, "p50", r.Latency.Percentiles[0].Name)
+	require.GreaterOrEqual(t, r.Latency.Percentiles[0].Value, 10.0)
+
+	require.Len(t, r.Segments, 2)
+	require.Equal(t, 20.0, r.Segments[0].ScheduledRPS)
+	require.Equal(t, 40.0, r.Segments[1].ScheduledRPS)
+	require.InDelta(t, 1.0, r.Segments[0].ActualDuration, 0.1)
+	require.InDelta(t, 20, r.Segments[0].AchievedRPS, 5)
+	require.InDelta(t, 40, r.Segments[1].AchievedRPS, 8)
+	require.Equal(t, r.Requests, r.Segments[0].Requests+r.Segments[1].Requests)
+
+	js, err := os.ReadFile(filepath.Join(dir, "report_gen.json"))
+	require.NoError(t, err)
+	var fromFile Report
+	require.NoError(t, json.Unmarshal(js, &fromFile))
+	require.Equal(t, "report_gen", fromFile.Name)
+	require.Len(t, fromFile.Generators, 1)
+	require.Equal(t, r.Requests, fromFile.Generators[0].Requests)
+
+	md, err := os.ReadFile(filepath.Join(dir, "report_gen.md"))
+	require.NoError(t, err)
+	require.Contains(t, string(md), "## Generator: report_gen (rps_schedule)")
+	require.Contains(t, string(md), "| Group | Requests | Min | Mean | p50 | p99 | Max |")
+	require.Contains(t, string(md), "20.00 RPS")
+	require.Contains(t, string(md), "### Top errors")
+
+	html, err := os.ReadFile(filepath.Join(dir, "report_gen.html"))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(html), "<!DOCTYPE html>"))
+	require.Contains(t, string(html), "<h2>Generator: report_gen (rps_schedule)</h2>")
+	require.NotContains(t, string(html), "<link")
+}
+
+func TestSmokeProfileReport(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	p := NewProfile().WithReport(dir)
+	p.Add(NewGenerator(&Config{
+		T:        t,
+		GenName:  "rps",
+		LoadType: RPS,
+		Schedule: Plain(5, 1*time.Second),
+		Gun:      NewMockGun(&MockGunConfig{CallSleep: 10 * time.Millisecond}),
+	}))
+	p.Add(NewGenerator(&Config{
+		T:        t,
+		GenName:  "vu",
+		LoadType: VU,
+		Schedule: Plain(2, 1*time.Second),
+		VU:       &groupVU{VUControl: NewVUControl()},
+	}))
+	_, err := p.Run(true)
+	require.NoError(t, err)
+
+	r := p.Report()
+	require.Len(t, r.Generators, 2)
+	require.Equal(t, 2.0, r.Generators[1].Segments[0].ScheduledVUs)
+	require.Len(t, r.Generators[1].Groups, 2)
+	require.Contains(t, r.Markdown(), "| fast |")
+	for _, ext := range []string{".json", ".md", ".html"} {
+		_, err := os.Stat(filepath.Join(dir, "profile_"+p.ProfileID+ext))
+		require.NoError(t, err)
+	}
+	// the report is written once
+	require.NoError(t, os.Remove(filepath.Join(dir, "profile_"+p.ProfileID+".json")))
+	p.Wait()
+	_, err = os.Stat(filepath.Join(dir, "profile_"+p.ProfileID+".json"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+type groupResultsVU struct {
+	*VUControl
+}
+
+func (m *groupResultsVU) Clone(_ *Generator) VirtualUser {
+	return &groupResultsVU{VUControl: NewVUControl()}
+}
+func (m *groupResultsVU) Setup(_ *Generator) error    { return nil }
+func (m *groupResultsVU) Teardown(_ *Generator) error { return nil }
+
+func (m *groupResultsVU) Call(l *Generator) {
+	time.Sleep(50 * time.Millisecond)
+	l.ResponsesChan <- &Response{Group: "login", Duration: time.Millisecond}
+	l.ResponsesChan <- &Response{Group: "login", Duration: time.Millisecond, Failed: true, Error: "denied"}
+	l.ResponsesChan <- &Response{Group: "search", Duration: time.Millisecond, Timeout: true, Error: "timeout"}
+}
+
+func TestSmokeGroupReport(t *testing.T) {
+	t.Parallel()
+	gen, err := NewGenerator(&Config{
+		T:        t,
+		GenName:  "groups",
+		LoadType: VU,
+		Schedule: Plain(1, 1*time.Second),
+		VU:       &groupResultsVU{VUControl: NewVUControl()},
+	})
+	require.NoError(t, err)
+	gen.Run(true)
+	r := NewReport("groups", gen).Generators[0]
+	require.Len(t, r.Groups, 2)
+	login, search := r.Groups[0], r.Groups[1]
+	require.Equal(t, "login", login.Name)
+	require.Greater(t, login.Success, int64(0))
+	require.Equal(t, login.Success, login.Failed)
+	require.Zero(t, login.Timeouts)
+	require.Equal(t, login.Success+login.Failed, login.Latency.Count)
+	require.Zero(t, search.Success+search.Failed)
+	require.Equal(t, search.Latency.Count, search.Timeouts)
+	md := NewReport("groups", gen).Markdown()
+	require.Contains(t, md, "| Group | Success | Failed | Timeouts |")
+	require.Contains(t, md, fmt.Sprintf("| login | %d | %d | 0 |", login.Success, login.Failed))
+}
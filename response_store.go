@@ -0,0 +1,319 @@
+package wasp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/* Disk-backed storage of all the recorded responses */
+
+const (
+	DefaultResponseStoreMaxBytes     = 10 << 30
+	DefaultResponseStoreSegmentBytes = 64 << 20
+	responseStoreSegmentName         = "responses"
+)
+
+var (
+	ErrResponseStoreNoDir  = errors.New("response store dir must be set")
+	ErrResponseStoreSize   = errors.New("response store max bytes and segment bytes must be >= 0, segment bytes must be <= max bytes")
+	ErrResponseStoreOpen   = errors.New("response store can be read only after the generator finished, call Wait() first")
+	ErrResponseStoreClosed = errors.New("response store is closed")
+)
+
+// ResponseStoreConfig is a configuration of a disk store that keeps every recorded response
+type ResponseStoreConfig struct {
+	// Dir is a directory for segments, it is created if needed, segments of previous runs with the same name are kept
+	// while they fit the budget, they are counted in it, but only responses of this run are read
+	Dir string
+	// MaxBytes is a size budget, the oldest segments are removed when it's exceeded, default is DefaultResponseStoreMaxBytes
+	MaxBytes int64
+	// SegmentBytes is a size of one segment, default is DefaultResponseStoreSegmentBytes
+	SegmentBytes int64
+	// Gzip compresses segments, the budget is checked with uncompressed size of the current segment
+	Gzip bool
+}
+
+func (m *ResponseStoreConfig) Validate() error {
+	if m.Dir == "" {
+		return ErrResponseStoreNoDir
+	}
+	if m.MaxBytes < 0 || m.SegmentBytes < 0 {
+		return ErrResponseStoreSize
+	}
+	if m.MaxBytes == 0 {
+		m.MaxBytes = DefaultResponseStoreMaxBytes
+	}
+	if m.SegmentBytes == 0 {
+		m.SegmentBytes = min(DefaultResponseStoreSegmentBytes, m.MaxBytes)
+	}
+	if m.SegmentBytes > m.MaxBytes {
+		return ErrResponseStoreSize
+	}
+	return nil
+}
+
+// ResponseStoreStats are store counters
+type ResponseStoreStats struct {
+	// Stored responses written to disk
+	Stored atomic.Int64 `json:"stored"`
+	// Previous responses found in segments of previous runs when the store was created
+	Previous atomic.Int64 `json:"previous"`
+	// Evicted responses removed with the oldest segments because of the size budget, including segments of previous runs
+	Evicted atomic.Int64 `json:"evicted"`
+	// Bytes currently stored on disk
+	Bytes atomic.Int64 `json:"bytes"`
+	// Errors of writing responses, the generator stops on the first one
+	Errors atomic.Int64 `json:"errors"`
+}
+
+// storeSegment is one segment of the store with the time range of its responses
+type storeSegment struct {
+	path string
+	// previous segments are written by previous runs, they are only counted in the budget
+	previous bool
+	bytes    int64
+	count    int64
+	from     time.Time
+	to       time.Time
+}
+
+// ResponseStore is a Sink that writes every recorded response to rotating segments <dir>/<name>_responses_<seq>.jsonl[.gz],
+// segments have the same format as FileSink segments, so ReadFileRecords can read them too.
+// Responses can be iterated in order of recording after the generator finished, records have the run id of the store like FileSink records
+type ResponseStore struct {
+	cfg         *ResponseStoreConfig
+	runID       string
+	mu          *sync.Mutex
+	writer      *segmentWriter
+	segments    []*storeSegment
+	closedBytes int64
+	closed      bool
+	stats       *ResponseStoreStats
+}
+
+// NewResponseStore creates a new response store, name is a prefix of its segments, usually Config.GenName
+func NewResponseStore(cfg *ResponseStoreConfig, name string) (*ResponseStore, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	m := &ResponseStore{
+		cfg:    cfg,
+		runID:  uuid.NewString(),
+		mu:     &sync.Mutex{},
+		writer: newSegmentWriter(cfg.Dir, name+"_"+responseStoreSegmentName, FileFormatJSONL, cfg.Gzip, cfg.SegmentBytes),
+		stats:  &ResponseStoreStats{},
+	}
+	if err := m.load(name); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// load indexes segments of previous runs and evicts the oldest ones if they don't fit the budget
+func (m *ResponseStore) load(name string) error {
+	paths := make([]string, 0)
+	for _, ext := range []string{".jsonl", ".jsonl.gz"} {
+		matches, err := filepath.Glob(filepath.Join(m.cfg.Dir, fmt.Sprintf("%s_%s_[0-9]*%s", name, responseStoreSegmentName, ext)))
+		if err != nil {
+			return err
+		}
+		paths = append(paths, matches...)
+	}
+	// sequence numbers have a fixed width, so segments are sorted in order of writing
+	sort.Strings(paths)
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		s := &storeSegment{path: path, previous: true, bytes: fi.Size()}
+		err = readSegment(path, func(rec *FileRecord) error {
+			if rec.Response == nil {
+				return nil
+			}
+			s.count++
+			if s.from.IsZero() || rec.Time.Before(s.from) {
+				s.from = rec.Time
+			}
+			if rec.Time.After(s.to) {
+				s.to = rec.Time
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		m.segments = append(m.segments, s)
+		m.closedBytes += s.bytes
+		m.stats.Previous.Add(s.count)
+	}
+	m.evict()
+	return nil
+}
+
+func (m *ResponseStore) Name() string {
+	return "response_store"
+}
+
+// RunID returns the id written to all the records of this run
+func (m *ResponseStore) RunID() string {
+	return m.runID
+}
+
+func (m *ResponseStore) HandleResponse(_ *Generator, r *Response) error {
+	if err := m.store(r); err != nil {
+		m.stats.Errors.Add(1)
+		return err
+	}
+	return nil
+}
+
+// store writes a response to the current segment
+func (m *ResponseStore) store(r *Response) error {
+	ts := time.Now()
+	if r.FinishedAt != nil {
+		ts = *r.FinishedAt
+	}
+	d, err := json.Marshal(&FileRecord{Type: FileRecordResponse, Time: ts, RunID: m.runID, Response: r})
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrResponseStoreClosed
+	}
+	var prev *storeSegment
+	if m.writer.file != nil {
+		prev = m.segments[len(m.segments)-1]
+	}
+	opened, err := m.writer.next()
+	if err != nil {
+		return err
+	}
+	if opened {
+		if prev != nil {
+			m.finish(prev)
+		}
+		m.segments = append(m.segments, &storeSegment{path: m.writer.file.Name(), from: ts})
+	}
+	if _, err := m.writer.Write(append(d, '\n')); err != nil {
+		return err
+	}
+	s := m.segments[len(m.segments)-1]
+	s.count++
+	if ts.Before(s.from) {
+		s.from = ts
+	}
+	if ts.After(s.to) {
+		s.to = ts
+	}
+	m.stats.Stored.Add(1)
+	m.evict()
+	return nil
+}
+
+// HandleStats does nothing, the store keeps only responses
+func (m *ResponseStore) HandleStats(_ *Generator, _ map[string]interface{}) error {
+	return nil
+}
+
+// finish accounts the size of a closed segment on disk, must be called under lock
+func (m *ResponseStore) finish(s *storeSegment) {
+	if fi, err := os.Stat(s.path); err == nil {
+		s.bytes = fi.Size()
+	}
+	m.closedBytes += s.bytes
+}
+
+// evict removes the oldest closed segments while the store is over the budget, must be called under lock
+func (m *ResponseStore) evict() {
+	closed := len(m.segments)
+	if m.writer.file != nil {
+		closed--
+	}
+	for ; closed > 0 && m.closedBytes+m.writer.written > m.cfg.MaxBytes; closed-- {
+		s := m.segments[0]
+		if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		m.segments = m.segments[1:]
+		m.closedBytes -= s.bytes
+		m.stats.Evicted.Add(s.count)
+	}
+	m.stats.Bytes.Store(m.closedBytes + m.writer.written)
+}
+
+// Close flushes and closes the current segment, segments stay on disk
+func (m *ResponseStore) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	open := m.writer.file != nil
+	if err := m.writer.Close(); err != nil {
+		return err
+	}
+	if open {
+		m.finish(m.segments[len(m.segments)-1])
+	}
+	m.stats.Bytes.Store(m.closedBytes)
+	return nil
+}
+
+// Stats returns store counters
+func (m *ResponseStore) Stats() *ResponseStoreStats {
+	return m.stats
+}
+
+// Iterate calls fn for every response stored by this run in order of recording until it returns an error
+func (m *ResponseStore) Iterate(fn func(r *Response) error) error {
+	return m.Range(time.Time{}, time.Time{}, fn)
+}
+
+// Range calls fn for responses stored by this run finished in [from, to) in order of recording until it returns an error,
+// zero from or to means the range is not limited, segments out of the range and segments of previous runs are not read
+func (m *ResponseStore) Range(from, to time.Time, fn func(r *Response) error) error {
+	m.mu.Lock()
+	if !m.closed {
+		m.mu.Unlock()
+		return ErrResponseStoreOpen
+	}
+	segments := append([]*storeSegment(nil), m.segments...)
+	m.mu.Unlock()
+	inRange := func(ts time.Time) bool {
+		return (from.IsZero() || !ts.Before(from)) && (to.IsZero() || ts.Before(to))
+	}
+	for _, s := range segments {
+		if s.previous {
+			continue
+		}
+		if (!from.IsZero() && s.to.Before(from)) || (!to.IsZero() && !s.from.Before(to)) {
+			continue
+		}
+		err := readSegment(s.path, func(rec *FileRecord) error {
+			if rec.Response == nil || rec.RunID != m.runID || !inRange(rec.Time) {
+				return nil
+			}
+			return fn(rec.Response)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
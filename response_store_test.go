@@ -0,0 +1,176 @@
+package wasp
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSmokeResponseStore(t *testing.T) {
+	t.Parallel()
+	t.Run("all the recorded responses are stored on disk", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		gen, err := NewGenerator(&Config{
+			T:                 t,
+			GenName:           "store",
+			LoadType:          RPS,
+			Schedule:          Plain(100, 2*time.Second),
+			StatsPollInterval: 1 * time.Second,
+			CallResultBufLen:  10,
+			ResponseStore:     &ResponseStoreConfig{Dir: dir, SegmentBytes: 4096},
+			Gun: NewMockGun(&MockGunConfig{
+				FailRatio: 10,
+				CallSleep: 10 * time.Millisecond,
+			}),
+		})
+		require.NoError(t, err)
+		store := gen.ResponseStore()
+		require.ErrorIs(t, store.Iterate(func(*Response) error { return nil }), ErrResponseStoreOpen)
+		_, _ = gen.Run(true)
+		require.Equal(t, 10, gen.GetData().OKResponses().Len())
+
+		stored := store.Stats().Stored.Load()
+		require.Equal(t, gen.Stats().SamplesRecorded.Load(), stored)
+		require.Zero(t, store.Stats().Evicted.Load())
+		var total, failed int64
+		var first, last time.Time
+		require.NoError(t, store.Iterate(func(r *Response) error {
+			total++
+			if r.Failed {
+				failed++
+			}
+			if first.IsZero() || r.FinishedAt.Before(first) {
+				first = *r.FinishedAt
+			}
+			if r.FinishedAt.After(last) {
+				last = *r.FinishedAt
+			}
+			return nil
+		}))
+		require.Equal(t, stored, total)
+		require.Equal(t, gen.Stats().Failed.Load(), failed)
+
+		mid := first.Add(last.Sub(first) / 2)
+		var before, after int64
+		require.NoError(t, store.Range(time.Time{}, mid, func(*Response) error { before++; return nil }))
+		require.NoError(t, store.Range(mid, time.Time{}, func(*Response) error { after++; return nil }))
+		require.Greater(t, before, int64(0))
+		require.Greater(t, after, int64(0))
+		require.Equal(t, total, before+after)
+
+		segments, err := filepath.Glob(filepath.Join(dir, "store_responses_*.jsonl"))
+		require.NoError(t, err)
+		require.Greater(t, len(segments), 1)
+		var records int64
+		require.NoError(t, ReadFileRecords(dir, func(*FileRecord) error { records++; return nil }))
+		require.Equal(t, total, records)
+	})
+	t.Run("the oldest segments are evicted over the budget", func(t *testing.T) {
+		t.Parallel()
+		store, err := NewResponseStore(&ResponseStoreConfig{Dir: t.TempDir(), MaxBytes: 8192, SegmentBytes: 2048, Gzip: true}, "budget")
+		require.NoError(t, err)
+		start := time.Now()
+		for i := 0; i < 1000; i++ {
+			ts := start.Add(time.Duration(i) * time.Millisecond)
+			require.NoError(t, store.HandleResponse(nil, &Response{Data: i, FinishedAt: &ts}))
+		}
+		require.NoError(t, store.Close())
+		require.ErrorIs(t, store.HandleResponse(nil, &Response{}), ErrResponseStoreClosed)
+		stats := store.Stats()
+		require.Equal(t, int64(1), stats.Errors.Load())
+		require.Equal(t, int64(1000), stats.Stored.Load())
+		require.Greater(t, stats.Evicted.Load(), int64(0))
+		require.LessOrEqual(t, stats.Bytes.Load(), int64(8192))
+		var kept []int
+		require.NoError(t, store.Iterate(func(r *Response) error {
+			kept = append(kept, int(r.Data.(float64)))
+			return nil
+		}))
+		require.Len(t, kept, int(stats.Stored.Load()-stats.Evicted.Load()))
+		for i, v := range kept {
+			require.Equal(t, 1000-len(kept)+i, v)
+		}
+		var ranged int
+		require.NoError(t, store.Range(start.Add(990*time.Millisecond), start.Add(995*time.Millisecond), func(*Response) error {
+			ranged++
+			return nil
+		}))
+		require.Equal(t, 5, ranged)
+	})
+	t.Run("segments of previous runs are counted in the budget and are not read", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		cfg := &ResponseStoreConfig{Dir: dir, MaxBytes: 8192, SegmentBytes: 2048}
+		start := time.Now()
+		write := func(from, to int) *ResponseStore {
+			store, err := NewResponseStore(cfg, "prev")
+			require.NoError(t, err)
+			for i := from; i < to; i++ {
+				ts := start.Add(time.Duration(i) * time.Millisecond)
+				require.NoError(t, store.HandleResponse(nil, &Response{Data: i, FinishedAt: &ts}))
+			}
+			require.NoError(t, store.Close())
+			return store
+		}
+		first := write(0, 500)
+		kept := first.Stats().Stored.Load() - first.Stats().Evicted.Load()
+
+		store := write(500, 1000)
+		stats := store.Stats()
+		require.Equal(t, kept, stats.Previous.Load())
+		require.Greater(t, stats.Evicted.Load(), int64(0))
+		require.LessOrEqual(t, stats.Bytes.Load(), int64(8192))
+		var onDisk int64
+		segments, err := filepath.Glob(filepath.Join(dir, "prev_responses_*.jsonl"))
+		require.NoError(t, err)
+		for _, path := range segments {
+			fi, err := os.Stat(path)
+			require.NoError(t, err)
+			onDisk += fi.Size()
+		}
+		require.Equal(t, stats.Bytes.Load(), onDisk)
+		// only responses of this run are read
+		var values []int
+		require.NoError(t, store.Iterate(func(r *Response) error {
+			values = append(values, int(r.Data.(float64)))
+			return nil
+		}))
+		require.NotEmpty(t, values)
+		for i, v := range values {
+			require.Equal(t, 1000-len(values)+i, v)
+		}
+		require.GreaterOrEqual(t, values[0], 500)
+
+		// segments of the first runs are evicted before the new ones
+		last := write(1000, 2000)
+		runs := make(map[string]int)
+		require.NoError(t, ReadFileRecords(dir, func(rec *FileRecord) error {
+			runs[rec.RunID]++
+			require.GreaterOrEqual(t, int(rec.Response.Data.(float64)), 1000)
+			return nil
+		}))
+		require.Len(t, runs, 1)
+		require.Contains(t, runs, last.RunID())
+		store, err = NewResponseStore(cfg, "prev")
+		require.NoError(t, err)
+		require.NoError(t, store.Close())
+		require.NoError(t, store.Iterate(func(r *Response) error {
+			return errors.New("responses of previous runs are read")
+		}))
+		other, err := NewResponseStore(cfg, "other")
+		require.NoError(t, err)
+		require.Zero(t, other.Stats().Previous.Load())
+	})
+	t.Run("validation", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewResponseStore(&ResponseStoreConfig{}, "a")
+		require.ErrorIs(t, err, ErrResponseStoreNoDir)
+		_, err = NewResponseStore(&ResponseStoreConfig{Dir: t.TempDir(), MaxBytes: 1, SegmentBytes: 2}, "a")
+		require.ErrorIs(t, err, ErrResponseStoreSize)
+	})
+}
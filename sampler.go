@@ -1,44 +1,301 @@
 package wasp
 
-import "math/rand"
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/* Sampling strategies of successful responses */
+
+var (
+	ErrSamplerRatio     = errors.New("sampler ratios must be in [0, 100]")
+	ErrSamplerReservoir = errors.New("reservoir sampler size and window must be > 0")
+	ErrSamplerRate      = errors.New("rate limited sampler rate and burst must be > 0")
+)
+
+// SampleDecision is a decision of a Sampler about one response
+type SampleDecision int
+
+const (
+	// SampleSkip skips the response
+	SampleSkip SampleDecision = iota
+	// SampleRecord records the response: pushes it to sinks and keeps it in generator data
+	SampleRecord
+	// SampleHold means the sampler keeps the response and decides later, see HoldingSampler
+	SampleHold
+)
+
+// Sampler decides which successful responses are recorded, failed and timed out responses are always recorded.
+// Samplers are called concurrently, the generator counts decisions in Stats.SamplesRecorded and Stats.SamplesSkipped
+type Sampler interface {
+	Sample(res *Response) SampleDecision
+}
+
+// HoldingSampler is a Sampler that holds responses and decides later, for example at the end of a time window
+type HoldingSampler interface {
+	Sampler
+	// Release returns held responses that must be recorded now and the amount of held responses that were skipped,
+	// all the held responses are released if final is true
+	Release(final bool) ([]*Response, int64)
+}
 
 type SamplerConfig struct {
+	// SuccessfulCallResultRecordRatio is a percentage of recorded successful responses, 0-100
 	SuccessfulCallResultRecordRatio int
+	// GroupRatios overrides SuccessfulCallResultRecordRatio for a Response.Group
+	GroupRatios map[string]int
+	// Seed of the random source of the sampler, the time is used if 0
+	Seed int64
+}
+
+func (m *SamplerConfig) Validate() error {
+	if m.SuccessfulCallResultRecordRatio < 0 || m.SuccessfulCallResultRecordRatio > 100 {
+		return ErrSamplerRatio
+	}
+	for _, r := range m.GroupRatios {
+		if r < 0 || r > 100 {
+			return ErrSamplerRatio
+		}
+	}
+	return nil
+}
+
+// newRand creates a random source of one sampler, seeded with the time if seed is 0
+func newRand(seed int64) *rand.Rand {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	//nolint
+	return rand.New(rand.NewSource(seed))
+}
+
+// lockedRand is a random source safe for concurrent use
+type lockedRand struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
 }
 
-// Sampler is a CallResult filter that stores a percentage of successful call results
-// errored and timed out results are always stored
-type Sampler struct {
+// newLockedRand creates a source of one sampler, seeded with the time if seed is 0
+func newLockedRand(seed int64) *lockedRand {
+	return &lockedRand{rnd: newRand(seed)}
+}
+
+func (m *lockedRand) Int63n(n int64) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rnd.Int63n(n)
+}
+
+// RatioSampler records a percentage of successful responses, the percentage can be set per Response.Group
+type RatioSampler struct {
 	cfg *SamplerConfig
+	rnd *lockedRand
 }
 
-// NewSampler creates new Sampler
-func NewSampler(cfg *SamplerConfig) *Sampler {
+// NewSampler creates a RatioSampler, all the responses are recorded if cfg is nil
+func NewSampler(cfg *SamplerConfig) *RatioSampler {
 	if cfg == nil {
 		cfg = &SamplerConfig{SuccessfulCallResultRecordRatio: 100}
 	}
-	return &Sampler{cfg: cfg}
+	return &RatioSampler{cfg: cfg, rnd: newLockedRand(cfg.Seed)}
 }
 
-// ShouldRecord return true if we should save CallResult
-func (m *Sampler) ShouldRecord(cr *Response, s *Stats) bool {
-	if cr.Error != "" || cr.Failed || cr.Timeout {
-		s.SamplesRecorded.Add(1)
-		return true
+// Sample records a response with the ratio of its group
+func (m *RatioSampler) Sample(res *Response) SampleDecision {
+	ratio := m.cfg.SuccessfulCallResultRecordRatio
+	if r, ok := m.cfg.GroupRatios[res.Group]; ok {
+		ratio = r
 	}
-	if m.cfg.SuccessfulCallResultRecordRatio == 0 {
-		s.SamplesSkipped.Add(1)
-		return false
+	switch {
+	case ratio <= 0:
+		return SampleSkip
+	case ratio >= 100:
+		return SampleRecord
+	case m.rnd.Int63n(100) < int64(ratio):
+		return SampleRecord
+	default:
+		return SampleSkip
 	}
-	if m.cfg.SuccessfulCallResultRecordRatio == 100 {
-		s.SamplesRecorded.Add(1)
-		return true
+}
+
+// TailSampler always records responses slower than a threshold, other responses are sampled by the next sampler
+type TailSampler struct {
+	threshold time.Duration
+	next      Sampler
+}
+
+// NewTailSampler creates a TailSampler, responses faster than the threshold are skipped if next is nil
+func NewTailSampler(threshold time.Duration, next Sampler) *TailSampler {
+	return &TailSampler{threshold: threshold, next: next}
+}
+
+// Sample records slow responses
+func (m *TailSampler) Sample(res *Response) SampleDecision {
+	if res.Duration >= m.threshold {
+		return SampleRecord
 	}
-	r := rand.Intn(100)
-	if cr.Error == "" && r < m.cfg.SuccessfulCallResultRecordRatio {
-		s.SamplesRecorded.Add(1)
-		return true
+	if m.next == nil {
+		return SampleSkip
+	}
+	return m.next.Sample(res)
+}
+
+// Release releases responses held by the next sampler
+func (m *TailSampler) Release(final bool) ([]*Response, int64) {
+	return releaseHeld(m.next, final)
+}
+
+// ReservoirSampler records a uniform random sample of a fixed size from every time window,
+// the sample of a window is released when the next window starts or when the run ends
+type ReservoirSampler struct {
+	mu        sync.Mutex
+	size      int
+	window    time.Duration
+	rnd       *rand.Rand
+	windowEnd time.Time
+	seen      int64
+	reservoir []*Response
+	ready     []*Response
+	skipped   int64
+	hasReady  atomic.Bool
+}
+
+// NewReservoirSampler creates a ReservoirSampler that records up to size responses per window
+func NewReservoirSampler(size int, window time.Duration) (*ReservoirSampler, error) {
+	if size <= 0 || window <= 0 {
+		return nil, ErrSamplerReservoir
+	}
+	return &ReservoirSampler{
+		size:      size,
+		window:    window,
+		rnd:       newRand(0),
+		reservoir: make([]*Response, 0, size),
+	}, nil
+}
+
+// WithSeed seeds the random source of the sampler, so the same responses are picked in the same order of calls
+func (m *ReservoirSampler) WithSeed(seed int64) *ReservoirSampler {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rnd = newRand(seed)
+	return m
+}
+
+// Sample holds the response in the reservoir of the current window, the response it replaces is skipped
+func (m *ReservoirSampler) Sample(res *Response) SampleDecision {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if m.windowEnd.IsZero() {
+		m.windowEnd = now.Add(m.window)
+	}
+	if !now.Before(m.windowEnd) {
+		m.ready = append(m.ready, m.reservoir...)
+		m.hasReady.Store(len(m.ready) > 0)
+		m.reservoir = make([]*Response, 0, m.size)
+		m.seen = 0
+		// empty windows are skipped
+		m.windowEnd = m.windowEnd.Add((now.Sub(m.windowEnd)/m.window + 1) * m.window)
+	}
+	m.seen++
+	if len(m.reservoir) < m.size {
+		m.reservoir = append(m.reservoir, res)
+		return SampleHold
+	}
+	if i := m.rnd.Int63n(m.seen); i < int64(m.size) {
+		m.reservoir[i] = res
+		m.skipped++
+		return SampleHold
+	}
+	return SampleSkip
+}
+
+// Release releases samples of finished windows and the amount of responses replaced in reservoirs
+func (m *ReservoirSampler) Release(final bool) ([]*Response, int64) {
+	if !final && !m.hasReady.Load() {
+		m.mu.Lock()
+		skipped := m.skipped
+		m.skipped = 0
+		m.mu.Unlock()
+		return nil, skipped
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := m.ready
+	if final {
+		res = append(res, m.reservoir...)
+		m.reservoir = make([]*Response, 0, m.size)
+		m.seen = 0
+	}
+	m.ready = nil
+	m.hasReady.Store(false)
+	skipped := m.skipped
+	m.skipped = 0
+	return res, skipped
+}
+
+// RateLimitedSampler caps responses recorded by the next sampler with a token bucket
+type RateLimitedSampler struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	next   Sampler
+}
+
+// NewRateLimitedSampler creates a sampler that records at most perSecond responses with bursts up to burst,
+// all the successful responses are candidates if next is nil
+func NewRateLimitedSampler(perSecond float64, burst int, next Sampler) (*RateLimitedSampler, error) {
+	if perSecond <= 0 || burst <= 0 {
+		return nil, ErrSamplerRate
+	}
+	if next == nil {
+		next = NewSampler(nil)
+	}
+	return &RateLimitedSampler{rate: perSecond, burst: float64(burst), tokens: float64(burst), next: next}, nil
+}
+
+// Sample records a response recorded by the next sampler if there is a token
+func (m *RateLimitedSampler) Sample(res *Response) SampleDecision {
+	d := m.next.Sample(res)
+	if d != SampleRecord {
+		return d
+	}
+	if m.take() {
+		return SampleRecord
+	}
+	return SampleSkip
+}
+
+// take takes a token from the bucket
+func (m *RateLimitedSampler) take() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if !m.last.IsZero() {
+		m.tokens = min(m.burst, m.tokens+now.Sub(m.last).Seconds()*m.rate)
+	}
+	m.last = now
+	if m.tokens < 1 {
+		return false
+	}
+	m.tokens--
+	return true
+}
+
+// Release releases responses held by the next sampler, they are not limited
+func (m *RateLimitedSampler) Release(final bool) ([]*Response, int64) {
+	return releaseHeld(m.next, final)
+}
+
+// releaseHeld releases responses of a sampler if it holds them
+func releaseHeld(s Sampler, final bool) ([]*Response, int64) {
+	if hs, ok := s.(HoldingSampler); ok {
+		return hs.Release(final)
 	}
-	s.SamplesSkipped.Add(1)
-	return false
+	return nil, 0
 }
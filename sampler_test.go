@@ -0,0 +1,120 @@
+package wasp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func countDecisions(s Sampler, n int, res func(i int) *Response) map[SampleDecision]int {
+	d := make(map[SampleDecision]int)
+	for i := 0; i < n; i++ {
+		d[s.Sample(res(i))]++
+	}
+	return d
+}
+
+func TestSmokeSamplers(t *testing.T) {
+	t.Parallel()
+	t.Run("ratio per call group", func(t *testing.T) {
+		t.Parallel()
+		s := NewSampler(&SamplerConfig{SuccessfulCallResultRecordRatio: 10, GroupRatios: map[string]int{"login": 100, "health": 0}, Seed: 1})
+		require.Equal(t, 1000, countDecisions(s, 1000, func(int) *Response { return &Response{Group: "login"} })[SampleRecord])
+		require.Equal(t, 1000, countDecisions(s, 1000, func(int) *Response { return &Response{Group: "health"} })[SampleSkip])
+		recorded := countDecisions(s, 1000, func(int) *Response { return &Response{Group: "other"} })[SampleRecord]
+		require.Greater(t, recorded, 50)
+		require.Less(t, recorded, 150)
+	})
+	t.Run("slow responses are always recorded", func(t *testing.T) {
+		t.Parallel()
+		s := NewTailSampler(100*time.Millisecond, NewSampler(&SamplerConfig{SuccessfulCallResultRecordRatio: 0}))
+		d := countDecisions(s, 100, func(i int) *Response { return &Response{Duration: time.Duration(i) * 2 * time.Millisecond} })
+		require.Equal(t, 50, d[SampleRecord])
+		require.Equal(t, 50, d[SampleSkip])
+	})
+	t.Run("token bucket caps recorded samples", func(t *testing.T) {
+		t.Parallel()
+		s, err := NewRateLimitedSampler(100, 10, nil)
+		require.NoError(t, err)
+		d := countDecisions(s, 1000, func(int) *Response { return &Response{} })
+		require.GreaterOrEqual(t, d[SampleRecord], 10)
+		require.Less(t, d[SampleRecord], 20)
+		time.Sleep(100 * time.Millisecond)
+		d = countDecisions(s, 1000, func(int) *Response { return &Response{} })
+		require.GreaterOrEqual(t, d[SampleRecord], 9)
+		require.LessOrEqual(t, d[SampleRecord], 10)
+	})
+	t.Run("reservoir keeps a fixed count per window", func(t *testing.T) {
+		t.Parallel()
+		s, err := NewReservoirSampler(5, 100*time.Millisecond)
+		require.NoError(t, err)
+		d := countDecisions(s, 100, func(i int) *Response { return &Response{Data: i} })
+		released, skipped := s.Release(false)
+		require.Empty(t, released)
+		require.Equal(t, int64(100), int64(d[SampleSkip])+skipped+5)
+		time.Sleep(100 * time.Millisecond)
+		s.Sample(&Response{})
+		released, _ = s.Release(false)
+		require.Len(t, released, 5)
+		released, _ = s.Release(true)
+		require.Len(t, released, 1)
+		_, err = NewReservoirSampler(0, time.Second)
+		require.ErrorIs(t, err, ErrSamplerReservoir)
+	})
+	t.Run("seeded reservoirs pick the same responses", func(t *testing.T) {
+		t.Parallel()
+		sample := func() []any {
+			s, err := NewReservoirSampler(5, time.Minute)
+			require.NoError(t, err)
+			s.WithSeed(42)
+			countDecisions(s, 1000, func(i int) *Response { return &Response{Data: i} })
+			released, _ := s.Release(true)
+			data := make([]any, 0)
+			for _, r := range released {
+				data = append(data, r.Data)
+			}
+			return data
+		}
+		require.Equal(t, sample(), sample())
+	})
+	t.Run("samples are counted for composed strategies", func(t *testing.T) {
+		t.Parallel()
+		reservoir, err := NewReservoirSampler(10, 200*time.Millisecond)
+		require.NoError(t, err)
+		gen, err := NewGenerator(&Config{
+			T:                 t,
+			LoadType:          RPS,
+			Schedule:          Plain(100, 2*time.Second),
+			StatsPollInterval: 1 * time.Second,
+			Sampler:           NewTailSampler(40*time.Millisecond, reservoir),
+			Gun: NewMockGun(&MockGunConfig{
+				FailRatio: 10,
+				CallSleep: 20 * time.Millisecond,
+			}),
+		})
+		require.NoError(t, err)
+		_, _ = gen.Run(true)
+		stats := gen.Stats()
+		_, okResponses, failResponses := convertResponsesData(gen)
+		require.Equal(t, stats.Latencies.All.Count(), stats.SamplesRecorded.Load()+stats.SamplesSkipped.Load())
+		require.Equal(t, stats.SamplesRecorded.Load(), int64(len(okResponses)+len(failResponses)))
+		// 10 samples per 200ms window and a few slow calls
+		require.GreaterOrEqual(t, len(okResponses), 90)
+		require.LessOrEqual(t, len(okResponses), 130)
+		require.NotEmpty(t, failResponses)
+	})
+	t.Run("validation", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewGenerator(&Config{
+			T:             t,
+			LoadType:      RPS,
+			Schedule:      Plain(1, time.Second),
+			SamplerConfig: &SamplerConfig{GroupRatios: map[string]int{"a": 101}},
+			Gun:           NewMockGun(&MockGunConfig{}),
+		})
+		require.ErrorIs(t, err, ErrSamplerRatio)
+		_, err = NewRateLimitedSampler(0, 1, nil)
+		require.ErrorIs(t, err, ErrSamplerRate)
+	})
+}
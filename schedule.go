@@ -1,6 +1,8 @@
 package wasp
 
 import (
+	"math"
+	"math/rand"
 	"time"
 )
 
@@ -9,6 +11,8 @@ import (
 const (
 	// DefaultStepChangePrecision is default amount of steps in which we split a schedule
 	DefaultStepChangePrecision = 10
+	// DefaultWavePrecision is default amount of ramp segments approximating one period of a wave
+	DefaultWavePrecision = 20
 )
 
 // Plain create a constant workload Segment
@@ -21,6 +25,18 @@ func Plain(from int64, duration time.Duration) []*Segment {
 	}
 }
 
+// Ramp creates a Segment that changes RPS or VUs linearly from "from" to "to"
+func Ramp(from, to int64, duration time.Duration) []*Segment {
+	return []*Segment{
+		{
+			From:     from,
+			To:       to,
+			Ramp:     true,
+			Duration: duration,
+		},
+	}
+}
+
 // Steps creates a series of increasing/decreasing Segments
 func Steps(from, increase int64, steps int, duration time.Duration) []*Segment {
 	segments := make([]*Segment, 0)
@@ -57,3 +73,117 @@ func CombineAndRepeat(times int, segs ...[]*Segment) []*Segment {
 	}
 	return acc
 }
+
+// Sine creates ramp segments approximating a sinusoidal wave around "mean" with "amplitude" and "period"
+// wave starts at mean and is cut at "duration", values are never lower than 1
+func Sine(mean, amplitude int64, period time.Duration, duration time.Duration) ([]*Segment, error) {
+	if period < DefaultWavePrecision || duration <= 0 {
+		return nil, ErrInvalidSegmentDuration
+	}
+	step := period / DefaultWavePrecision
+	if segmentsCount(step, duration) > MaxScheduleSegments {
+		return nil, ErrTooManySegments
+	}
+	valueAt := func(t time.Duration) int64 {
+		v := float64(mean) + float64(amplitude)*math.Sin(2*math.Pi*float64(t)/float64(period))
+		return clampSegmentValue(int64(math.Round(v)))
+	}
+	segments := make([]*Segment, 0)
+	for t := time.Duration(0); t < duration; t += step {
+		d := min(step, duration-t)
+		segments = append(segments, rampOrPlain(valueAt(t), valueAt(t+d), d))
+	}
+	return segments, nil
+}
+
+// Spike creates "baseline" segments with short "peak" bursts lasting "spikeDuration" every "interval"
+// schedule starts with a baseline and is cut at "duration"
+func Spike(baseline, peak int64, spikeDuration, interval, duration time.Duration) ([]*Segment, error) {
+	if spikeDuration <= 0 || interval <= spikeDuration || duration <= 0 {
+		return nil, ErrInvalidSegmentDuration
+	}
+	// every interval is split into a baseline and a peak segment
+	if segmentsCount(interval, duration) > MaxScheduleSegments/2 {
+		return nil, ErrTooManySegments
+	}
+	return alternate(
+		[]int64{clampSegmentValue(baseline), clampSegmentValue(peak)},
+		[]time.Duration{interval - spikeDuration, spikeDuration},
+		duration,
+	), nil
+}
+
+// SquareWave creates segments switching between "high" and "low" every half of a "period"
+// wave starts with "high" and is cut at "duration"
+func SquareWave(low, high int64, period, duration time.Duration) ([]*Segment, error) {
+	if period < 2 || duration <= 0 {
+		return nil, ErrInvalidSegmentDuration
+	}
+	if segmentsCount(period/2, duration) > MaxScheduleSegments {
+		return nil, ErrTooManySegments
+	}
+	return alternate(
+		[]int64{clampSegmentValue(high), clampSegmentValue(low)},
+		[]time.Duration{period / 2, period - period/2},
+		duration,
+	), nil
+}
+
+// RandomWalk creates segments of "interval" length, each one changes the value randomly by up to "step"
+// walk starts at "mean" and never deviates from it more than "deviation", values are never lower than 1
+func RandomWalk(mean, deviation, step int64, interval, duration time.Duration, rnd *rand.Rand) ([]*Segment, error) {
+	if interval <= 0 || duration <= 0 {
+		return nil, ErrInvalidSegmentDuration
+	}
+	if deviation < 0 || step < 0 || step >= math.MaxInt64/2 || rnd == nil {
+		return nil, ErrInvalidRandomWalk
+	}
+	if segmentsCount(interval, duration) > MaxScheduleSegments {
+		return nil, ErrTooManySegments
+	}
+	lowest := clampSegmentValue(mean - deviation)
+	highest := clampSegmentValue(mean + deviation)
+	v := clampSegmentValue(mean)
+	segments := make([]*Segment, 0)
+	for t := time.Duration(0); t < duration; t += interval {
+		segments = append(segments, &Segment{
+			From:     v,
+			Duration: min(interval, duration-t),
+		})
+		v += rnd.Int63n(2*step+1) - step
+		v = max(lowest, min(highest, v))
+	}
+	return segments, nil
+}
+
+// segmentsCount returns how many segments of "step" length are needed to fill "duration"
+func segmentsCount(step, duration time.Duration) int64 {
+	return int64((duration-1)/step + 1)
+}
+
+// alternate cycles through values with their durations until "duration" is reached
+func alternate(values []int64, durations []time.Duration, duration time.Duration) []*Segment {
+	segments := make([]*Segment, 0)
+	for i, t := 0, time.Duration(0); t < duration; i = (i + 1) % len(values) {
+		d := min(durations[i], duration-t)
+		segments = append(segments, &Segment{
+			From:     values[i],
+			Duration: d,
+		})
+		t += d
+	}
+	return segments
+}
+
+// rampOrPlain creates a ramp segment or a plain one if values are equal
+func rampOrPlain(from, to int64, duration time.Duration) *Segment {
+	if from == to {
+		return &Segment{From: from, Duration: duration}
+	}
+	return &Segment{From: from, To: to, Ramp: true, Duration: duration}
+}
+
+// clampSegmentValue keeps generated RPS or VUs valid for a segment
+func clampSegmentValue(v int64) int64 {
+	return max(1, v)
+}
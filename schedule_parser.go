@@ -0,0 +1,524 @@
+package wasp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+/* Declarative schedule definitions parsed from DSL strings, TOML and YAML */
+
+const (
+	SegmentTypePlain  = "plain"
+	SegmentTypeRamp   = "ramp"
+	SegmentTypeSteps  = "steps"
+	SegmentTypeRepeat = "repeat"
+	// MaxScheduleSegments limits the amount of segments a schedule definition can expand to
+	MaxScheduleSegments = 100000
+)
+
+var yamlErrorLine = regexp.MustCompile(`line (\d+): (.*)`)
+
+// ScheduleParseError points at the offending token of a schedule definition
+type ScheduleParseError struct {
+	// Line is a 1-based line in a TOML document, 0 if unknown
+	Line int
+	// Column is a 1-based column in a DSL string or a TOML line, 0 if unknown
+	Column int
+	// Token is the offending token or the field path, ex.: segments[1].duration
+	Token string
+	Msg   string
+}
+
+func (e *ScheduleParseError) Error() string {
+	switch {
+	case e.Line > 0 && e.Column == 0:
+		return fmt.Sprintf("schedule: %s at line %d near %q", e.Msg, e.Line, e.Token)
+	case e.Line > 0:
+		return fmt.Sprintf("schedule: %s at line %d column %d near %q", e.Msg, e.Line, e.Column, e.Token)
+	case e.Column > 0:
+		return fmt.Sprintf("schedule: %s at column %d near %q", e.Msg, e.Column, e.Token)
+	default:
+		return fmt.Sprintf("schedule: %s at %s", e.Msg, e.Token)
+	}
+}
+
+// ParseSchedule parses a schedule from a DSL string, items are separated by commas:
+// - 10rps@1m - plain segment, unit is optional and can be either rps or vu
+// - ramp(10..200)@5m - linear ramp from 10 to 200
+// - steps(10,10,5)@5m - 5 steps starting from 10 and increasing by 10 split across 5m
+// - repeat(3){10rps@1m, 20rps@1m} - repeats the body 3 times
+// units must be the same, use ParseScheduleFor to check them against the load type
+func ParseSchedule(s string) ([]*Segment, error) {
+	return ParseScheduleFor("", s)
+}
+
+// ParseScheduleFor parses a schedule like ParseSchedule, rps units are allowed only for RPS load type and vu only for VU,
+// units are not checked if loadType is empty
+func ParseScheduleFor(loadType ScheduleType, s string) ([]*Segment, error) {
+	p := &scheduleParser{s: s, loadType: loadType}
+	p.skipSpaces()
+	if p.pos == len(p.s) {
+		return nil, p.errorAt(p.pos, "", "empty schedule")
+	}
+	segs, err := p.list()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.s) {
+		return nil, p.errorAt(p.pos, p.peekToken(), "unexpected token, expected ','")
+	}
+	return segs, nil
+}
+
+// scheduleParser is a recursive descent parser of schedule DSL
+type scheduleParser struct {
+	s        string
+	pos      int
+	unit     string
+	loadType ScheduleType
+}
+
+func (p *scheduleParser) errorAt(pos int, token, msg string) *ScheduleParseError {
+	if token == "" && pos >= len(p.s) {
+		token = "<end>"
+	}
+	return &ScheduleParseError{Column: pos + 1, Token: token, Msg: msg}
+}
+
+func (p *scheduleParser) skipSpaces() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t' || p.s[p.pos] == '\n' || p.s[p.pos] == '\r') {
+		p.pos++
+	}
+}
+
+// peekToken returns the token at the current position without consuming it
+func (p *scheduleParser) peekToken() string {
+	end := p.pos
+	for end < len(p.s) && !strings.ContainsRune(" \t\r\n,(){}@", rune(p.s[end])) {
+		end++
+	}
+	if end == p.pos && end < len(p.s) {
+		end++
+	}
+	return p.s[p.pos:end]
+}
+
+// read consumes bytes while ok returns true
+func (p *scheduleParser) read(ok func(c byte) bool) string {
+	start := p.pos
+	for p.pos < len(p.s) && ok(p.s[p.pos]) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func isScheduleLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isScheduleDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (p *scheduleParser) expect(tok string) error {
+	p.skipSpaces()
+	if !strings.HasPrefix(p.s[p.pos:], tok) {
+		return p.errorAt(p.pos, p.peekToken(), fmt.Sprintf("expected '%s'", tok))
+	}
+	p.pos += len(tok)
+	return nil
+}
+
+// list parses comma separated items
+func (p *scheduleParser) list() ([]*Segment, error) {
+	segs := make([]*Segment, 0)
+	for {
+		item, err := p.item()
+		if err != nil {
+			return nil, err
+		}
+		segs = append(segs, item...)
+		if len(segs) > MaxScheduleSegments {
+			return nil, p.errorAt(p.pos, p.peekToken(), fmt.Sprintf("schedule has more than %d segments", MaxScheduleSegments))
+		}
+		p.skipSpaces()
+		if p.pos == len(p.s) || p.s[p.pos] != ',' {
+			return segs, nil
+		}
+		p.pos++
+	}
+}
+
+func (p *scheduleParser) item() ([]*Segment, error) {
+	p.skipSpaces()
+	if p.pos == len(p.s) || !isScheduleLetter(p.s[p.pos]) {
+		return p.plain()
+	}
+	start := p.pos
+	word := p.read(isScheduleLetter)
+	switch strings.ToLower(word) {
+	case SegmentTypeRamp:
+		return p.ramp()
+	case SegmentTypeSteps:
+		return p.steps()
+	case SegmentTypeRepeat:
+		return p.repeat()
+	default:
+		return nil, p.errorAt(start, word, "unknown construct, expected a number, ramp, steps or repeat")
+	}
+}
+
+// number parses an integer with an optional rps or vu unit suffix
+func (p *scheduleParser) number(allowUnit bool) (int64, int, string, error) {
+	p.skipSpaces()
+	start := p.pos
+	if p.pos < len(p.s) && (p.s[p.pos] == '-' || p.s[p.pos] == '+') {
+		p.pos++
+	}
+	p.read(isScheduleDigit)
+	tok := p.s[start:p.pos]
+	v, err := strconv.ParseInt(tok, 10, 64)
+	if err != nil {
+		p.pos = start
+		return 0, start, tok, p.errorAt(start, p.peekToken(), "expected a number")
+	}
+	if !allowUnit {
+		return v, start, tok, nil
+	}
+	unitPos := p.pos
+	unit := strings.ToLower(p.read(isScheduleLetter))
+	switch unit {
+	case "":
+	case "rps":
+	case "vu", "vus":
+		unit = "vu"
+	default:
+		return 0, start, tok, p.errorAt(unitPos, unit, "unknown unit, expected rps or vu")
+	}
+	if unit != "" {
+		if (unit == "rps" && p.loadType == VU) || (unit == "vu" && p.loadType == RPS) {
+			return 0, start, tok, p.errorAt(unitPos, unit, fmt.Sprintf("unit %s doesn't match load type %s", unit, p.loadType))
+		}
+		if p.unit != "" && p.unit != unit {
+			return 0, start, tok, p.errorAt(unitPos, unit, fmt.Sprintf("mixed units, schedule already uses %s", p.unit))
+		}
+		p.unit = unit
+	}
+	return v, start, p.s[start:p.pos], nil
+}
+
+// at parses "@duration", returns the duration with its position and token
+func (p *scheduleParser) at() (time.Duration, int, string, error) {
+	if err := p.expect("@"); err != nil {
+		return 0, p.pos, "", err
+	}
+	p.skipSpaces()
+	start := p.pos
+	tok := p.read(func(c byte) bool {
+		return isScheduleDigit(c) || isScheduleLetter(c) || c == '.' || c >= 0x80
+	})
+	d, err := time.ParseDuration(tok)
+	if err != nil {
+		p.pos = start
+		return 0, start, tok, p.errorAt(start, p.peekToken(), "invalid duration")
+	}
+	if d <= 0 {
+		return 0, start, tok, p.errorAt(start, tok, "duration must be > 0")
+	}
+	return d, start, tok, nil
+}
+
+func (p *scheduleParser) plain() ([]*Segment, error) {
+	v, pos, tok, err := p.number(true)
+	if err != nil {
+		return nil, err
+	}
+	if v <= 0 {
+		return nil, p.errorAt(pos, tok, ErrStartFrom.Error())
+	}
+	d, _, _, err := p.at()
+	if err != nil {
+		return nil, err
+	}
+	return Plain(v, d), nil
+}
+
+func (p *scheduleParser) ramp() ([]*Segment, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	from, fromPos, fromTok, err := p.number(true)
+	if err != nil {
+		return nil, err
+	}
+	if from <= 0 {
+		return nil, p.errorAt(fromPos, fromTok, ErrStartFrom.Error())
+	}
+	if err := p.expect(".."); err != nil {
+		return nil, err
+	}
+	to, toPos, toTok, err := p.number(true)
+	if err != nil {
+		return nil, err
+	}
+	if to < 0 {
+		return nil, p.errorAt(toPos, toTok, ErrInvalidSegmentTo.Error())
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	d, _, _, err := p.at()
+	if err != nil {
+		return nil, err
+	}
+	return Ramp(from, to, d), nil
+}
+
+func (p *scheduleParser) steps() ([]*Segment, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	from, fromPos, fromTok, err := p.number(true)
+	if err != nil {
+		return nil, err
+	}
+	if from <= 0 {
+		return nil, p.errorAt(fromPos, fromTok, ErrStartFrom.Error())
+	}
+	if err := p.expect(","); err != nil {
+		return nil, err
+	}
+	increase, incPos, incTok, err := p.number(true)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(","); err != nil {
+		return nil, err
+	}
+	steps, stepsPos, stepsTok, err := p.number(false)
+	if err != nil {
+		return nil, err
+	}
+	if steps <= 0 {
+		return nil, p.errorAt(stepsPos, stepsTok, "steps must be > 0")
+	}
+	if steps > MaxScheduleSegments {
+		return nil, p.errorAt(stepsPos, stepsTok, fmt.Sprintf("steps must be <= %d", MaxScheduleSegments))
+	}
+	if from+(steps-1)*increase <= 0 {
+		return nil, p.errorAt(incPos, incTok, "last step must be > 0")
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	d, dPos, dTok, err := p.at()
+	if err != nil {
+		return nil, err
+	}
+	if d/time.Duration(steps) <= 0 {
+		return nil, p.errorAt(dPos, dTok, "step duration must be > 0")
+	}
+	return Steps(from, increase, int(steps), d), nil
+}
+
+func (p *scheduleParser) repeat() ([]*Segment, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	times, pos, tok, err := p.number(false)
+	if err != nil {
+		return nil, err
+	}
+	if times <= 0 {
+		return nil, p.errorAt(pos, tok, "repeat times must be > 0")
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	body, err := p.list()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+	if times > int64(MaxScheduleSegments/len(body)) {
+		return nil, p.errorAt(pos, tok, fmt.Sprintf("schedule has more than %d segments", MaxScheduleSegments))
+	}
+	return CombineAndRepeat(int(times), body), nil
+}
+
+// ScheduleSpec is a TOML/YAML schedule definition, either a DSL string or a list of segments
+type ScheduleSpec struct {
+	// Schedule is a DSL string, see ParseSchedule
+	Schedule string        `toml:"schedule" yaml:"schedule"`
+	Segments []SegmentSpec `toml:"segments" yaml:"segments"`
+}
+
+// SegmentSpec is a TOML/YAML schedule segment, Type is one of plain, ramp, steps or repeat
+type SegmentSpec struct {
+	Type string `toml:"type" yaml:"type"`
+	// From is RPS or VUs for plain, ramp and steps
+	From int64 `toml:"from" yaml:"from"`
+	// To is the final value of a ramp
+	To int64 `toml:"to" yaml:"to"`
+	// Increase is a value added on every step
+	Increase int64 `toml:"increase" yaml:"increase"`
+	// Steps is the amount of steps
+	Steps int `toml:"steps" yaml:"steps"`
+	// Times is the amount of repeats of Segments
+	Times int `toml:"times" yaml:"times"`
+	// Duration is in time.ParseDuration format, ex.: 1m30s
+	Duration string `toml:"duration" yaml:"duration"`
+	// Segments is a body of a repeat
+	Segments []SegmentSpec `toml:"segments" yaml:"segments"`
+}
+
+// ParseScheduleTOML parses a schedule from a TOML document
+func ParseScheduleTOML(data []byte) ([]*Segment, error) {
+	var spec ScheduleSpec
+	if err := toml.NewDecoder(bytes.NewReader(data)).DisallowUnknownFields().Decode(&spec); err != nil {
+		var strictErr *toml.StrictMissingError
+		if errors.As(err, &strictErr) && len(strictErr.Errors) > 0 {
+			return nil, tomlParseError(&strictErr.Errors[0], "unknown field")
+		}
+		var decodeErr *toml.DecodeError
+		if errors.As(err, &decodeErr) {
+			return nil, tomlParseError(decodeErr, decodeErr.Error())
+		}
+		return nil, err
+	}
+	return spec.Build()
+}
+
+func tomlParseError(err *toml.DecodeError, msg string) *ScheduleParseError {
+	row, col := err.Position()
+	return &ScheduleParseError{Line: row, Column: col, Token: strings.Join(err.Key(), "."), Msg: msg}
+}
+
+// ParseScheduleYAML parses a schedule from a YAML document
+func ParseScheduleYAML(data []byte) ([]*Segment, error) {
+	var spec ScheduleSpec
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&spec); err != nil {
+		return nil, yamlParseError(err)
+	}
+	return spec.Build()
+}
+
+// yamlParseError converts a YAML decoding error, only the first error is reported
+func yamlParseError(err error) *ScheduleParseError {
+	msg := err.Error()
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) && len(typeErr.Errors) > 0 {
+		msg = typeErr.Errors[0]
+	}
+	m := yamlErrorLine.FindStringSubmatch(msg)
+	if m == nil {
+		return &ScheduleParseError{Token: "<yaml>", Msg: strings.TrimPrefix(msg, "yaml: ")}
+	}
+	line, _ := strconv.Atoi(m[1])
+	return &ScheduleParseError{Line: line, Token: "<yaml>", Msg: m[2]}
+}
+
+// Build validates the spec and converts it to schedule segments
+func (m *ScheduleSpec) Build() ([]*Segment, error) {
+	if m.Schedule != "" && len(m.Segments) > 0 {
+		return nil, &ScheduleParseError{Token: "schedule", Msg: "either schedule or segments must be defined, not both"}
+	}
+	if m.Schedule != "" {
+		return ParseSchedule(m.Schedule)
+	}
+	if len(m.Segments) == 0 {
+		return nil, &ScheduleParseError{Token: "segments", Msg: ErrNoSchedule.Error()}
+	}
+	return buildSegmentSpecs("segments", m.Segments)
+}
+
+func buildSegmentSpecs(path string, specs []SegmentSpec) ([]*Segment, error) {
+	segs := make([]*Segment, 0)
+	for i, s := range specs {
+		ss, err := s.build(fmt.Sprintf("%s[%d]", path, i))
+		if err != nil {
+			return nil, err
+		}
+		segs = append(segs, ss...)
+		if len(segs) > MaxScheduleSegments {
+			return nil, &ScheduleParseError{Token: fmt.Sprintf("%s[%d]", path, i), Msg: fmt.Sprintf("schedule has more than %d segments", MaxScheduleSegments)}
+		}
+	}
+	return segs, nil
+}
+
+func (m *SegmentSpec) build(path string) ([]*Segment, error) {
+	fieldErr := func(field, msg string) error {
+		return &ScheduleParseError{Token: path + "." + field, Msg: msg}
+	}
+	switch m.Type {
+	case SegmentTypePlain, SegmentTypeRamp, SegmentTypeSteps, SegmentTypeRepeat:
+	default:
+		return nil, fieldErr("type", fmt.Sprintf("unknown segment type %q, expected plain, ramp, steps or repeat", m.Type))
+	}
+	if m.Type == SegmentTypeRepeat {
+		if m.Times <= 0 {
+			return nil, fieldErr("times", "repeat times must be > 0")
+		}
+		if len(m.Segments) == 0 {
+			return nil, fieldErr("segments", ErrNoSchedule.Error())
+		}
+		body, err := buildSegmentSpecs(path+".segments", m.Segments)
+		if err != nil {
+			return nil, err
+		}
+		if m.Times > MaxScheduleSegments/len(body) {
+			return nil, fieldErr("times", fmt.Sprintf("schedule has more than %d segments", MaxScheduleSegments))
+		}
+		return CombineAndRepeat(m.Times, body), nil
+	}
+	if m.From <= 0 {
+		return nil, fieldErr("from", ErrStartFrom.Error())
+	}
+	d, err := time.ParseDuration(m.Duration)
+	if err != nil {
+		return nil, fieldErr("duration", fmt.Sprintf("invalid duration %q", m.Duration))
+	}
+	if d <= 0 {
+		return nil, fieldErr("duration", "duration must be > 0")
+	}
+	switch m.Type {
+	case SegmentTypePlain:
+		return Plain(m.From, d), nil
+	case SegmentTypeRamp:
+		if m.To < 0 {
+			return nil, fieldErr("to", ErrInvalidSegmentTo.Error())
+		}
+		return Ramp(m.From, m.To, d), nil
+	default:
+		if m.Steps <= 0 {
+			return nil, fieldErr("steps", "steps must be > 0")
+		}
+		if m.Steps > MaxScheduleSegments {
+			return nil, fieldErr("steps", fmt.Sprintf("steps must be <= %d", MaxScheduleSegments))
+		}
+		if d/time.Duration(m.Steps) <= 0 {
+			return nil, fieldErr("duration", "step duration must be > 0")
+		}
+		if m.From+int64(m.Steps-1)*m.Increase <= 0 {
+			return nil, fieldErr("increase", "last step must be > 0")
+		}
+		return Steps(m.From, m.Increase, m.Steps, d), nil
+	}
+}
@@ -1,6 +1,8 @@
 package wasp
 
 import (
+	"math"
+	"math/rand"
 	"testing"
 	"time"
 
@@ -51,6 +53,18 @@ func TestSmokeSchedules(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:  "ramp",
+			input: Ramp(10, 200, 5*time.Minute),
+			output: []*Segment{
+				{
+					From:     10,
+					To:       200,
+					Ramp:     true,
+					Duration: 5 * time.Minute,
+				},
+			},
+		},
 		{
 			name:  "plain",
 			input: Plain(1, 1*time.Second),
@@ -112,3 +126,311 @@ func TestSmokeSchedules(t *testing.T) {
 		})
 	}
 }
+
+func TestSmokeSegmentValueAt(t *testing.T) {
+	s := Ramp(10, 110, 10*time.Second)[0]
+	require.Equal(t, true, s.IsRamp())
+	require.Equal(t, int64(10), s.ValueAt(0))
+	require.Equal(t, int64(60), s.ValueAt(5*time.Second))
+	require.Equal(t, int64(110), s.ValueAt(10*time.Second))
+	require.Equal(t, int64(110), s.ValueAt(20*time.Second))
+	down := Ramp(100, 50, 10*time.Second)[0]
+	require.Equal(t, int64(75), down.ValueAt(5*time.Second))
+	toZero := Ramp(10, 0, 10*time.Second)[0]
+	require.Equal(t, true, toZero.IsRamp())
+	require.Equal(t, int64(5), toZero.ValueAt(5*time.Second))
+	require.Equal(t, int64(0), toZero.ValueAt(10*time.Second))
+	plain := Plain(10, 10*time.Second)[0]
+	require.Equal(t, false, plain.IsRamp())
+	require.Equal(t, int64(10), plain.ValueAt(5*time.Second))
+}
+
+func TestSmokeParseSchedule(t *testing.T) {
+	type test struct {
+		name   string
+		input  string
+		output []*Segment
+	}
+
+	tests := []test{
+		{
+			name:   "plain",
+			input:  "10rps@1m",
+			output: Plain(10, 1*time.Minute),
+		},
+		{
+			name:  "plain, ramp and repeat",
+			input: "10rps@1m, ramp(10..200)@5m, 200rps@30m, repeat(2){ 50@10s, 100rps@1m30s }",
+			output: Combine(
+				Plain(10, 1*time.Minute),
+				Ramp(10, 200, 5*time.Minute),
+				Plain(200, 30*time.Minute),
+				CombineAndRepeat(2, Plain(50, 10*time.Second), Plain(100, 90*time.Second)),
+			),
+		},
+		{
+			name:   "ramp down to zero",
+			input:  "ramp(10..0)@1m",
+			output: Ramp(10, 0, 1*time.Minute),
+		},
+		{
+			name:   "decreasing steps",
+			input:  "steps(100vu, -10, 3)@30s",
+			output: Steps(100, -10, 3, 30*time.Second),
+		},
+		{
+			name:   "nested repeat",
+			input:  "repeat(2){repeat(2){1@1s}}",
+			output: CombineAndRepeat(4, Plain(1, 1*time.Second)),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			segs, err := ParseSchedule(tc.input)
+			require.NoError(t, err)
+			require.Equal(t, tc.output, segs)
+		})
+	}
+}
+
+func TestSmokeParseScheduleErrors(t *testing.T) {
+	type test struct {
+		name   string
+		input  string
+		column int
+		token  string
+	}
+
+	tests := []test{
+		{name: "empty", input: " ", column: 2, token: "<end>"},
+		{name: "zero from", input: "10rps@1m, 0rps@1m", column: 11, token: "0rps"},
+		{name: "invalid duration", input: "10rps@1x", column: 7, token: "1x"},
+		{name: "unknown construct", input: "sine(1..2)@1m", column: 1, token: "sine"},
+		{name: "unknown unit", input: "10rpm@1m", column: 3, token: "rpm"},
+		{name: "mixed units", input: "10rps@1m, 10vu@1m", column: 13, token: "vu"},
+		{name: "missing range", input: "ramp(10-200)@1m", column: 8, token: "-200"},
+		{name: "unclosed repeat", input: "repeat(3){10@1m", column: 16, token: "<end>"},
+		{name: "steps below zero", input: "steps(10, -10, 3)@1m", column: 11, token: "-10"},
+		{name: "trailing token", input: "10@1m 20@1m", column: 7, token: "20"},
+		{name: "zero step duration", input: "steps(10, 1, 3)@2ns", column: 17, token: "2ns"},
+		{name: "too many steps", input: "steps(1, 1, 1000000)@1h", column: 13, token: "1000000"},
+		{name: "too many repeats", input: "repeat(100000){1@1s, 2@1s}", column: 8, token: "100000"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseSchedule(tc.input)
+			var perr *ScheduleParseError
+			require.ErrorAs(t, err, &perr)
+			require.Equal(t, tc.column, perr.Column, err.Error())
+			require.Equal(t, tc.token, perr.Token, err.Error())
+		})
+	}
+	t.Run("units are checked against the load type", func(t *testing.T) {
+		_, err := ParseScheduleFor(RPS, "10@1m, 10vu@1m")
+		var perr *ScheduleParseError
+		require.ErrorAs(t, err, &perr)
+		require.Equal(t, 10, perr.Column)
+		require.Equal(t, "vu", perr.Token)
+		_, err = ParseScheduleFor(VU, "ramp(1rps..10)@1m")
+		require.ErrorAs(t, err, &perr)
+		require.Equal(t, "rps", perr.Token)
+		segs, err := ParseScheduleFor(VU, "10vu@1m, 20@1m")
+		require.NoError(t, err)
+		require.Equal(t, Combine(Plain(10, time.Minute), Plain(20, time.Minute)), segs)
+	})
+}
+
+func TestSmokeParseScheduleSpec(t *testing.T) {
+	expected := Combine(
+		Plain(10, 1*time.Minute),
+		Ramp(10, 200, 5*time.Minute),
+		CombineAndRepeat(3, Steps(10, 10, 2, 20*time.Second)),
+	)
+	t.Run("toml", func(t *testing.T) {
+		segs, err := ParseScheduleTOML([]byte(`
+[[segments]]
+type = "plain"
+from = 10
+duration = "1m"
+
+[[segments]]
+type = "ramp"
+from = 10
+to = 200
+duration = "5m"
+
+[[segments]]
+type = "repeat"
+times = 3
+  [[segments.segments]]
+  type = "steps"
+  from = 10
+  increase = 10
+  steps = 2
+  duration = "20s"
+`))
+		require.NoError(t, err)
+		require.Equal(t, expected, segs)
+	})
+	t.Run("yaml", func(t *testing.T) {
+		segs, err := ParseScheduleYAML([]byte(`
+segments:
+  - type: plain
+    from: 10
+    duration: 1m
+  - type: ramp
+    from: 10
+    to: 200
+    duration: 5m
+  - type: repeat
+    times: 3
+    segments:
+      - type: steps
+        from: 10
+        increase: 10
+        steps: 2
+        duration: 20s
+`))
+		require.NoError(t, err)
+		require.Equal(t, expected, segs)
+	})
+	t.Run("dsl string", func(t *testing.T) {
+		segs, err := ParseScheduleTOML([]byte(`schedule = "10rps@1m, ramp(10..200)@5m, repeat(3){steps(10,10,2)@20s}"`))
+		require.NoError(t, err)
+		require.Equal(t, expected, segs)
+	})
+	t.Run("invalid field", func(t *testing.T) {
+		_, err := ParseScheduleYAML([]byte(`
+segments:
+  - type: repeat
+    times: 2
+    segments:
+      - type: plain
+        from: 10
+        duration: 1q
+`))
+		var perr *ScheduleParseError
+		require.ErrorAs(t, err, &perr)
+		require.Equal(t, "segments[0].segments[0].duration", perr.Token)
+	})
+	t.Run("yaml decoding errors", func(t *testing.T) {
+		_, err := ParseScheduleYAML([]byte("segments:\n  - type: plain\n    frm: 10\n"))
+		var perr *ScheduleParseError
+		require.ErrorAs(t, err, &perr)
+		require.Equal(t, 3, perr.Line)
+		require.Contains(t, perr.Msg, "frm")
+		_, err = ParseScheduleYAML([]byte("segments: [\n"))
+		require.ErrorAs(t, err, &perr)
+	})
+	t.Run("zero step duration", func(t *testing.T) {
+		_, err := ParseScheduleYAML([]byte("segments:\n  - type: steps\n    from: 1\n    steps: 10\n    duration: 5ns\n"))
+		var perr *ScheduleParseError
+		require.ErrorAs(t, err, &perr)
+		require.Equal(t, "segments[0].duration", perr.Token)
+	})
+	t.Run("unknown toml field", func(t *testing.T) {
+		_, err := ParseScheduleTOML([]byte("[[segments]]\ntype = \"plain\"\nfrm = 10\n"))
+		var perr *ScheduleParseError
+		require.ErrorAs(t, err, &perr)
+		require.Equal(t, 3, perr.Line)
+		require.Equal(t, "segments.frm", perr.Token)
+	})
+}
+
+func TestSmokeWaveSchedules(t *testing.T) {
+	t.Run("sine", func(t *testing.T) {
+		segs, err := Sine(100, 50, 20*time.Second, 30*time.Second)
+		require.NoError(t, err)
+		require.Len(t, segs, 30)
+		var total time.Duration
+		for _, s := range segs {
+			total += s.Duration
+			require.GreaterOrEqual(t, s.From, int64(50))
+			require.LessOrEqual(t, s.From, int64(150))
+		}
+		require.Equal(t, 30*time.Second, total)
+		require.Equal(t, int64(100), segs[0].From)
+		require.Equal(t, int64(150), segs[5].From)
+		require.Equal(t, int64(100), segs[10].From)
+		require.Equal(t, int64(50), segs[15].From)
+		require.Equal(t, segs[1].From, segs[0].To)
+	})
+	t.Run("sine never goes below 1", func(t *testing.T) {
+		segs, err := Sine(10, 50, 10*time.Second, 10*time.Second)
+		require.NoError(t, err)
+		for _, s := range segs {
+			require.GreaterOrEqual(t, s.From, int64(1))
+		}
+	})
+	t.Run("spike", func(t *testing.T) {
+		segs, err := Spike(10, 100, 2*time.Second, 10*time.Second, 19*time.Second)
+		require.NoError(t, err)
+		require.Equal(t, []*Segment{
+			{From: 10, Duration: 8 * time.Second},
+			{From: 100, Duration: 2 * time.Second},
+			{From: 10, Duration: 8 * time.Second},
+			{From: 100, Duration: 1 * time.Second},
+		}, segs)
+	})
+	t.Run("square wave", func(t *testing.T) {
+		segs, err := SquareWave(10, 100, 10*time.Second, 15*time.Second)
+		require.NoError(t, err)
+		require.Equal(t, []*Segment{
+			{From: 100, Duration: 5 * time.Second},
+			{From: 10, Duration: 5 * time.Second},
+			{From: 100, Duration: 5 * time.Second},
+		}, segs)
+	})
+	t.Run("random walk", func(t *testing.T) {
+		//nolint
+		segs, err := RandomWalk(100, 20, 5, 1*time.Second, 1*time.Minute, rand.New(rand.NewSource(1)))
+		require.NoError(t, err)
+		require.Len(t, segs, 60)
+		require.Equal(t, int64(100), segs[0].From)
+		for i, s := range segs {
+			require.GreaterOrEqual(t, s.From, int64(80))
+			require.LessOrEqual(t, s.From, int64(120))
+			if i > 0 {
+				require.LessOrEqual(t, math.Abs(float64(s.From-segs[i-1].From)), float64(5))
+			}
+		}
+		//nolint
+		_, err = RandomWalk(100, 20, -1, time.Second, time.Minute, rand.New(rand.NewSource(1)))
+		require.ErrorIs(t, err, ErrInvalidRandomWalk)
+		_, err = RandomWalk(100, 20, 5, time.Second, time.Minute, nil)
+		require.ErrorIs(t, err, ErrInvalidRandomWalk)
+	})
+	t.Run("combine", func(t *testing.T) {
+		wave, err := SquareWave(1, 2, 2*time.Second, 2*time.Second)
+		require.NoError(t, err)
+		segs := Combine(Plain(10, 1*time.Second), wave)
+		require.Len(t, segs, 3)
+	})
+	t.Run("invalid durations", func(t *testing.T) {
+		_, err := Sine(100, 50, 0, time.Minute)
+		require.ErrorIs(t, err, ErrInvalidSegmentDuration)
+		_, err = Spike(10, 100, 10*time.Second, 10*time.Second, time.Minute)
+		require.ErrorIs(t, err, ErrInvalidSegmentDuration)
+		_, err = SquareWave(10, 100, time.Second, 0)
+		require.ErrorIs(t, err, ErrInvalidSegmentDuration)
+		//nolint
+		_, err = RandomWalk(100, 20, 5, 0, time.Minute, rand.New(rand.NewSource(1)))
+		require.ErrorIs(t, err, ErrInvalidSegmentDuration)
+	})
+	t.Run("too many segments", func(t *testing.T) {
+		_, err := Sine(100, 50, time.Microsecond, time.Hour)
+		require.ErrorIs(t, err, ErrTooManySegments)
+		_, err = Spike(10, 100, time.Microsecond, time.Millisecond, time.Hour)
+		require.ErrorIs(t, err, ErrTooManySegments)
+		_, err = SquareWave(10, 100, 2, time.Hour)
+		require.ErrorIs(t, err, ErrTooManySegments)
+		//nolint
+		_, err = RandomWalk(100, 20, 5, time.Microsecond, time.Hour, rand.New(rand.NewSource(1)))
+		require.ErrorIs(t, err, ErrTooManySegments)
+		segs, err := Sine(100, 50, time.Second, MaxScheduleSegments/DefaultWavePrecision*time.Second)
+		require.NoError(t, err)
+		require.Len(t, segs, MaxScheduleSegments)
+	})
+}
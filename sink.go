@@ -0,0 +1,315 @@
+package wasp
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+/* Pluggable sinks for responses and stats */
+
+const (
+	DefaultSinkBufferSize = 50000
+)
+
+var (
+	ErrNoSink              = errors.New("sink must be set")
+	ErrInvalidSinkBuffer   = errors.New("sink buffer size must be >= 0")
+	ErrInvalidBackpressure = errors.New("invalid backpressure policy, use block, drop_newest or drop_oldest")
+)
+
+// Sink receives generator results, implement it to push them to your own backend.
+// HandleResponse and HandleStats are called from two different goroutines and can run concurrently,
+// each of them is never called concurrently with itself, Close is called after both of them returned
+type Sink interface {
+	// Name is a sink name used in logs
+	Name() string
+	// HandleResponse handles a sampled call result, the response must not be modified
+	HandleResponse(g *Generator, r *Response) error
+	// HandleStats handles StatsJSON, it's called every Config.StatsPollInterval
+	HandleStats(g *Generator, stats map[string]interface{}) error
+	// Close flushes and closes the sink when the generator finishes
+	Close() error
+}
+
+// GeneratorSink is a Sink that can be shared by several generators,
+// a generator calls CloseGenerator instead of Close when it finishes, so sinks of other generators stay open
+type GeneratorSink interface {
+	Sink
+	// CloseGenerator flushes and closes what was written for one generator
+	CloseGenerator(g *Generator) error
+}
+
+// BackpressurePolicy defines what happens with a response when a sink buffer is full
+type BackpressurePolicy string
+
+const (
+	// BackpressureBlock blocks the call until the sink has space
+	BackpressureBlock BackpressurePolicy = "block"
+	// BackpressureDropNewest drops the response
+	BackpressureDropNewest BackpressurePolicy = "drop_newest"
+	// BackpressureDropOldest drops the oldest buffered response to make space
+	BackpressureDropOldest BackpressurePolicy = "drop_oldest"
+)
+
+// SinkConfig is a sink with its buffering and backpressure policy
+type SinkConfig struct {
+	Sink Sink
+	// BufferSize is the amount of responses buffered for the sink, default is DefaultSinkBufferSize
+	BufferSize int
+	// Backpressure is applied when the buffer is full, default is BackpressureBlock
+	Backpressure BackpressurePolicy
+	// StopOnError stops the generator if the sink returns an error
+	StopOnError bool
+}
+
+func (m *SinkConfig) Validate() error {
+	if m.Sink == nil {
+		return ErrNoSink
+	}
+	if m.BufferSize < 0 {
+		return ErrInvalidSinkBuffer
+	}
+	if m.BufferSize == 0 {
+		m.BufferSize = DefaultSinkBufferSize
+	}
+	if m.Backpressure == "" {
+		m.Backpressure = BackpressureBlock
+	}
+	switch m.Backpressure {
+	case BackpressureBlock, BackpressureDropNewest, BackpressureDropOldest:
+	default:
+		return ErrInvalidBackpressure
+	}
+	return nil
+}
+
+// sinkRunner buffers responses for one sink
+type sinkRunner struct {
+	cfg       *SinkConfig
+	responses chan *Response
+	// flush asks the stats goroutine to push stats before the next tick
+	flush   chan struct{}
+	dropped atomic.Int64
+}
+
+func newSinkRunner(cfg *SinkConfig) *sinkRunner {
+	return &sinkRunner{
+		cfg:       cfg,
+		responses: make(chan *Response, cfg.BufferSize),
+		flush:     make(chan struct{}, 1),
+	}
+}
+
+// push buffers a response according to the backpressure policy
+func (m *sinkRunner) push(r *Response) {
+	switch m.cfg.Backpressure {
+	case BackpressureDropNewest:
+		select {
+		case m.responses <- r:
+		default:
+			m.dropped.Add(1)
+		}
+	case BackpressureDropOldest:
+		for {
+			select {
+			case m.responses <- r:
+				return
+			default:
+			}
+			select {
+			case <-m.responses:
+				m.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		m.responses <- r
+	}
+}
+
+// pushToSinks buffers a response for all the sinks
+func (g *Generator) pushToSinks(r *Response) {
+	for _, s := range g.sinks {
+		s.push(r)
+	}
+}
+
+// handleSinkErr logs sink error and stops the generator if required
+func (g *Generator) handleSinkErr(s *sinkRunner, err error) {
+	if err == nil {
+		return
+	}
+	g.Log.Err(err).Str("Sink", s.cfg.Sink.Name()).Send()
+	if s.cfg.StopOnError {
+		// Stop waits for sinks goroutines, including the caller
+		go g.Stop()
+	}
+}
+
+// startSinks pushes responses and stats to all the sinks until the generator finishes
+func (g *Generator) startSinks() {
+	for _, s := range g.sinks {
+		s := s
+		g.Log.Info().
+			Str("Sink", s.cfg.Sink.Name()).
+			Interface("DefaultLabels", g.Cfg.Labels).
+			Msg("Streaming data to sink")
+		g.sinksWaitGroup.Add(2)
+		go func() {
+			defer g.sinksWaitGroup.Done()
+			// the channel is closed when nothing can push to it anymore, so everything buffered is handled
+			for r := range s.responses {
+				g.handleSinkErr(s, s.cfg.Sink.HandleResponse(g, r))
+			}
+			g.Log.Info().Str("Sink", s.cfg.Sink.Name()).Msg("Sink responses exited")
+		}()
+		go func() {
+			defer g.sinksWaitGroup.Done()
+			ticker := time.NewTicker(g.Cfg.StatsPollInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-g.sinksDone:
+					// final stats, after all the calls are finished
+					g.handleSinkErr(s, s.cfg.Sink.HandleStats(g, g.StatsJSON()))
+					g.Log.Info().Str("Sink", s.cfg.Sink.Name()).Msg("Sink stats exited")
+					return
+				case <-ticker.C:
+					g.handleSinkErr(s, s.cfg.Sink.HandleStats(g, g.StatsJSON()))
+				case <-s.flush:
+					g.handleSinkErr(s, s.cfg.Sink.HandleStats(g, g.StatsJSON()))
+				}
+			}
+		}()
+	}
+}
+
+// pushStatsToSinks asks stats goroutines of all the sinks to push current stats without waiting for the next tick
+func (g *Generator) pushStatsToSinks() {
+	for _, s := range g.sinks {
+		select {
+		case s.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// closeSinks stops sinks goroutines after all the producers of responses stopped and closes all the sinks
+func (g *Generator) closeSinks() {
+	g.dataCancel()
+	// calls are finished, the VU results collector is the last producer
+	g.dataWaitGroup.Wait()
+	g.sinksCloseOnce.Do(func() {
+		g.releaseSamples(true)
+		for _, s := range g.sinks {
+			close(s.responses)
+		}
+		close(g.sinksDone)
+		g.sinksWaitGroup.Wait()
+		for _, s := range g.sinks {
+			name := s.cfg.Sink.Name()
+			if d := s.dropped.Load(); d > 0 {
+				g.Log.Warn().Str("Sink", name).Int64("Dropped", d).Msg("Sink buffer was full, responses were dropped")
+			}
+			g.Log.Info().Str("Sink", name).Msg("Closing sink")
+			if err := g.closeSink(s.cfg.Sink); err != nil {
+				g.Log.Err(err).Str("Sink", name).Msg("Failed to close sink")
+			}
+		}
+	})
+}
+
+// closeSink closes a sink, or only the part of this generator if the sink is shared
+func (g *Generator) closeSink(s Sink) error {
+	if gs, ok := s.(GeneratorSink); ok {
+		return gs.CloseGenerator(g)
+	}
+	return s.Close()
+}
+
+// SinkDropped returns the amount of responses dropped by all the sinks because their buffers were full
+func (g *Generator) SinkDropped() int64 {
+	var dropped int64
+	for _, s := range g.sinks {
+		dropped += s.dropped.Load()
+	}
+	return dropped
+}
+
+// LokiSpoolStats returns spool counters of the LokiConfig sink, nil if Loki or its spool is not set
+func (g *Generator) LokiSpoolStats() *LokiSpoolStats {
+	if g.loki == nil {
+		return nil
+	}
+	return g.loki.SpoolStats()
+}
+
+// LokiSink pushes responses and stats to Loki
+type LokiSink struct {
+	cfg    *LokiConfig
+	client *LokiClient
+}
+
+// NewLokiSink creates a new Loki sink
+func NewLokiSink(cfg *LokiConfig) (*LokiSink, error) {
+	c, err := NewLokiClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &LokiSink{cfg: cfg, client: c}, nil
+}
+
+func (m *LokiSink) Name() string {
+	return "loki"
+}
+
+// HandleResponse handles CallResult payload with adding default labels
+// adding custom CallResult labels if present
+func (m *LokiSink) HandleResponse(g *Generator, r *Response) error {
+	labels := g.labels.Merge(model.LabelSet{
+		"test_data_type": "responses",
+		CallGroupLabel:   model.LabelValue(r.Group),
+	})
+	// we are removing time.Time{} because when it marshalled to string it creates N responses for some Loki queries
+	// and to minimize the payload, duration is already calculated at that point
+	payload := *r
+	payload.StartedAt = nil
+	payload.FinishedAt = nil
+	payload.IntendedAt = nil
+	return m.client.HandleStruct(labels, *r.FinishedAt, payload)
+}
+
+// HandleStats handles StatsJSON payload with adding default labels
+// this stream serves as a debug data and shouldn't be customized with additional labels
+func (m *LokiSink) HandleStats(g *Generator, stats map[string]interface{}) error {
+	ls := g.labels.Merge(model.LabelSet{
+		"test_data_type": "stats",
+	})
+	if spool := m.client.SpoolStats(); spool != nil {
+		withSpool := make(map[string]interface{}, len(stats)+3)
+		for k, v := range stats {
+			withSpool[k] = v
+		}
+		withSpool["loki_spooled"] = spool.Spooled.Load()
+		withSpool["loki_spool_replayed"] = spool.Replayed.Load()
+		withSpool["loki_spool_dropped"] = spool.Dropped.Load()
+		stats = withSpool
+	}
+	return m.client.HandleStruct(ls, time.Now(), stats)
+}
+
+// SpoolStats returns spool counters, nil if spool is not set
+func (m *LokiSink) SpoolStats() *LokiSpoolStats {
+	return m.client.SpoolStats()
+}
+
+// Close stops the Loki stream client, replaying the spool if it's set
+func (m *LokiSink) Close() error {
+	if m.cfg.URL != "" {
+		return m.client.Close()
+	}
+	return nil
+}
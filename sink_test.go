@@ -0,0 +1,186 @@
+package wasp
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// memSink stores everything in memory
+type memSink struct {
+	mu        *sync.Mutex
+	delay     time.Duration
+	err       error
+	responses []*Response
+	stats     []map[string]interface{}
+	closed    bool
+	// afterClose are responses handled after the sink was closed
+	afterClose int
+}
+
+func newMemSink() *memSink {
+	return &memSink{mu: &sync.Mutex{}}
+}
+
+func (m *memSink) Name() string { return "mem" }
+
+func (m *memSink) HandleResponse(_ *Generator, r *Response) error {
+	time.Sleep(m.delay)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		m.afterClose++
+	}
+	m.responses = append(m.responses, r)
+	return m.err
+}
+
+func (m *memSink) HandleStats(_ *Generator, stats map[string]interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats = append(m.stats, stats)
+	return nil
+}
+
+func (m *memSink) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
+
+func TestSmokeSinks(t *testing.T) {
+	t.Parallel()
+	t.Run("responses and stats are pushed to all the sinks", func(t *testing.T) {
+		t.Parallel()
+		a, b := newMemSink(), newMemSink()
+		gen, err := NewGenerator(&Config{
+			T:                 t,
+			LoadType:          RPS,
+			Schedule:          Plain(20, 1*time.Second),
+			StatsPollInterval: 100 * time.Millisecond,
+			Sinks:             []*SinkConfig{{Sink: a}, {Sink: b, BufferSize: 1}},
+			Gun:               NewMockGun(&MockGunConfig{CallSleep: 10 * time.Millisecond}),
+		})
+		require.NoError(t, err)
+		_, failed := gen.Run(true)
+		require.Equal(t, false, failed)
+		calls := int(gen.Stats().Latencies.All.Count())
+		for _, s := range []*memSink{a, b} {
+			require.True(t, s.closed)
+			require.Len(t, s.responses, calls)
+			require.GreaterOrEqual(t, len(s.stats), 5)
+			require.Equal(t, int64(calls), s.stats[len(s.stats)-1]["success"])
+		}
+		require.Equal(t, int64(0), gen.SinkDropped())
+	})
+	t.Run("slow sink drops responses", func(t *testing.T) {
+		t.Parallel()
+		newest, oldest := newMemSink(), newMemSink()
+		newest.delay = 50 * time.Millisecond
+		oldest.delay = 50 * time.Millisecond
+		gen, err := NewGenerator(&Config{
+			T:        t,
+			LoadType: RPS,
+			Schedule: Plain(100, 1*time.Second),
+			Sinks: []*SinkConfig{
+				{Sink: newest, BufferSize: 5, Backpressure: BackpressureDropNewest},
+				{Sink: oldest, BufferSize: 5, Backpressure: BackpressureDropOldest},
+			},
+			Gun: NewMockGun(&MockGunConfig{CallSleep: 1 * time.Millisecond}),
+		})
+		require.NoError(t, err)
+		start := time.Now()
+		gen.Run(true)
+		require.Less(t, time.Since(start), 2*time.Second)
+		calls := int(gen.Stats().Latencies.All.Count())
+		require.Greater(t, gen.SinkDropped(), int64(0))
+		require.Equal(t, int64(2*calls), gen.SinkDropped()+int64(len(newest.responses)+len(oldest.responses)))
+		// drop_oldest keeps the latest responses
+		last := oldest.responses[len(oldest.responses)-1]
+		for _, r := range newest.responses {
+			require.True(t, !r.FinishedAt.After(*last.FinishedAt))
+		}
+	})
+	t.Run("blocking sink gets all the VU responses", func(t *testing.T) {
+		t.Parallel()
+		s := newMemSink()
+		gen, err := NewGenerator(&Config{
+			T:        t,
+			LoadType: VU,
+			Schedule: Plain(1, 1*time.Second),
+			Sinks:    []*SinkConfig{{Sink: s, BufferSize: 1}},
+			VU:       NewMockVU(&MockVirtualUserConfig{}),
+		})
+		require.NoError(t, err)
+		gen.startSinks()
+		gen.collectVUResults()
+		// VU calls that are still in flight push responses while the sinks are closed
+		stop := make(chan struct{})
+		defer close(stop)
+		for i := 0; i < 1000; i++ {
+			go func() {
+				select {
+				case gen.ResponsesChan <- &Response{Data: "successCallData"}:
+				case <-stop:
+				}
+			}()
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			gen.closeSinks()
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			require.FailNow(t, "sinks are blocked by a full buffer")
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		require.True(t, s.closed)
+		require.Zero(t, s.afterClose)
+		require.Equal(t, gen.Stats().SamplesRecorded.Load(), int64(len(s.responses)))
+	})
+	t.Run("sink error stops the generator", func(t *testing.T) {
+		t.Parallel()
+		s := newMemSink()
+		s.err = errors.New("backend is down")
+		gen, err := NewGenerator(&Config{
+			T:        t,
+			LoadType: RPS,
+			Schedule: Plain(10, 10*time.Second),
+			Sinks:    []*SinkConfig{{Sink: s, StopOnError: true}},
+			Gun:      NewMockGun(&MockGunConfig{CallSleep: 10 * time.Millisecond}),
+		})
+		require.NoError(t, err)
+		start := time.Now()
+		_, failed := gen.Run(true)
+		require.Equal(t, true, failed)
+		require.Less(t, time.Since(start), 2*time.Second)
+		require.True(t, gen.Stats().RunStopped.Load())
+	})
+	t.Run("validation", func(t *testing.T) {
+		t.Parallel()
+		for _, tc := range []struct {
+			cfg *SinkConfig
+			err error
+		}{
+			{&SinkConfig{}, ErrNoSink},
+			{&SinkConfig{Sink: newMemSink(), BufferSize: -1}, ErrInvalidSinkBuffer},
+			{&SinkConfig{Sink: newMemSink(), Backpressure: "spill"}, ErrInvalidBackpressure},
+		} {
+			_, err := NewGenerator(&Config{
+				T:        t,
+				LoadType: RPS,
+				Schedule: Plain(1, 1*time.Second),
+				Gun:      NewMockGun(&MockGunConfig{}),
+				Sinks:    []*SinkConfig{tc.cfg},
+			})
+			require.ErrorIs(t, err, tc.err)
+		}
+	})
+}
@@ -0,0 +1,293 @@
+package wasp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+/* Local threshold assertions checked against in-process results when the run ends */
+
+// ThresholdMetric is a metric a threshold is checked against
+type ThresholdMetric string
+
+const (
+	// ThresholdLatency checks a latency percentile of all the calls or of a Response.Group
+	ThresholdLatency ThresholdMetric = "latency"
+	// ThresholdErrorRate checks a ratio of failed calls, timeouts are not included
+	ThresholdErrorRate ThresholdMetric = "error_rate"
+	// ThresholdTimeoutRate checks a ratio of timed out calls
+	ThresholdTimeoutRate ThresholdMetric = "timeout_rate"
+	// ThresholdAchievedRPS checks a ratio of achieved and scheduled RPS, only RPS generators are checked
+	ThresholdAchievedRPS ThresholdMetric = "achieved_rps"
+)
+
+var (
+	ErrInvalidThreshold = errors.New("invalid threshold")
+)
+
+// Threshold is an assertion on in-process results, use LatencyThreshold, ErrorRateThreshold,
+// TimeoutRateThreshold or AchievedRPSThreshold to create one
+type Threshold struct {
+	Metric ThresholdMetric
+	// Group is a Response.Group for latency thresholds, empty means all the calls
+	Group string
+	// Percentile for latency thresholds, (0, 100]
+	Percentile float64
+	// MaxLatency is an exclusive upper bound for latency thresholds
+	MaxLatency time.Duration
+	// MaxRate is an exclusive upper bound for error and timeout rate thresholds, 0-1
+	MaxRate float64
+	// MinRatio is an inclusive lower bound of achieved to scheduled RPS, 0-1
+	MinRatio float64
+}
+
+// LatencyThreshold asserts that the latency percentile of all the calls is lower than max
+func LatencyThreshold(percentile float64, max time.Duration) *Threshold {
+	return &Threshold{Metric: ThresholdLatency, Percentile: percentile, MaxLatency: max}
+}
+
+// GroupLatencyThreshold asserts that the latency percentile of a Response.Group is lower than max
+func GroupLatencyThreshold(group string, percentile float64, max time.Duration) *Threshold {
+	return &Threshold{Metric: ThresholdLatency, Group: group, Percentile: percentile, MaxLatency: max}
+}
+
+// ErrorRateThreshold asserts that the ratio of failed calls is lower than max, 0.001 is 0.1%
+func ErrorRateThreshold(max float64) *Threshold {
+	return &Threshold{Metric: ThresholdErrorRate, MaxRate: max}
+}
+
+// TimeoutRateThreshold asserts that the ratio of timed out calls is lower than max, 0.001 is 0.1%
+func TimeoutRateThreshold(max float64) *Threshold {
+	return &Threshold{Metric: ThresholdTimeoutRate, MaxRate: max}
+}
+
+// AchievedRPSThreshold asserts that achieved RPS is at least min ratio of scheduled RPS, 0.95 is 95%
+func AchievedRPSThreshold(min float64) *Threshold {
+	return &Threshold{Metric: ThresholdAchievedRPS, MinRatio: min}
+}
+
+func (m *Threshold) Validate() error {
+	switch m.Metric {
+	case ThresholdLatency:
+		if m.Percentile <= 0 || m.Percentile > 100 {
+			return fmt.Errorf("%w: %s, %s", ErrInvalidThreshold, m, ErrInvalidPercentile)
+		}
+		if m.MaxLatency <= 0 {
+			return fmt.Errorf("%w: %s, max latency must be > 0", ErrInvalidThreshold, m)
+		}
+		return nil
+	case ThresholdErrorRate, ThresholdTimeoutRate:
+		if m.MaxRate <= 0 || m.MaxRate > 1 {
+			return fmt.Errorf("%w: %s, max rate must be > 0 and <= 1", ErrInvalidThreshold, m)
+		}
+	case ThresholdAchievedRPS:
+		if m.MinRatio <= 0 || m.MinRatio > 1 {
+			return fmt.Errorf("%w: %s, min ratio must be > 0 and <= 1", ErrInvalidThreshold, m)
+		}
+	default:
+		return fmt.Errorf("%w: unknown metric %q", ErrInvalidThreshold, m.Metric)
+	}
+	if m.Group != "" {
+		return fmt.Errorf("%w: %s, group can only be used with latency thresholds", ErrInvalidThreshold, m)
+	}
+	return nil
+}
+
+// String describes the threshold, ex.: p99 < 200ms for group auth
+func (m *Threshold) String() string {
+	switch m.Metric {
+	case ThresholdLatency:
+		s := fmt.Sprintf("%s < %s", PercentileKey(m.Percentile), m.MaxLatency)
+		if m.Group != "" {
+			s += fmt.Sprintf(" for group %s", m.Group)
+		}
+		return s
+	case ThresholdErrorRate:
+		return fmt.Sprintf("error rate < %g%%", m.MaxRate*100)
+	case ThresholdTimeoutRate:
+		return fmt.Sprintf("timeout rate < %g%%", m.MaxRate*100)
+	case ThresholdAchievedRPS:
+		return fmt.Sprintf("achieved RPS >= %g%% of scheduled", m.MinRatio*100)
+	default:
+		return string(m.Metric)
+	}
+}
+
+// ThresholdResult is a result of a threshold check
+type ThresholdResult struct {
+	Name      string `json:"name"`
+	Threshold string `json:"threshold"`
+	Actual    string `json:"actual"`
+	Passed    bool   `json:"passed"`
+}
+
+// thresholdInput are in-process results of one generator or of a whole profile
+type thresholdInput struct {
+	name      string
+	latencies *Latencies
+	requests  int64
+	failed    int64
+	timeouts  int64
+	// scheduled and achieved requests of RPS segments
+	rps               bool
+	scheduledRequests float64
+	achievedRequests  float64
+}
+
+func (g *Generator) thresholdInput() *thresholdInput {
+	in := &thresholdInput{
+		name:      g.Cfg.GenName,
+		latencies: g.stats.Latencies,
+		requests:  g.stats.Latencies.All.Count(),
+		failed:    g.stats.Failed.Load() - g.stats.CallTimeout.Load(),
+		timeouts:  g.stats.CallTimeout.Load(),
+		rps:       g.Cfg.LoadType == RPS,
+	}
+	if in.rps {
+		for _, s := range g.segmentReports() {
+			in.scheduledRequests += s.ScheduledRPS * s.ActualDuration
+			in.achievedRequests += float64(s.Requests)
+		}
+	}
+	return in
+}
+
+// mergeThresholdInputs merges results of several generators
+func mergeThresholdInputs(name string, inputs []*thresholdInput) *thresholdInput {
+	res := &thresholdInput{name: name, latencies: NewLatencies()}
+	for _, in := range inputs {
+		res.latencies.Merge(in.latencies)
+		res.requests += in.requests
+		res.failed += in.failed
+		res.timeouts += in.timeouts
+		if in.rps {
+			res.rps = true
+			res.scheduledRequests += in.scheduledRequests
+			res.achievedRequests += in.achievedRequests
+		}
+	}
+	return res
+}
+
+func (m *Threshold) check(in *thresholdInput) *ThresholdResult {
+	r := &ThresholdResult{Name: in.name, Threshold: m.String()}
+	if in.requests == 0 {
+		r.Actual = "no calls"
+		return r
+	}
+	switch m.Metric {
+	case ThresholdLatency:
+		h := in.latencies.All
+		if m.Group != "" {
+			found := false
+			for _, name := range in.latencies.Groups() {
+				found = found || name == m.Group
+			}
+			if !found {
+				r.Actual = "no calls in group"
+				return r
+			}
+			h = in.latencies.Group(m.Group)
+		}
+		v := h.Percentile(m.Percentile)
+		r.Actual = v.String()
+		r.Passed = v < m.MaxLatency
+	case ThresholdErrorRate:
+		v := float64(in.failed) / float64(in.requests)
+		r.Actual = fmt.Sprintf("%.3f%%", v*100)
+		r.Passed = v < m.MaxRate
+	case ThresholdTimeoutRate:
+		v := float64(in.timeouts) / float64(in.requests)
+		r.Actual = fmt.Sprintf("%.3f%%", v*100)
+		r.Passed = v < m.MaxRate
+	case ThresholdAchievedRPS:
+		if !in.rps || in.scheduledRequests == 0 {
+			r.Actual = "no RPS schedule"
+			return r
+		}
+		v := in.achievedRequests / in.scheduledRequests
+		r.Actual = fmt.Sprintf("%.1f%%", v*100)
+		r.Passed = v >= m.MinRatio
+	}
+	return r
+}
+
+func checkThresholds(in *thresholdInput, thresholds []*Threshold) []*ThresholdResult {
+	res := make([]*ThresholdResult, 0, len(thresholds))
+	for _, t := range thresholds {
+		res = append(res, t.check(in))
+	}
+	return res
+}
+
+// CheckThresholds checks Config.Thresholds against in-process results
+func (g *Generator) CheckThresholds() []*ThresholdResult {
+	return checkThresholds(g.thresholdInput(), g.Cfg.Thresholds)
+}
+
+// CheckThresholds checks profile thresholds against merged results of all the generators
+func (m *Profile) CheckThresholds() []*ThresholdResult {
+	inputs := make([]*thresholdInput, 0)
+	for _, g := range m.Generators {
+		inputs = append(inputs, g.thresholdInput())
+	}
+	return checkThresholds(mergeThresholdInputs(fmt.Sprintf("profile_%s", m.ProfileID), inputs), m.thresholds)
+}
+
+// ThresholdsTable formats threshold results as a pass/fail table
+func ThresholdsTable(results []*ThresholdResult) string {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTHRESHOLD\tACTUAL\tRESULT")
+	for _, r := range results {
+		result := "PASS"
+		if !r.Passed {
+			result = "FAIL"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Name, r.Threshold, r.Actual, result)
+	}
+	_ = w.Flush()
+	return sb.String()
+}
+
+// thresholdsFailer is a part of testing.T used to fail the test
+type thresholdsFailer interface {
+	Errorf(format string, args ...any)
+}
+
+// reportThresholds logs threshold results and fails the test if any of them failed, t can be nil
+func reportThresholds(t *testing.T, l zerolog.Logger, results []*ThresholdResult) bool {
+	if t == nil {
+		return reportThresholdsTo(nil, l, results)
+	}
+	return reportThresholdsTo(t, l, results)
+}
+
+// reportThresholdsTo returns false if any threshold failed
+func reportThresholdsTo(f thresholdsFailer, l zerolog.Logger, results []*ThresholdResult) bool {
+	if len(results) == 0 {
+		return true
+	}
+	failed := 0
+	for _, r := range results {
+		if !r.Passed {
+			failed++
+		}
+	}
+	table := ThresholdsTable(results)
+	if failed == 0 {
+		l.Info().Msgf("All thresholds passed\n%s", table)
+		return true
+	}
+	l.Error().Msgf("%d of %d thresholds failed\n%s", failed, len(results), table)
+	if f != nil {
+		f.Errorf("%d of %d thresholds failed\n%s", failed, len(results), table)
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package wasp
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+// failRecorder records Errorf calls instead of failing the test
+type failRecorder struct {
+	msgs []string
+}
+
+func (m *failRecorder) Errorf(format string, args ...any) {
+	m.msgs = append(m.msgs, fmt.Sprintf(format, args...))
+}
+
+func TestSmokeThresholds(t *testing.T) {
+	t.Parallel()
+	t.Run("generator thresholds pass", func(t *testing.T) {
+		t.Parallel()
+		gen, err := NewGenerator(&Config{
+			T:        t,
+			LoadType: RPS,
+			Schedule: Plain(20, 1*time.Second),
+			Gun: NewMockGun(&MockGunConfig{
+				CallSleep: 10 * time.Millisecond,
+			}),
+			Thresholds: []*Threshold{
+				LatencyThreshold(99, 1*time.Second),
+				ErrorRateThreshold(0.001),
+				TimeoutRateThreshold(0.001),
+				AchievedRPSThreshold(0.8),
+			},
+		})
+		require.NoError(t, err)
+		_, failed := gen.Run(true)
+		require.Equal(t, false, failed)
+		for _, r := range gen.CheckThresholds() {
+			require.True(t, r.Passed, r.Threshold)
+		}
+	})
+	t.Run("generator thresholds fail", func(t *testing.T) {
+		t.Parallel()
+		gen, err := NewGenerator(&Config{
+			LoadType: RPS,
+			GenName:  "failing",
+			Schedule: Plain(10, 1*time.Second),
+			Gun: NewMockGun(&MockGunConfig{
+				FailRatio: 100,
+				CallSleep: 50 * time.Millisecond,
+			}),
+			Thresholds: []*Threshold{
+				LatencyThreshold(99, 10*time.Millisecond),
+				ErrorRateThreshold(0.001),
+				GroupLatencyThreshold("auth", 99, 200*time.Millisecond),
+				TimeoutRateThreshold(0.01),
+			},
+		})
+		require.NoError(t, err)
+		gen.Run(true)
+		results := gen.CheckThresholds()
+		require.Len(t, results, 4)
+		require.False(t, results[0].Passed)
+		require.Equal(t, "p99 < 10ms", results[0].Threshold)
+		require.False(t, results[1].Passed)
+		require.Equal(t, "100.000%", results[1].Actual)
+		require.False(t, results[2].Passed)
+		require.Equal(t, "p99 < 200ms for group auth", results[2].Threshold)
+		require.Equal(t, "no calls in group", results[2].Actual)
+		require.True(t, results[3].Passed)
+
+		rec := &failRecorder{}
+		require.False(t, reportThresholdsTo(rec, zerolog.Nop(), results))
+		require.Len(t, rec.msgs, 1)
+		require.Contains(t, rec.msgs[0], "3 of 4 thresholds failed")
+		require.Contains(t, rec.msgs[0], "error rate < 0.1%")
+		require.Contains(t, rec.msgs[0], "FAIL")
+		require.Contains(t, rec.msgs[0], "PASS")
+	})
+	t.Run("profile thresholds use merged results", func(t *testing.T) {
+		t.Parallel()
+		// nil T, failed thresholds are only logged
+		p := NewProfile().WithThresholds(nil,
+			GroupLatencyThreshold("fast", 99, 15*time.Millisecond),
+			GroupLatencyThreshold("slow", 50, 15*time.Millisecond),
+			AchievedRPSThreshold(0.8),
+		)
+		for i := 0; i < 2; i++ {
+			p.Add(NewGenerator(&Config{
+				T:        t,
+				GenName:  fmt.Sprintf("vu_%d", i),
+				LoadType: VU,
+				Schedule: Plain(1, 1*time.Second),
+				VU:       &groupVU{VUControl: NewVUControl()},
+			}))
+		}
+		_, err := p.Run(true)
+		require.NoError(t, err)
+		results := p.CheckThresholds()
+		require.True(t, results[0].Passed)
+		require.False(t, results[1].Passed)
+		require.Equal(t, "no RPS schedule", results[2].Actual)
+		require.Equal(t, "profile_"+p.ProfileID, results[0].Name)
+	})
+	t.Run("validation", func(t *testing.T) {
+		t.Parallel()
+		for _, th := range []*Threshold{
+			LatencyThreshold(0, 1*time.Second),
+			LatencyThreshold(99, 0),
+			ErrorRateThreshold(2),
+			{Metric: ThresholdErrorRate, Group: "auth", MaxRate: 0.1},
+			AchievedRPSThreshold(0),
+			{Metric: "apdex"},
+		} {
+			require.ErrorIs(t, th.Validate(), ErrInvalidThreshold)
+		}
+		_, err := NewGenerator(&Config{
+			T:          t,
+			LoadType:   RPS,
+			Schedule:   Plain(1, 1*time.Second),
+			Gun:        NewMockGun(&MockGunConfig{}),
+			Thresholds: []*Threshold{ErrorRateThreshold(-1)},
+		})
+		require.ErrorIs(t, err, ErrInvalidThreshold)
+		_, err = NewProfile().WithThresholds(t, TimeoutRateThreshold(0)).Run(true)
+		require.ErrorIs(t, err, ErrInvalidThreshold)
+	})
+}
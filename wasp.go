@@ -13,7 +13,7 @@ import (
 	"github.com/prometheus/common/model"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"go.uber.org/ratelimit"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -23,7 +23,9 @@ const (
 	DefaultStatsPollInterval     = 5 * time.Second
 	DefaultRateLimitUnitDuration = 1 * time.Second
 	DefaultCallResultBufLen      = 50000
+	DefaultRampTickInterval      = 1 * time.Second
 	DefaultGenName               = "Generator"
+	zeroRateCheckInterval        = 10 * time.Millisecond
 )
 
 var (
@@ -32,15 +34,23 @@ var (
 	ErrNoSchedule             = errors.New("no schedule segments were provided")
 	ErrInvalidScheduleType    = errors.New("schedule type must be either of wasp.RPS, wasp.VU, use package constants")
 	ErrCallTimeout            = errors.New("generator request call timeout")
+	ErrCallCancelled          = errors.New("generator request call cancelled")
 	ErrSetupTimeout           = errors.New("generator request setup timeout")
 	ErrSetup                  = errors.New("generator request setup error")
 	ErrTeardownTimeout        = errors.New("generator request teardown timeout")
 	ErrTeardown               = errors.New("generator request teardown error")
 	ErrStartFrom              = errors.New("from must be > 0")
 	ErrInvalidSegmentDuration = errors.New("SegmentDuration must be defined")
+	ErrInvalidSegmentTo       = errors.New("to must be >= 0")
+	ErrInvalidRandomWalk      = errors.New("random walk deviation and step must be >= 0 and rnd must be set")
+	ErrTooManySegments        = errors.New("schedule has more than MaxScheduleSegments segments")
 	ErrNoGun                  = errors.New("rps load scheduleSegments selected but gun implementation is nil")
 	ErrNoVU                   = errors.New("vu load scheduleSegments selected but vu implementation is nil")
 	ErrInvalidLabels          = errors.New("invalid Loki labels, labels should be [a-z][A-Z][0-9] and _")
+	ErrInvalidArrival         = errors.New("arrival process must be either of wasp.ArrivalConstant, wasp.ArrivalPoisson, wasp.ArrivalCustom")
+	ErrNoInterArrival         = errors.New("custom arrival process selected but InterArrival func is nil")
+	ErrInvalidMaxInFlight     = errors.New("MaxInFlight must be >= 0")
+	ErrScheduleFinished       = errors.New("generator schedule has already finished")
 )
 
 // Gun is basic interface for some synthetic load test implementation
@@ -62,6 +72,70 @@ type VirtualUser interface {
 	StopChan() chan struct{}
 }
 
+// GunCtx is a Gun that receives a call context, set Config.GunCtx to use it instead of Config.Gun
+// context is cancelled on CallTimeout, Generator.Stop() or when the schedule ends
+// return nil if the call was cancelled by the generator and the result should be discarded
+type GunCtx interface {
+	Call(ctx context.Context, l *Generator) *Response
+}
+
+// VirtualUserCtx is a VirtualUser that receives a call context, set Config.VUCtx to use it instead of Config.VU
+// context is cancelled on CallTimeout, Generator.Stop(), VU stop or when the schedule ends
+type VirtualUserCtx interface {
+	Call(ctx context.Context, l *Generator)
+	Clone(l *Generator) VirtualUserCtx
+	Setup(l *Generator) error
+	Teardown(l *Generator) error
+	Stop(l *Generator)
+	StopChan() chan struct{}
+}
+
+// AdaptGun wraps Gun to be used as GunCtx, wrapped Gun ignores the context
+func AdaptGun(gun Gun) GunCtx {
+	return &gunAdapter{gun: gun}
+}
+
+type gunAdapter struct {
+	gun Gun
+}
+
+func (m *gunAdapter) Call(_ context.Context, l *Generator) *Response {
+	return m.gun.Call(l)
+}
+
+// AdaptVirtualUser wraps VirtualUser to be used as VirtualUserCtx, wrapped VirtualUser ignores the context
+func AdaptVirtualUser(vu VirtualUser) VirtualUserCtx {
+	return &vuAdapter{vu: vu}
+}
+
+type vuAdapter struct {
+	vu VirtualUser
+}
+
+func (m *vuAdapter) Call(_ context.Context, l *Generator) {
+	m.vu.Call(l)
+}
+
+func (m *vuAdapter) Clone(l *Generator) VirtualUserCtx {
+	return &vuAdapter{vu: m.vu.Clone(l)}
+}
+
+func (m *vuAdapter) Setup(l *Generator) error {
+	return m.vu.Setup(l)
+}
+
+func (m *vuAdapter) Teardown(l *Generator) error {
+	return m.vu.Teardown(l)
+}
+
+func (m *vuAdapter) Stop(l *Generator) {
+	m.vu.Stop(l)
+}
+
+func (m *vuAdapter) StopChan() chan struct{} {
+	return m.vu.StopChan()
+}
+
 // NewVUControl creates new base VU that allows us to control the schedule and bring VUs up and down
 func NewVUControl() *VUControl {
 	return &VUControl{stop: make(chan struct{}, 1)}
@@ -94,6 +168,26 @@ type Response struct {
 	Group      string        `json:"group"`
 	Data       interface{}   `json:"data,omitempty"`
 	Error      string        `json:"error,omitempty"`
+	// Segment is 1-based index of the schedule segment the call was made in, it's set by the generator
+	Segment int `json:"segment,omitempty"`
+	// IntendedAt is when the RPS schedule intended to send the call, it's set by the generator
+	IntendedAt *time.Time `json:"intended_at,omitempty"`
+	// CorrectedDuration is a duration from IntendedAt, it includes the time the call waited to be sent
+	// when the generator fell behind the schedule (coordinated omission)
+	CorrectedDuration time.Duration `json:"corrected_duration,omitempty"`
+}
+
+// Corrected returns CorrectedDuration if the call was paced by the RPS schedule, Duration otherwise
+func (m *Response) Corrected() time.Duration {
+	if m.CorrectedDuration > 0 {
+		return m.CorrectedDuration
+	}
+	return m.Duration
+}
+
+// SendLateness returns how late the call was sent comparing to the RPS schedule
+func (m *Response) SendLateness() time.Duration {
+	return max(0, m.Corrected()-m.Duration)
 }
 
 type ScheduleType string
@@ -105,7 +199,11 @@ const (
 
 // Segment load test schedule segment
 type Segment struct {
-	From     int64
+	From int64
+	// To is an optional target, when set RPS or VUs are changed linearly from From to To during the segment
+	To int64
+	// Ramp makes To a target even if it is 0, segments created with Ramp always have it
+	Ramp     bool
 	Duration time.Duration
 }
 
@@ -113,12 +211,34 @@ func (ls *Segment) Validate() error {
 	if ls.From <= 0 {
 		return ErrStartFrom
 	}
+	if ls.To < 0 {
+		return ErrInvalidSegmentTo
+	}
 	if ls.Duration == 0 {
 		return ErrInvalidSegmentDuration
 	}
 	return nil
 }
 
+// IsRamp returns true if segment changes RPS or VUs during its duration
+func (ls *Segment) IsRamp() bool {
+	return (ls.Ramp || ls.To != 0) && ls.To != ls.From
+}
+
+// ValueAt returns interpolated RPS or VUs value after some time since the segment has started
+func (ls *Segment) ValueAt(elapsed time.Duration) int64 {
+	if !ls.IsRamp() {
+		return ls.From
+	}
+	if elapsed >= ls.Duration {
+		return ls.To
+	}
+	if elapsed <= 0 {
+		return ls.From
+	}
+	return ls.From + int64(math.Round(float64(ls.To-ls.From)*float64(elapsed)/float64(ls.Duration)))
+}
+
 // Config is for shared load test data and configuration
 type Config struct {
 	T                     *testing.T
@@ -126,19 +246,35 @@ type Config struct {
 	LoadType              ScheduleType
 	Labels                map[string]string
 	LokiConfig            *LokiConfig
+	Sinks                 []*SinkConfig
 	Schedule              []*Segment
 	RateLimitUnitDuration time.Duration
+	Arrival               ArrivalProcess
+	InterArrival          InterArrivalFunc
+	Replay                *ReplayConfig
 	CallResultBufLen      int
 	StatsPollInterval     time.Duration
 	CallTimeout           time.Duration
 	SetupTimeout          time.Duration
 	TeardownTimeout       time.Duration
 	FailOnErr             bool
+	MaxInFlight           int
+	ControlServerAddr     string
+	Prometheus            *PrometheusConfig
+	ResponseStore         *ResponseStoreConfig
+	OTel                  *OTelConfig
+	ReportDir             string
 	Gun                   Gun
+	GunCtx                GunCtx
 	VU                    VirtualUser
+	VUCtx                 VirtualUserCtx
 	Logger                zerolog.Logger
 	SharedData            interface{}
 	SamplerConfig         *SamplerConfig
+	Sampler               Sampler
+	Percentiles           []float64
+	Thresholds            []*Threshold
+	AbortRules            []*AbortRule
 	// calculated fields
 	duration time.Duration
 	// only available in cluster mode
@@ -164,23 +300,84 @@ func (lgc *Config) Validate() error {
 	if lgc.GenName == "" {
 		lgc.GenName = DefaultGenName
 	}
-	if lgc.Gun == nil && lgc.VU == nil {
+	if lgc.RateLimitUnitDuration == 0 {
+		lgc.RateLimitUnitDuration = DefaultRateLimitUnitDuration
+	}
+	if lgc.Gun == nil && lgc.VU == nil && lgc.GunCtx == nil && lgc.VUCtx == nil {
 		return ErrNoImpl
 	}
+	if lgc.Replay != nil {
+		if lgc.LoadType != RPS {
+			return ErrReplayLoadType
+		}
+		if err := lgc.Replay.Validate(); err != nil {
+			return err
+		}
+		if lgc.Schedule == nil {
+			lgc.Schedule = lgc.Replay.Schedule(lgc.RateLimitUnitDuration)
+		}
+	}
 	if lgc.Schedule == nil {
 		return ErrNoSchedule
 	}
 	if lgc.LoadType != RPS && lgc.LoadType != VU {
 		return ErrInvalidScheduleType
 	}
-	if lgc.LoadType == RPS && lgc.Gun == nil {
+	if lgc.LoadType == RPS && lgc.Gun == nil && lgc.GunCtx == nil {
 		return ErrNoGun
 	}
-	if lgc.LoadType == VU && lgc.VU == nil {
+	if lgc.LoadType == VU && lgc.VU == nil && lgc.VUCtx == nil {
 		return ErrNoVU
 	}
-	if lgc.RateLimitUnitDuration == 0 {
-		lgc.RateLimitUnitDuration = DefaultRateLimitUnitDuration
+	for _, s := range lgc.Sinks {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+	}
+	if lgc.Prometheus != nil {
+		if err := lgc.Prometheus.Validate(); err != nil {
+			return err
+		}
+	}
+	if lgc.OTel != nil {
+		if err := lgc.OTel.Validate(); err != nil {
+			return err
+		}
+	}
+	if lgc.MaxInFlight < 0 {
+		return ErrInvalidMaxInFlight
+	}
+	if lgc.SamplerConfig != nil {
+		if err := lgc.SamplerConfig.Validate(); err != nil {
+			return err
+		}
+	}
+	if lgc.Arrival == "" {
+		lgc.Arrival = ArrivalConstant
+	}
+	if lgc.Arrival != ArrivalConstant && lgc.Arrival != ArrivalPoisson && lgc.Arrival != ArrivalCustom {
+		return ErrInvalidArrival
+	}
+	if lgc.Arrival == ArrivalCustom && lgc.InterArrival == nil {
+		return ErrNoInterArrival
+	}
+	if lgc.Percentiles == nil {
+		lgc.Percentiles = DefaultPercentiles
+	}
+	for _, p := range lgc.Percentiles {
+		if p <= 0 || p > 100 {
+			return ErrInvalidPercentile
+		}
+	}
+	for _, t := range lgc.Thresholds {
+		if err := t.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, r := range lgc.AbortRules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -201,47 +398,85 @@ type Stats struct {
 	Success         atomic.Int64 `json:"success"`
 	Failed          atomic.Int64 `json:"failed"`
 	CallTimeout     atomic.Int64 `json:"callTimeout"`
+	Dropped         atomic.Int64 `json:"dropped"`
 	Duration        int64        `json:"load_duration"`
+	Aborted         atomic.Bool  `json:"aborted"`
+	abortReason     atomic.Pointer[string]
+	// Latencies are HDR histograms of all the calls, recorded before sampling
+	Latencies *Latencies `json:"-"`
 }
 
 // ResponseData includes any request/response data that a gun might store
-// ok* slices usually contains successful responses and their verifications if their done async
-// fail* slices contains CallResult with response data and an error
+// ok* buffers usually contains successful responses and their verifications if their done async
+// fail* buffers contains CallResult with response data and an error
+// it is a snapshot of recorded responses, buffers return them from the oldest to the newest, see Generator.GetData
 type ResponseData struct {
-	okDataMu        *sync.Mutex
-	OKData          *SliceBuffer[any]
-	okResponsesMu   *sync.Mutex
-	OKResponses     *SliceBuffer[*Response]
-	failResponsesMu *sync.Mutex
-	FailResponses   *SliceBuffer[*Response]
+	okData        *SliceBuffer[any]
+	okResponses   *SliceBuffer[*Response]
+	failResponses *SliceBuffer[*Response]
+}
+
+// OKData returns Response.Data of successful responses
+func (m *ResponseData) OKData() *SliceBuffer[any] {
+	return m.okData
+}
+
+// OKResponses returns successful responses
+func (m *ResponseData) OKResponses() *SliceBuffer[*Response] {
+	return m.okResponses
+}
+
+// FailResponses returns failed and timed out responses
+func (m *ResponseData) FailResponses() *SliceBuffer[*Response] {
+	return m.failResponses
 }
 
 // Generator generates load with some RPS
 type Generator struct {
 	Cfg                *Config
-	sampler            *Sampler
+	sampler            Sampler
 	Log                zerolog.Logger
 	labels             model.LabelSet
-	rl                 atomic.Pointer[ratelimit.Limiter]
+	rl                 atomic.Pointer[intendedLimiter]
+	replay             *replayLimiter
+	interArrival       InterArrivalFunc
+	inFlight           chan struct{}
+	scheduleMu         *sync.Mutex
 	scheduleSegments   []*Segment
+	scheduleDone       bool
 	currentSegment     *Segment
+	segmentRuns        []*segmentRun
+	currentSegmentRun  atomic.Pointer[segmentRun]
+	reportOnce         *sync.Once
+	thresholdsOnce     *sync.Once
+	abortWindows       []*slidingWindow
+	onProfileAbort     func(reason string)
+	targetMu           *sync.Mutex
+	targetOverridden   bool
+	control            *ControlServer
+	prom               *PrometheusExporter
+	promExporters      []*PrometheusExporter
+	otel               *otelExporter
+	otelOnce           *sync.Once
 	ResponsesWaitGroup *sync.WaitGroup
 	dataWaitGroup      *sync.WaitGroup
 	ResponsesCtx       context.Context
 	responsesCancel    context.CancelFunc
 	dataCtx            context.Context
 	dataCancel         context.CancelFunc
-	gun                Gun
-	vu                 VirtualUser
-	vus                []VirtualUser
+	gun                GunCtx
+	vu                 VirtualUserCtx
+	vus                []VirtualUserCtx
 	ResponsesChan      chan *Response
 	Responses          *Responses
-	responsesData      *ResponseData
-	errsMu             *sync.Mutex
-	errs               *SliceBuffer[string]
+	results            *resultAggregator
 	stats              *Stats
-	loki               *LokiClient
-	lokiResponsesChan  chan *Response
+	sinks              []*sinkRunner
+	loki               *LokiSink
+	store              *ResponseStore
+	sinksWaitGroup     *sync.WaitGroup
+	sinksDone          chan struct{}
+	sinksCloseOnce     *sync.Once
 }
 
 // NewGenerator creates a new generator,
@@ -278,46 +513,102 @@ func NewGenerator(cfg *Config) (*Generator, error) {
 		return nil, ErrInvalidLabels
 	}
 	cfg.nodeID = os.Getenv("WASP_NODE_ID")
-	// context for all requests/responses and vus
-	responsesCtx, responsesCancel := context.WithTimeout(context.Background(), cfg.duration)
+	// context for all requests/responses and vus, scheduler cancels it when all the segments are done
+	responsesCtx, responsesCancel := context.WithCancel(context.Background())
 	// context for all the collected data
 	dataCtx, dataCancel := context.WithCancel(context.Background())
 	rch := make(chan *Response)
+	sampler := cfg.Sampler
+	if sampler == nil {
+		sampler = NewSampler(cfg.SamplerConfig)
+	}
 	g := &Generator{
 		Cfg:                cfg,
-		sampler:            NewSampler(cfg.SamplerConfig),
+		sampler:            sampler,
+		scheduleMu:         &sync.Mutex{},
 		scheduleSegments:   cfg.Schedule,
+		targetMu:           &sync.Mutex{},
+		reportOnce:         &sync.Once{},
+		thresholdsOnce:     &sync.Once{},
 		ResponsesWaitGroup: &sync.WaitGroup{},
 		dataWaitGroup:      &sync.WaitGroup{},
 		ResponsesCtx:       responsesCtx,
 		responsesCancel:    responsesCancel,
 		dataCtx:            dataCtx,
 		dataCancel:         dataCancel,
-		gun:                cfg.Gun,
-		vu:                 cfg.VU,
+		gun:                cfg.GunCtx,
+		vu:                 cfg.VUCtx,
 		Responses:          NewResponses(rch),
 		ResponsesChan:      rch,
 		labels:             ls,
-		responsesData: &ResponseData{
-			okDataMu:        &sync.Mutex{},
-			OKData:          NewSliceBuffer[any](cfg.CallResultBufLen),
-			okResponsesMu:   &sync.Mutex{},
-			OKResponses:     NewSliceBuffer[*Response](cfg.CallResultBufLen),
-			failResponsesMu: &sync.Mutex{},
-			FailResponses:   NewSliceBuffer[*Response](cfg.CallResultBufLen),
-		},
-		errsMu:            &sync.Mutex{},
-		errs:              NewSliceBuffer[string](cfg.CallResultBufLen),
-		stats:             &Stats{},
-		Log:               l,
-		lokiResponsesChan: make(chan *Response, 50000),
-	}
-	var err error
+		results:            newResultAggregator(cfg.CallResultBufLen, shardCount()),
+		stats:              &Stats{Latencies: NewLatencies()},
+		Log:                l,
+		sinksWaitGroup:     &sync.WaitGroup{},
+		sinksDone:          make(chan struct{}),
+		sinksCloseOnce:     &sync.Once{},
+		otelOnce:           &sync.Once{},
+	}
+	if g.gun == nil && cfg.Gun != nil {
+		g.gun = AdaptGun(cfg.Gun)
+	}
+	if g.vu == nil && cfg.VU != nil {
+		g.vu = AdaptVirtualUser(cfg.VU)
+	}
+	if cfg.Prometheus != nil {
+		prom, err := NewPrometheusExporter(cfg.Prometheus, g)
+		if err != nil {
+			return nil, err
+		}
+		g.prom = prom
+	}
+	if cfg.OTel != nil {
+		otel, err := newOTelExporter(cfg.OTel, g)
+		if err != nil {
+			return nil, err
+		}
+		g.otel = otel
+	}
+	for _, r := range cfg.AbortRules {
+		g.abortWindows = append(g.abortWindows, newSlidingWindow(r))
+	}
+	if cfg.Replay != nil {
+		g.replay = newReplayLimiter(responsesCtx, cfg.Replay.Offsets())
+	}
+	switch cfg.Arrival {
+	case ArrivalPoisson:
+		g.interArrival = ExponentialInterArrival(newRand(0))
+	case ArrivalCustom:
+		g.interArrival = cfg.InterArrival
+	}
 	if cfg.LokiConfig != nil {
-		g.loki, err = NewLokiClient(cfg.LokiConfig)
+		loki, err := NewLokiSink(cfg.LokiConfig)
+		if err != nil {
+			return nil, err
+		}
+		g.loki = loki
+		g.sinks = append(g.sinks, newSinkRunner(&SinkConfig{
+			Sink:         loki,
+			BufferSize:   DefaultSinkBufferSize,
+			Backpressure: BackpressureBlock,
+			StopOnError:  true,
+		}))
+	}
+	if cfg.ResponseStore != nil {
+		store, err := NewResponseStore(cfg.ResponseStore, cfg.GenName)
 		if err != nil {
 			return nil, err
 		}
+		g.store = store
+		g.sinks = append(g.sinks, newSinkRunner(&SinkConfig{
+			Sink:         store,
+			BufferSize:   DefaultSinkBufferSize,
+			Backpressure: BackpressureBlock,
+			StopOnError:  true,
+		}))
+	}
+	for _, s := range cfg.Sinks {
+		g.sinks = append(g.sinks, newSinkRunner(s))
 	}
 	CPUCheckLoop()
 	return g, nil
@@ -331,8 +622,11 @@ func (g *Generator) setupSchedule() {
 	case RPS:
 		g.ResponsesWaitGroup.Add(1)
 		g.stats.CurrentRPS.Store(g.currentSegment.From)
-		newRateLimit := ratelimit.New(int(g.currentSegment.From), ratelimit.Per(g.Cfg.RateLimitUnitDuration))
+		newRateLimit := g.newRateLimiter(g.currentSegment.From)
 		g.rl.Store(&newRateLimit)
+		if g.Cfg.MaxInFlight > 0 {
+			g.inFlight = make(chan struct{}, g.Cfg.MaxInFlight)
+		}
 		// we run pacedCall controlled by stats.CurrentRPS
 		go func() {
 			for {
@@ -359,7 +653,7 @@ func (g *Generator) setupSchedule() {
 }
 
 // runSetupWithTimeout runs setup with timeout
-func (g *Generator) runSetupWithTimeout(vu VirtualUser) bool {
+func (g *Generator) runSetupWithTimeout(vu VirtualUserCtx) bool {
 	startedAt := time.Now()
 	ctx, cancel := context.WithTimeout(context.Background(), g.Cfg.SetupTimeout)
 	defer cancel()
@@ -382,7 +676,7 @@ func (g *Generator) runSetupWithTimeout(vu VirtualUser) bool {
 }
 
 // runTeardownWithTimeout runs teardown with timeout
-func (g *Generator) runTeardownWithTimeout(vu VirtualUser) bool {
+func (g *Generator) runTeardownWithTimeout(vu VirtualUserCtx) bool {
 	startedAt := time.Now()
 	ctx, cancel := context.WithTimeout(context.Background(), g.Cfg.TeardownTimeout)
 	defer cancel()
@@ -405,7 +699,7 @@ func (g *Generator) runTeardownWithTimeout(vu VirtualUser) bool {
 }
 
 // runVU performs virtual user lifecycle
-func (g *Generator) runVU(vu VirtualUser) {
+func (g *Generator) runVU(vu VirtualUserCtx) {
 	g.ResponsesWaitGroup.Add(1)
 	go func() {
 		defer g.ResponsesWaitGroup.Done()
@@ -417,29 +711,35 @@ func (g *Generator) runVU(vu VirtualUser) {
 				continue
 			}
 			startedAt := time.Now()
-			ctx, cancel := context.WithTimeout(context.Background(), g.Cfg.CallTimeout)
-			vuChan := make(chan struct{})
+			ctx, cancel := context.WithTimeout(g.ResponsesCtx, g.Cfg.CallTimeout)
+			ctx, span := g.startCallSpan(ctx, "wasp.vu.call")
+			callTimeout := time.NewTimer(g.Cfg.CallTimeout)
+			vuChan := make(chan struct{}, 1)
 			go func() {
-				vu.Call(g)
-				select {
-				case <-ctx.Done():
-					return
-				default:
-					vuChan <- struct{}{}
-				}
+				vu.Call(ctx, g)
+				vuChan <- struct{}{}
 			}()
 			select {
 			case <-g.ResponsesCtx.Done():
+				callTimeout.Stop()
 				cancel()
+				g.endVUCallSpan(span, ErrCallCancelled)
 				return
 			case <-vu.StopChan():
-				g.runTeardownWithTimeout(vu)
+				callTimeout.Stop()
 				cancel()
+				g.endVUCallSpan(span, ErrCallCancelled)
+				g.runTeardownWithTimeout(vu)
 				return
-			case <-ctx.Done():
-				g.ResponsesChan <- &Response{StartedAt: &startedAt, Error: ErrCallTimeout.Error(), Timeout: true}
+			case <-callTimeout.C:
 				cancel()
+				res := &Response{StartedAt: &startedAt, Error: ErrCallTimeout.Error(), Timeout: true}
+				g.endCallSpan(span, res)
+				g.ResponsesChan <- res
 			case <-vuChan:
+				callTimeout.Stop()
+				cancel()
+				g.endVUCallSpan(span, nil)
 			}
 		}
 	}()
@@ -455,27 +755,47 @@ func (g *Generator) processSegment() bool {
 			Int64("RPS", g.stats.CurrentRPS.Load()).
 			Msg("Schedule segment")
 	}()
+	g.scheduleMu.Lock()
+	defer g.scheduleMu.Unlock()
+	now := time.Now()
+	if sr := g.currentSegmentRun.Load(); sr != nil {
+		sr.finishedAt = now
+	}
 	if g.stats.CurrentSegment.Load() == g.stats.LastSegment.Load() {
+		g.scheduleDone = true
 		return true
 	}
 	g.currentSegment = g.scheduleSegments[g.stats.CurrentSegment.Load()]
+	sr := &segmentRun{index: len(g.segmentRuns) + 1, segment: g.currentSegment, startedAt: now}
+	g.segmentRuns = append(g.segmentRuns, sr)
+	g.currentSegmentRun.Store(sr)
 	g.stats.CurrentSegment.Add(1)
+	g.targetOverridden = false
+	g.applyTarget(g.currentSegment.From)
+	return false
+}
+
+// applyTarget changes RPS or VUs to a new target value
+// changing both internal and Stats values to report
+func (g *Generator) applyTarget(target int64) {
+	g.targetMu.Lock()
+	defer g.targetMu.Unlock()
 	switch g.Cfg.LoadType {
 	case RPS:
-		newRateLimit := ratelimit.New(int(g.currentSegment.From), ratelimit.Per(g.Cfg.RateLimitUnitDuration))
+		newRateLimit := g.newRateLimiter(target)
 		g.rl.Store(&newRateLimit)
-		g.stats.CurrentRPS.Store(g.currentSegment.From)
+		g.stats.CurrentRPS.Store(target)
 	case VU:
 		oldVUs := g.stats.CurrentVUs.Load()
-		newVUs := g.currentSegment.From
+		newVUs := target
 		g.stats.CurrentVUs.Store(newVUs)
 
-		vusToSpawn := int(math.Abs(float64(max(oldVUs, g.currentSegment.From) - min(oldVUs, g.currentSegment.From))))
+		vusToSpawn := int(math.Abs(float64(max(oldVUs, newVUs) - min(oldVUs, newVUs))))
 		log.Debug().Int64("OldVUs", oldVUs).Int64("NewVUs", newVUs).Int("VUsDelta", vusToSpawn).Msg("Changing VUs")
 		if oldVUs == newVUs {
-			return false
+			return
 		}
-		if oldVUs > g.currentSegment.From {
+		if oldVUs > newVUs {
 			for i := 0; i < vusToSpawn; i++ {
 				g.vus[i].Stop(g)
 			}
@@ -488,7 +808,47 @@ func (g *Generator) processSegment() bool {
 			}
 		}
 	}
-	return false
+}
+
+// currentTarget returns current RPS or VUs value
+func (g *Generator) currentTarget() int64 {
+	if g.Cfg.LoadType == VU {
+		return g.stats.CurrentVUs.Load()
+	}
+	return g.stats.CurrentRPS.Load()
+}
+
+// holdSegment waits until the segment ends, ramp segments are interpolated from Segment.From to Segment.To
+// until the target is overridden with SetTarget
+func (g *Generator) holdSegment(s *Segment) {
+	if !s.IsRamp() {
+		select {
+		case <-g.ResponsesCtx.Done():
+		case <-time.After(s.Duration):
+		}
+		return
+	}
+	tick := s.Duration / time.Duration(math.Abs(float64(s.To-s.From)))
+	if tick < DefaultRampTickInterval {
+		tick = DefaultRampTickInterval
+	}
+	startedAt := time.Now()
+	for {
+		left := s.Duration - time.Since(startedAt)
+		if left <= 0 {
+			return
+		}
+		select {
+		case <-g.ResponsesCtx.Done():
+			return
+		case <-time.After(min(tick, left)):
+			g.scheduleMu.Lock()
+			if v := s.ValueAt(time.Since(startedAt)); !g.targetOverridden && v != g.currentTarget() {
+				g.applyTarget(v)
+			}
+			g.scheduleMu.Unlock()
+		}
+	}
 }
 
 // runSchedule runs scheduling loop
@@ -507,9 +867,10 @@ func (g *Generator) runSchedule() {
 				return
 			default:
 				if g.processSegment() {
-					return
+					g.responsesCancel()
+					continue
 				}
-				time.Sleep(g.currentSegment.Duration)
+				g.holdSegment(g.currentSegment)
 			}
 		}
 	}()
@@ -520,36 +881,63 @@ func (g *Generator) storeResponses(res *Response) {
 	if g.Cfg.CallTimeout > 0 && res.Duration > g.Cfg.CallTimeout && !res.Timeout {
 		return
 	}
-	if !g.sampler.ShouldRecord(res, g.stats) {
+	// histograms and segment counters must see all the calls, even if samples are skipped
+	g.stats.Latencies.Record(res)
+	g.recordAbortWindows(res)
+	g.observePrometheus(res)
+	if sr := g.currentSegmentRun.Load(); sr != nil {
+		sr.requests.Add(1)
+		res.Segment = sr.index
+	}
+	if !g.shouldRecord(res) {
 		return
 	}
-	if g.Cfg.LokiConfig != nil {
-		g.lokiResponsesChan <- res
+	g.recordResponse(res)
+}
+
+// shouldRecord samples a response, failed and timed out responses are always recorded
+func (g *Generator) shouldRecord(res *Response) bool {
+	if res.Error != "" || res.Failed || res.Timeout {
+		g.stats.SamplesRecorded.Add(1)
+		return true
 	}
-	g.responsesData.okDataMu.Lock()
-	g.responsesData.failResponsesMu.Lock()
-	g.errsMu.Lock()
+	switch g.sampler.Sample(res) {
+	case SampleRecord:
+		g.stats.SamplesRecorded.Add(1)
+		return true
+	case SampleSkip:
+		g.stats.SamplesSkipped.Add(1)
+	}
+	g.releaseSamples(false)
+	return false
+}
+
+// releaseSamples records responses released by a HoldingSampler, final releases all the held responses
+func (g *Generator) releaseSamples(final bool) {
+	released, skipped := releaseHeld(g.sampler, final)
+	g.stats.SamplesSkipped.Add(skipped)
+	for _, res := range released {
+		g.stats.SamplesRecorded.Add(1)
+		g.recordResponse(res)
+	}
+}
+
+// recordResponse pushes a sampled response to sinks and stores it in generator data
+func (g *Generator) recordResponse(res *Response) {
+	g.pushToSinks(res)
+	g.results.record(res)
 	if res.Failed {
 		g.stats.RunFailed.Store(true)
 		g.stats.Failed.Add(1)
-		g.errs.Append(res.Error)
-		g.responsesData.FailResponses.Append(res)
 		g.Log.Error().Str("Err", res.Error).Msg("load generator request failed")
 	} else if res.Timeout {
 		g.stats.RunFailed.Store(true)
 		g.stats.CallTimeout.Add(1)
 		g.stats.Failed.Add(1)
-		g.errs.Append(res.Error)
-		g.responsesData.FailResponses.Append(res)
 		g.Log.Error().Str("Err", res.Error).Msg("load generator request timed out")
 	} else {
 		g.stats.Success.Add(1)
-		g.responsesData.OKData.Append(res.Data)
-		g.responsesData.OKResponses.Append(res)
 	}
-	g.responsesData.okDataMu.Unlock()
-	g.responsesData.failResponsesMu.Unlock()
-	g.errsMu.Unlock()
 	if (g.stats.Failed.Load() > 0 || g.stats.CallTimeout.Load() > 0) && g.Cfg.FailOnErr {
 		g.Log.Warn().Msg("Generator has stopped on first error")
 		g.responsesCancel()
@@ -586,43 +974,151 @@ func (g *Generator) pacedCall() {
 	if g.stats.RunPaused.Load() || g.stats.RunStopped.Load() {
 		return
 	}
+	// ramp can go down to zero RPS, calls are not sent until the rate is changed
+	if g.replay == nil && g.stats.CurrentRPS.Load() == 0 {
+		select {
+		case <-g.ResponsesCtx.Done():
+		case <-time.After(zeroRateCheckInterval):
+		}
+		return
+	}
 	l := *g.rl.Load()
-	l.Take()
-	result := make(chan *Response)
-	requestCtx, cancel := context.WithTimeout(context.Background(), g.Cfg.CallTimeout)
-	callStartTS := time.Now()
-	go func() {
-		result <- g.gun.Call(g)
-	}()
+	intended := l.TakeIntended()
+	// replay limiter unblocks when the schedule ends, the trace is over by then
+	if g.replay != nil && g.ResponsesCtx.Err() != nil {
+		return
+	}
+	if g.inFlight == nil {
+		g.ResponsesWaitGroup.Add(1)
+		go func() {
+			defer g.ResponsesWaitGroup.Done()
+			g.callGun(intended)
+		}()
+		return
+	}
+	// the call is dropped if MaxInFlight calls are running
+	select {
+	case g.inFlight <- struct{}{}:
+	default:
+		g.stats.Dropped.Add(1)
+		return
+	}
 	g.ResponsesWaitGroup.Add(1)
 	go func() {
 		defer g.ResponsesWaitGroup.Done()
+		defer func() { <-g.inFlight }()
+		pending := g.callGun(intended)
+		if pending == nil {
+			return
+		}
+		// timed out call is still in flight until the gun returns
 		select {
-		case <-requestCtx.Done():
-			ts := time.Now()
-			cr := &Response{Duration: time.Since(callStartTS), FinishedAt: &ts, Timeout: true, Error: ErrCallTimeout.Error()}
-			g.storeResponses(cr)
-		case res := <-result:
-			defer close(result)
-			res.Duration = time.Since(callStartTS)
-			ts := time.Now()
-			res.FinishedAt = &ts
-			g.storeResponses(res)
-		}
-		cancel()
+		case <-pending:
+		case <-g.ResponsesCtx.Done():
+		}
+	}()
+}
+
+// callGun calls a gun and stores the result or a timeout, intended is the call time by the schedule,
+// returns the result channel if the call timed out and the gun has not returned yet
+func (g *Generator) callGun(intended time.Time) chan *Response {
+	result := make(chan *Response, 1)
+	// request context is cancelled on timeout, Stop() or when the schedule ends
+	requestCtx, cancel := context.WithTimeout(g.ResponsesCtx, g.Cfg.CallTimeout)
+	defer cancel()
+	requestCtx, span := g.startCallSpan(requestCtx, "wasp.call")
+	callTimeout := time.NewTimer(g.Cfg.CallTimeout)
+	defer callTimeout.Stop()
+	callStartTS := time.Now()
+	go func() {
+		result <- g.gun.Call(requestCtx, g)
 	}()
+	select {
+	case <-callTimeout.C:
+		g.endCallSpan(span, g.storeCallTimeout(callStartTS, intended))
+		return result
+	case res := <-result:
+		if requestCtx.Err() != nil && g.ResponsesCtx.Err() == nil {
+			// the call was cancelled by its own timeout
+			g.endCallSpan(span, g.storeCallTimeout(callStartTS, intended))
+			return nil
+		}
+		if res == nil {
+			g.endCallSpan(span, nil)
+			return nil
+		}
+		ts := time.Now()
+		res.Duration = ts.Sub(callStartTS)
+		res.FinishedAt = &ts
+		setIntended(res, intended)
+		g.endCallSpan(span, res)
+		g.storeResponses(res)
+		return nil
+	}
+}
+
+// storeCallTimeout stores a timed out Gun call
+func (g *Generator) storeCallTimeout(callStartTS, intended time.Time) *Response {
+	ts := time.Now()
+	cr := &Response{Duration: ts.Sub(callStartTS), FinishedAt: &ts, Timeout: true, Error: ErrCallTimeout.Error()}
+	setIntended(cr, intended)
+	g.storeResponses(cr)
+	return cr
+}
+
+// setIntended sets the intended send time and the duration corrected for coordinated omission
+func setIntended(res *Response, intended time.Time) {
+	res.IntendedAt = &intended
+	res.CorrectedDuration = max(res.Duration, res.FinishedAt.Sub(intended))
+}
+
+// startCallSpan starts a span of a call if OTel is on, the span is available to the call through its context
+func (g *Generator) startCallSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	if g.otel == nil {
+		return ctx, nil
+	}
+	return g.otel.startCall(ctx, name)
+}
+
+// endCallSpan ends a span of a call if OTel is on
+func (g *Generator) endCallSpan(span trace.Span, res *Response) {
+	if span == nil {
+		return
+	}
+	g.otel.endCall(span, res)
+}
+
+// endVUCallSpan ends a span of a VU call with the call outcome if OTel is on, VUs send their responses themselves
+func (g *Generator) endVUCallSpan(span trace.Span, err error) {
+	if span == nil {
+		return
+	}
+	res := &Response{}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	g.otel.endCall(span, res)
 }
 
 // Run runs load loop until timeout or stop
 func (g *Generator) Run(wait bool) (interface{}, bool) {
 	g.Log.Info().Msg("Load generator started")
-	g.printStatsLoop()
-	if g.Cfg.LokiConfig != nil {
-		g.sendResponsesToLoki()
-		g.sendStatsToLoki()
+	if g.Cfg.ControlServerAddr != "" {
+		g.control = NewControlServer(g.Cfg.ControlServerAddr, g)
+		if err := g.control.Start(); err != nil {
+			g.Log.Err(err).Msg("Failed to start control server")
+		}
+	}
+	if g.prom != nil {
+		if err := g.prom.Start(); err != nil {
+			g.Log.Err(err).Msg("Failed to start prometheus exporter")
+		}
 	}
+	g.printStatsLoop()
+	g.startSinks()
 	g.setupSchedule()
 	g.collectVUResults()
+	g.runAbortRules()
 	g.runSchedule()
 	if wait {
 		return g.Wait()
@@ -633,15 +1129,58 @@ func (g *Generator) Run(wait bool) (interface{}, bool) {
 // Pause pauses execution of a generator
 func (g *Generator) Pause() {
 	g.Log.Warn().Msg("Generator was paused")
+	if rl := g.rl.Load(); rl != nil {
+		(*rl).pause()
+	}
 	g.stats.RunPaused.Store(true)
 }
 
 // Resume resumes execution of a generator
 func (g *Generator) Resume() {
 	g.Log.Warn().Msg("Generator was resumed")
+	if rl := g.rl.Load(); rl != nil {
+		(*rl).resume()
+	}
 	g.stats.RunPaused.Store(false)
 }
 
+// SetTarget overrides current RPS or VUs until the next schedule segment starts
+func (g *Generator) SetTarget(target int64) error {
+	if target <= 0 {
+		return ErrStartFrom
+	}
+	g.scheduleMu.Lock()
+	defer g.scheduleMu.Unlock()
+	if g.scheduleDone || g.stats.RunStopped.Load() {
+		return ErrScheduleFinished
+	}
+	g.Log.Warn().Int64("Target", target).Msg("Generator target was overridden")
+	g.targetOverridden = true
+	g.applyTarget(target)
+	return nil
+}
+
+// AppendSegments adds segments to the end of a running schedule
+func (g *Generator) AppendSegments(segs ...*Segment) error {
+	for _, s := range segs {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+	}
+	g.scheduleMu.Lock()
+	defer g.scheduleMu.Unlock()
+	if g.scheduleDone || g.stats.RunStopped.Load() {
+		return ErrScheduleFinished
+	}
+	g.scheduleSegments = append(g.scheduleSegments[:len(g.scheduleSegments):len(g.scheduleSegments)], segs...)
+	for _, s := range segs {
+		g.Cfg.duration += s.Duration
+	}
+	g.stats.LastSegment.Store(int64(len(g.scheduleSegments)))
+	g.Log.Warn().Int("Segments", len(segs)).Msg("Schedule segments were appended")
+	return nil
+}
+
 // Stop stops load generator, waiting for all calls for either finish or timeout
 // this method is external so Gun/VU implementations can stop the generator
 func (g *Generator) Stop() (interface{}, bool) {
@@ -659,12 +1198,39 @@ func (g *Generator) Stop() (interface{}, bool) {
 func (g *Generator) Wait() (interface{}, bool) {
 	g.Log.Info().Msg("Waiting for all responses to finish")
 	g.ResponsesWaitGroup.Wait()
+	g.scheduleMu.Lock()
 	g.stats.Duration = g.Cfg.duration.Nanoseconds()
 	g.stats.CurrentTimeUnit = g.Cfg.RateLimitUnitDuration.Nanoseconds()
-	if g.Cfg.LokiConfig != nil {
-		g.dataCancel()
-		g.dataWaitGroup.Wait()
-		g.stopLokiStream()
+	// generator was stopped in the middle of a segment
+	if sr := g.currentSegmentRun.Load(); sr != nil && sr.finishedAt.IsZero() {
+		sr.finishedAt = time.Now()
+	}
+	g.scheduleMu.Unlock()
+	g.closeSinks()
+	if g.otel != nil {
+		g.otelOnce.Do(func() {
+			if err := g.otel.Shutdown(); err != nil {
+				g.Log.Err(err).Msg("Failed to flush OTel spans and metrics")
+			}
+		})
+	}
+	if g.control != nil {
+		g.control.Stop()
+	}
+	if g.prom != nil {
+		g.prom.Stop()
+	}
+	if len(g.Cfg.Thresholds) > 0 {
+		g.thresholdsOnce.Do(func() {
+			reportThresholds(g.Cfg.T, g.Log, g.CheckThresholds())
+		})
+	}
+	if g.Cfg.ReportDir != "" {
+		g.reportOnce.Do(func() {
+			if err := NewReport(g.Cfg.GenName, g).WriteFiles(g.Cfg.ReportDir); err != nil {
+				g.Log.Err(err).Msg("Failed to write report")
+			}
+		})
 	}
 	return g.GetData(), g.stats.RunFailed.Load()
 }
@@ -674,14 +1240,19 @@ func (g *Generator) InputSharedData() interface{} {
 	return g.Cfg.SharedData
 }
 
-// Errors get all calls errors
+// Errors get all calls errors, merged from all the shards in the recording order
 func (g *Generator) Errors() []string {
-	return g.errs.Data
+	return g.results.errors()
 }
 
-// GetData get all calls data
+// GetData get all calls data, merged from all the shards in the recording order
 func (g *Generator) GetData() *ResponseData {
-	return g.responsesData
+	return g.results.responseData()
+}
+
+// ResponseStore returns the disk store of all the recorded responses, nil if Config.ResponseStore is not set
+func (g *Generator) ResponseStore() *ResponseStore {
+	return g.store
 }
 
 // Stats get all load stats
@@ -689,92 +1260,12 @@ func (g *Generator) Stats() *Stats {
 	return g.stats
 }
 
-/* Loki's methods to handle CallResult/Stats and stream it to Loki */
-
-// stopLokiStream stops the Loki stream client
-func (g *Generator) stopLokiStream() {
-	if g.Cfg.LokiConfig != nil && g.Cfg.LokiConfig.URL != "" {
-		g.Log.Info().Msg("Stopping Loki")
-		g.loki.StopNow()
-		g.Log.Info().Msg("Loki exited")
-	}
-}
-
-// handleLokiResponsePayload handles CallResult payload with adding default labels
-// adding custom CallResult labels if present
-func (g *Generator) handleLokiResponsePayload(r *Response) {
-	labels := g.labels.Merge(model.LabelSet{
-		"test_data_type": "responses",
-		CallGroupLabel:   model.LabelValue(r.Group),
-	})
-	// we are removing time.Time{} because when it marshalled to string it creates N responses for some Loki queries
-	// and to minimize the payload, duration is already calculated at that point
-	ts := r.FinishedAt
-	r.StartedAt = nil
-	r.FinishedAt = nil
-	err := g.loki.HandleStruct(labels, *ts, r)
-	if err != nil {
-		g.Log.Err(err).Send()
-		g.Stop()
-	}
-}
-
-// handleLokiStatsPayload handles StatsJSON payload with adding default labels
-// this stream serves as a debug data and shouldn't be customized with additional labels
-func (g *Generator) handleLokiStatsPayload() {
-	ls := g.labels.Merge(model.LabelSet{
-		"test_data_type": "stats",
-	})
-	err := g.loki.HandleStruct(ls, time.Now(), g.StatsJSON())
-	if err != nil {
-		g.Log.Err(err).Send()
-		g.Stop()
-	}
-}
-
-// sendResponsesToLoki pushes responses to Loki
-func (g *Generator) sendResponsesToLoki() {
-	g.Log.Info().
-		Str("URL", g.Cfg.LokiConfig.URL).
-		Interface("DefaultLabels", g.Cfg.Labels).
-		Msg("Streaming data to Loki")
-	g.dataWaitGroup.Add(1)
-	go func() {
-		defer g.dataWaitGroup.Done()
-		for {
-			select {
-			case <-g.dataCtx.Done():
-				g.Log.Info().Msg("Loki responses exited")
-				return
-			case r := <-g.lokiResponsesChan:
-				g.handleLokiResponsePayload(r)
-			}
-		}
-	}()
-}
-
-// sendStatsToLoki pushes stats to Loki
-func (g *Generator) sendStatsToLoki() {
-	g.dataWaitGroup.Add(1)
-	go func() {
-		defer g.dataWaitGroup.Done()
-		for {
-			select {
-			case <-g.dataCtx.Done():
-				g.Log.Info().Msg("Loki stats exited")
-				return
-			default:
-				time.Sleep(g.Cfg.StatsPollInterval)
-				g.handleLokiStatsPayload()
-			}
-		}
-	}()
-}
-
 /* Local logging methods */
 
 // StatsJSON get all load stats for export
 func (g *Generator) StatsJSON() map[string]interface{} {
+	g.scheduleMu.Lock()
+	defer g.scheduleMu.Unlock()
 	return map[string]interface{}{
 		"node_id":           g.Cfg.nodeID,
 		"current_rps":       g.stats.CurrentRPS.Load(),
@@ -786,8 +1277,15 @@ func (g *Generator) StatsJSON() map[string]interface{} {
 		"failed":            g.stats.Failed.Load(),
 		"success":           g.stats.Success.Load(),
 		"callTimeout":       g.stats.CallTimeout.Load(),
+		"dropped":           g.stats.Dropped.Load(),
 		"load_duration":     g.stats.Duration,
 		"current_time_unit": g.stats.CurrentTimeUnit,
+		"aborted":           g.stats.Aborted.Load(),
+		"abort_reason":      g.stats.AbortReason(),
+		"latency":           g.stats.Latencies.All.Summary(g.Cfg.Percentiles),
+		"latency_groups":    g.stats.Latencies.GroupsSummary(g.Cfg.Percentiles),
+		"latency_corrected": g.stats.Latencies.Corrected.Summary(g.Cfg.Percentiles),
+		"send_lateness":     g.stats.Latencies.SendLateness.Summary(g.Cfg.Percentiles),
 	}
 }
 
@@ -796,17 +1294,19 @@ func (g *Generator) printStatsLoop() {
 	g.ResponsesWaitGroup.Add(1)
 	go func() {
 		defer g.ResponsesWaitGroup.Done()
+		ticker := time.NewTicker(g.Cfg.StatsPollInterval)
+		defer ticker.Stop()
 		for {
 			select {
 			case <-g.ResponsesCtx.Done():
 				g.Log.Info().Msg("Stats loop exited")
 				return
-			default:
-				time.Sleep(g.Cfg.StatsPollInterval)
+			case <-ticker.C:
 				g.Log.Info().
 					Int64("Success", g.stats.Success.Load()).
 					Int64("Failed", g.stats.Failed.Load()).
 					Int64("CallTimeout", g.stats.CallTimeout.Load()).
+					Int64("Dropped", g.stats.Dropped.Load()).
 					Msg("Load stats")
 			}
 		}
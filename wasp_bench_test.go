@@ -1,9 +1,11 @@
 package wasp
 
 import (
+	"github.com/HdrHistogram/hdrhistogram-go"
 	"github.com/stretchr/testify/require"
 	"math"
 	"os"
+	"sync"
 	"testing"
 	"time"
 )
@@ -27,3 +29,209 @@ func BenchmarkPacedCall(b *testing.B) {
 		gen.pacedCall()
 	}
 }
+
+func BenchmarkPacedCallMaxInFlight(b *testing.B) {
+	_ = os.Setenv("WASP_LOG_LEVEL", "warn")
+	gen, err := NewGenerator(&Config{
+		LoadType:          RPS,
+		StatsPollInterval: 1 * time.Second,
+		Schedule:          NoLimitSchedule,
+		MaxInFlight:       1000,
+		Gun:               NewMockGun(&MockGunConfig{}),
+	})
+	require.NoError(b, err)
+	gen.setupSchedule()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		gen.pacedCall()
+	}
+}
+
+// mutexResults stores responses like generators did before sharding: three locks for every response
+type mutexResults struct {
+	okDataMu        *sync.Mutex
+	okData          *ring[any]
+	okResponses     *ring[*Response]
+	failResponsesMu *sync.Mutex
+	failResponses   *ring[*Response]
+	errsMu          *sync.Mutex
+	errs            *ring[string]
+}
+
+func (m *mutexResults) record(res *Response) {
+	m.okDataMu.Lock()
+	m.failResponsesMu.Lock()
+	m.errsMu.Lock()
+	if res.Failed {
+		m.errs.append(res.Error)
+		m.failResponses.append(res)
+	} else {
+		m.okData.append(res.Data)
+		m.okResponses.append(res)
+	}
+	m.okDataMu.Unlock()
+	m.failResponsesMu.Unlock()
+	m.errsMu.Unlock()
+}
+
+func BenchmarkStoreResults(b *testing.B) {
+	res := &Response{Data: "successCallData"}
+	b.Run("mutex", func(b *testing.B) {
+		m := &mutexResults{
+			okDataMu:        &sync.Mutex{},
+			okData:          &ring[any]{capacity: DefaultCallResultBufLen},
+			okResponses:     &ring[*Response]{capacity: DefaultCallResultBufLen},
+			failResponsesMu: &sync.Mutex{},
+			failResponses:   &ring[*Response]{capacity: DefaultCallResultBufLen},
+			errsMu:          &sync.Mutex{},
+			errs:            &ring[string]{capacity: DefaultCallResultBufLen},
+		}
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				m.record(res)
+			}
+		})
+	})
+	b.Run("sharded", func(b *testing.B) {
+		m := newResultAggregator(DefaultCallResultBufLen, shardCount())
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				m.record(res)
+			}
+		})
+	})
+}
+
+// mutexHistogram records latencies like histograms did before sharding: one lock for every value
+type mutexHistogram struct {
+	mu *sync.Mutex
+	h  *hdrhistogram.Histogram
+}
+
+func newMutexHistogram() *mutexHistogram {
+	return &mutexHistogram{
+		mu: &sync.Mutex{},
+		h:  hdrhistogram.New(1, DefaultHistogramMaxLatency.Microseconds(), DefaultHistogramSignificantFigures),
+	}
+}
+
+func (m *mutexHistogram) record(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_ = m.h.RecordValue(min(max(d.Microseconds(), 1), DefaultHistogramMaxLatency.Microseconds()))
+}
+
+// mutexStore is storeResponses before sharding: histograms, the groups map and result buffers are guarded by single locks
+type mutexStore struct {
+	all, corrected, lateness *mutexHistogram
+	groupsMu                 *sync.Mutex
+	groups                   map[string]*mutexHistogram
+	counts                   map[string]*ResultCounts
+	// window is an abort rule window, it was locked for every call
+	windowMu    *sync.Mutex
+	windowCalls int64
+	windowEnd   time.Time
+	window      *mutexHistogram
+	results     *mutexResults
+}
+
+func newMutexStore() *mutexStore {
+	return &mutexStore{
+		all:       newMutexHistogram(),
+		corrected: newMutexHistogram(),
+		lateness:  newMutexHistogram(),
+		groupsMu:  &sync.Mutex{},
+		groups:    make(map[string]*mutexHistogram),
+		counts:    make(map[string]*ResultCounts),
+		windowMu:  &sync.Mutex{},
+		window:    newMutexHistogram(),
+		results: &mutexResults{
+			okDataMu:        &sync.Mutex{},
+			okData:          &ring[any]{capacity: DefaultCallResultBufLen},
+			okResponses:     &ring[*Response]{capacity: DefaultCallResultBufLen},
+			failResponsesMu: &sync.Mutex{},
+			failResponses:   &ring[*Response]{capacity: DefaultCallResultBufLen},
+			errsMu:          &sync.Mutex{},
+			errs:            &ring[string]{capacity: DefaultCallResultBufLen},
+		},
+	}
+}
+
+func (m *mutexStore) store(g *Generator, res *Response) {
+	m.all.record(res.Duration)
+	m.corrected.record(res.Corrected())
+	if res.CorrectedDuration > 0 {
+		m.lateness.record(res.SendLateness())
+	}
+	if res.Group != "" {
+		m.groupsMu.Lock()
+		h, ok := m.groups[res.Group]
+		if !ok {
+			h = newMutexHistogram()
+			m.groups[res.Group] = h
+			m.counts[res.Group] = &ResultCounts{}
+		}
+		c := m.counts[res.Group]
+		m.groupsMu.Unlock()
+		h.record(res.Duration)
+		c.record(res)
+	}
+	m.windowMu.Lock()
+	if time.Now().After(m.windowEnd) {
+		m.windowCalls = 0
+		m.windowEnd = time.Now().Add(time.Second)
+	}
+	m.windowCalls++
+	m.window.record(res.Duration)
+	m.windowMu.Unlock()
+	g.observePrometheus(res)
+	if sr := g.currentSegmentRun.Load(); sr != nil {
+		sr.requests.Add(1)
+		res.Segment = sr.index
+	}
+	if !g.shouldRecord(res) {
+		return
+	}
+	g.pushToSinks(res)
+	m.results.record(res)
+	g.stats.Success.Add(1)
+}
+
+func BenchmarkStoreResponses(b *testing.B) {
+	_ = os.Setenv("WASP_LOG_LEVEL", "warn")
+	newGen := func(b *testing.B) *Generator {
+		gen, err := NewGenerator(&Config{
+			LoadType:          RPS,
+			StatsPollInterval: 1 * time.Second,
+			Schedule:          NoLimitSchedule,
+			AbortRules:        []*AbortRule{AbortOnLatency(99, 1*time.Second, 1*time.Minute)},
+			Gun:               NewMockGun(&MockGunConfig{}),
+		})
+		require.NoError(b, err)
+		return gen
+	}
+	response := func() *Response {
+		return &Response{Data: "successCallData", Group: "call", Duration: time.Millisecond, CorrectedDuration: 2 * time.Millisecond}
+	}
+	b.Run("mutex", func(b *testing.B) {
+		gen := newGen(b)
+		m := newMutexStore()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				m.store(gen, response())
+			}
+		})
+	})
+	b.Run("sharded", func(b *testing.B) {
+		gen := newGen(b)
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				gen.storeResponses(response())
+			}
+		})
+	})
+}
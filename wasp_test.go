@@ -1,7 +1,11 @@
 package wasp
 
 import (
+	"context"
+	"math"
 	"os"
+	"sort"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -494,6 +498,174 @@ func TestSmokeCustomUnitPrecision(t *testing.T) {
 	require.Empty(t, gen.Errors())
 }
 
+func TestSmokeArrivalProcesses(t *testing.T) {
+	t.Parallel()
+	// gapsCV returns coefficient of variation of gaps between finished calls
+	gapsCV := func(responses []*Response) float64 {
+		ts := make([]time.Time, 0)
+		for _, r := range responses {
+			ts = append(ts, *r.FinishedAt)
+		}
+		sort.Slice(ts, func(i, j int) bool { return ts[i].Before(ts[j]) })
+		gaps := make([]float64, 0)
+		for i := 1; i < len(ts); i++ {
+			gaps = append(gaps, float64(ts[i].Sub(ts[i-1])))
+		}
+		var mean, variance float64
+		for _, g := range gaps {
+			mean += g
+		}
+		mean /= float64(len(gaps))
+		for _, g := range gaps {
+			variance += (g - mean) * (g - mean)
+		}
+		variance /= float64(len(gaps))
+		return math.Sqrt(variance) / mean
+	}
+	t.Run("poisson arrivals keep the mean rate", func(t *testing.T) {
+		t.Parallel()
+		gen, err := NewGenerator(&Config{
+			T:        t,
+			LoadType: RPS,
+			Arrival:  ArrivalPoisson,
+			Schedule: Plain(500, 4*time.Second),
+			Gun: NewMockGun(&MockGunConfig{
+				CallSleep: 10 * time.Millisecond,
+			}),
+		})
+		require.NoError(t, err)
+		_, failed := gen.Run(true)
+		require.Equal(t, false, failed)
+		require.GreaterOrEqual(t, gen.Stats().Success.Load(), int64(1800))
+		require.LessOrEqual(t, gen.Stats().Success.Load(), int64(2200))
+		_, okResponses, _ := convertResponsesData(gen)
+		// exponential gaps have CV = 1, even gaps are close to 0
+		require.Greater(t, gapsCV(okResponses), 0.6)
+	})
+	t.Run("custom arrivals respect rate limit unit", func(t *testing.T) {
+		t.Parallel()
+		gen, err := NewGenerator(&Config{
+			T:                     t,
+			LoadType:              RPS,
+			Arrival:               ArrivalCustom,
+			InterArrival:          func(mean time.Duration) time.Duration { return mean },
+			RateLimitUnitDuration: 2 * time.Second,
+			Schedule:              Plain(200, 4*time.Second),
+			Gun: NewMockGun(&MockGunConfig{
+				CallSleep: 10 * time.Millisecond,
+			}),
+		})
+		require.NoError(t, err)
+		_, failed := gen.Run(true)
+		require.Equal(t, false, failed)
+		require.GreaterOrEqual(t, gen.Stats().Success.Load(), int64(390))
+		require.LessOrEqual(t, gen.Stats().Success.Load(), int64(410))
+	})
+}
+
+func TestSmokeCoordinatedOmission(t *testing.T) {
+	t.Parallel()
+	t.Run("late arrivals keep their scheduled time", func(t *testing.T) {
+		t.Parallel()
+		l := newArrivalLimiter(1000, time.Second, ConstantInterArrival)
+		first := l.TakeIntended()
+		// the generator stalls for 50 gaps, slack is 10 gaps
+		time.Sleep(50 * time.Millisecond)
+		late := l.TakeIntended()
+		require.Equal(t, first.Add(time.Millisecond), late)
+		require.GreaterOrEqual(t, time.Since(late), 49*time.Millisecond)
+		// the limiter catches up only slack
+		catchUp := l.TakeIntended()
+		require.Less(t, time.Since(catchUp), 20*time.Millisecond)
+		require.Greater(t, time.Since(catchUp), 5*time.Millisecond)
+	})
+	t.Run("constant arrivals are paced by ratelimit and keep their scheduled time", func(t *testing.T) {
+		t.Parallel()
+		l := newConstantLimiter(1000, time.Second)
+		first := l.TakeIntended()
+		time.Sleep(50 * time.Millisecond)
+		late := l.TakeIntended()
+		require.Equal(t, first.Add(time.Millisecond), late)
+		require.GreaterOrEqual(t, time.Since(late), 49*time.Millisecond)
+		catchUp := l.TakeIntended()
+		require.Less(t, time.Since(catchUp), 20*time.Millisecond)
+		require.Greater(t, time.Since(catchUp), 5*time.Millisecond)
+
+		for _, arrival := range []ArrivalProcess{ArrivalConstant, ArrivalPoisson} {
+			gen, err := NewGenerator(&Config{
+				T:        t,
+				LoadType: RPS,
+				Schedule: Plain(10, 1*time.Second),
+				Arrival:  arrival,
+				Gun:      NewMockGun(&MockGunConfig{}),
+			})
+			require.NoError(t, err)
+			if arrival == ArrivalConstant {
+				require.IsType(t, &constantLimiter{}, gen.newRateLimiter(10))
+				continue
+			}
+			require.IsType(t, &arrivalLimiter{}, gen.newRateLimiter(10))
+		}
+	})
+	t.Run("corrected latency and send lateness are recorded", func(t *testing.T) {
+		t.Parallel()
+		gen, err := NewGenerator(&Config{
+			T:        t,
+			LoadType: RPS,
+			Schedule: Plain(100, 3*time.Second),
+			Gun: NewMockGun(&MockGunConfig{
+				CallSleep: 10 * time.Millisecond,
+			}),
+		})
+		require.NoError(t, err)
+		_, _ = gen.Run(false)
+		time.Sleep(1 * time.Second)
+		// pause is not counted as send lateness
+		gen.Pause()
+		time.Sleep(500 * time.Millisecond)
+		gen.Resume()
+		_, failed := gen.Wait()
+		require.Equal(t, false, failed)
+
+		_, okResponses, _ := convertResponsesData(gen)
+		require.NotEmpty(t, okResponses)
+		for _, r := range okResponses {
+			require.NotNil(t, r.IntendedAt)
+			require.GreaterOrEqual(t, r.CorrectedDuration, r.Duration)
+			require.Equal(t, r.CorrectedDuration, r.FinishedAt.Sub(*r.IntendedAt))
+			require.Less(t, r.SendLateness(), 200*time.Millisecond)
+		}
+		stats := gen.Stats()
+		require.Equal(t, stats.Latencies.All.Count(), stats.Latencies.Corrected.Count())
+		require.Equal(t, stats.Latencies.All.Count(), stats.Latencies.SendLateness.Count())
+		require.GreaterOrEqual(t, stats.Latencies.Corrected.Percentile(50), stats.Latencies.All.Percentile(50))
+		js := gen.StatsJSON()
+		require.Contains(t, js, "latency_corrected")
+		require.Contains(t, js, "send_lateness")
+		r := gen.Report()
+		require.Equal(t, r.Requests, r.CorrectedLatency.Count)
+		require.Equal(t, r.Requests, r.SendLateness.Count)
+	})
+	t.Run("VU calls are not corrected", func(t *testing.T) {
+		t.Parallel()
+		gen, err := NewGenerator(&Config{
+			T:        t,
+			LoadType: VU,
+			Schedule: Plain(1, 1*time.Second),
+			VU: NewMockVU(&MockVirtualUserConfig{
+				CallSleep: 50 * time.Millisecond,
+			}),
+		})
+		require.NoError(t, err)
+		_, failed := gen.Run(true)
+		require.Equal(t, false, failed)
+		stats := gen.Stats()
+		require.Greater(t, stats.Latencies.Corrected.Count(), int64(0))
+		require.Equal(t, int64(0), stats.Latencies.SendLateness.Count())
+		require.Nil(t, gen.Report().CorrectedLatency)
+	})
+}
+
 func TestSmokeStaticRPSScheduleIsNotBlocking(t *testing.T) {
 	gen, err := NewGenerator(&Config{
 		T:        t,
@@ -559,6 +731,234 @@ func TestSmokeLoadScheduleSegmentRPSDecrease(t *testing.T) {
 	require.GreaterOrEqual(t, gen.Stats().Success.Load(), int64(17))
 }
 
+func TestSmokeRampSegments(t *testing.T) {
+	t.Parallel()
+	t.Run("RPS is interpolated during ramp segment", func(t *testing.T) {
+		t.Parallel()
+		gen, err := NewGenerator(&Config{
+			T:        t,
+			LoadType: RPS,
+			Schedule: Ramp(10, 50, 4*time.Second),
+			Gun: NewMockGun(&MockGunConfig{
+				CallSleep: 10 * time.Millisecond,
+			}),
+		})
+		require.NoError(t, err)
+		gen.Run(false)
+		time.Sleep(2 * time.Second)
+		mid := gen.Stats().CurrentRPS.Load()
+		require.Greater(t, mid, int64(10))
+		require.Less(t, mid, int64(50))
+		_, failed := gen.Wait()
+		require.Equal(t, false, failed)
+		require.Equal(t, int64(50), gen.Stats().CurrentRPS.Load())
+		// RPS is changing every second, 10+20+30+40 instead of a flat 10 RPS
+		require.GreaterOrEqual(t, gen.Stats().Success.Load(), int64(95))
+		require.LessOrEqual(t, gen.Stats().Success.Load(), int64(125))
+	})
+	t.Run("VUs are interpolated during ramp segment", func(t *testing.T) {
+		t.Parallel()
+		gen, err := NewGenerator(&Config{
+			T:        t,
+			LoadType: VU,
+			Schedule: Combine(
+				Ramp(1, 5, 4*time.Second),
+				Ramp(5, 2, 3*time.Second),
+			),
+			VU: NewMockVU(&MockVirtualUserConfig{
+				CallSleep: 50 * time.Millisecond,
+			}),
+		})
+		require.NoError(t, err)
+		gen.Run(false)
+		time.Sleep(2 * time.Second)
+		mid := gen.Stats().CurrentVUs.Load()
+		require.Greater(t, mid, int64(1))
+		require.Less(t, mid, int64(5))
+		_, failed := gen.Wait()
+		require.Equal(t, false, failed)
+		require.Equal(t, int64(2), gen.Stats().CurrentVUs.Load())
+	})
+	t.Run("RPS and VUs can ramp down to zero", func(t *testing.T) {
+		t.Parallel()
+		rpsGen, err := NewGenerator(&Config{
+			T:        t,
+			LoadType: RPS,
+			Schedule: Combine(
+				Ramp(20, 0, 2*time.Second),
+				Ramp(1, 0, 1*time.Second),
+			),
+			Gun: NewMockGun(&MockGunConfig{
+				CallSleep: 10 * time.Millisecond,
+			}),
+		})
+		require.NoError(t, err)
+		vuGen, err := NewGenerator(&Config{
+			T:        t,
+			LoadType: VU,
+			Schedule: Ramp(2, 0, 2*time.Second),
+			VU: NewMockVU(&MockVirtualUserConfig{
+				CallSleep: 50 * time.Millisecond,
+			}),
+		})
+		require.NoError(t, err)
+		rpsGen.Run(false)
+		vuGen.Run(false)
+		_, failed := rpsGen.Wait()
+		require.Equal(t, false, failed)
+		require.Equal(t, int64(0), rpsGen.Stats().CurrentRPS.Load())
+		// 20 RPS for a second and 10 RPS for another one, nothing is sent at zero RPS
+		require.GreaterOrEqual(t, rpsGen.Stats().Success.Load(), int64(25))
+		require.LessOrEqual(t, rpsGen.Stats().Success.Load(), int64(40))
+		_, failed = vuGen.Wait()
+		require.Equal(t, false, failed)
+		require.Equal(t, int64(0), vuGen.Stats().CurrentVUs.Load())
+	})
+}
+
+// ctxVU is a context-aware VU that blocks until its call context is cancelled
+type ctxVU struct {
+	*VUControl
+	cancelled *atomic.Int64
+}
+
+func (m *ctxVU) Clone(_ *Generator) VirtualUserCtx {
+	return &ctxVU{VUControl: NewVUControl(), cancelled: m.cancelled}
+}
+
+func (m *ctxVU) Setup(_ *Generator) error {
+	return nil
+}
+
+func (m *ctxVU) Teardown(_ *Generator) error {
+	return nil
+}
+
+func (m *ctxVU) Call(ctx context.Context, _ *Generator) {
+	<-ctx.Done()
+	m.cancelled.Add(1)
+}
+
+func TestSmokeContextCancellation(t *testing.T) {
+	t.Parallel()
+	t.Run("gun call is cancelled on timeout", func(t *testing.T) {
+		t.Parallel()
+		gun := NewMockGunCtx(&MockGunConfig{
+			CallSleep: 10 * time.Second,
+		})
+		gen, err := NewGenerator(&Config{
+			T:           t,
+			LoadType:    RPS,
+			Schedule:    Plain(10, 1*time.Second),
+			CallTimeout: 100 * time.Millisecond,
+			GunCtx:      gun,
+		})
+		require.NoError(t, err)
+		_, failed := gen.Run(true)
+		require.Equal(t, true, failed)
+		stats := gen.Stats()
+		require.GreaterOrEqual(t, stats.CallTimeout.Load(), int64(5))
+		require.Eventually(t, func() bool {
+			return gun.Cancelled.Load() >= stats.CallTimeout.Load()
+		}, 1*time.Second, 10*time.Millisecond)
+	})
+	t.Run("gun call is cancelled on stop", func(t *testing.T) {
+		t.Parallel()
+		gun := NewMockGunCtx(&MockGunConfig{
+			CallSleep: 10 * time.Second,
+		})
+		gen, err := NewGenerator(&Config{
+			T:        t,
+			LoadType: RPS,
+			Schedule: Plain(10, 20*time.Second),
+			GunCtx:   gun,
+		})
+		require.NoError(t, err)
+		gen.Run(false)
+		time.Sleep(1 * time.Second)
+		stopStartedAt := time.Now()
+		gen.Stop()
+		require.Less(t, time.Since(stopStartedAt), 2*time.Second)
+		require.GreaterOrEqual(t, gun.Cancelled.Load(), int64(10))
+		// cancelled calls are discarded
+		require.Equal(t, int64(0), gen.Stats().CallTimeout.Load())
+		require.Equal(t, int64(0), gen.Stats().Success.Load())
+	})
+	t.Run("vu call is cancelled when schedule ends", func(t *testing.T) {
+		t.Parallel()
+		cancelled := &atomic.Int64{}
+		gen, err := NewGenerator(&Config{
+			T:        t,
+			LoadType: VU,
+			Schedule: Plain(2, 1*time.Second),
+			VUCtx:    &ctxVU{VUControl: NewVUControl(), cancelled: cancelled},
+		})
+		require.NoError(t, err)
+		startedAt := time.Now()
+		_, failed := gen.Run(true)
+		require.Equal(t, false, failed)
+		require.Less(t, time.Since(startedAt), 3*time.Second)
+		require.Eventually(t, func() bool {
+			return cancelled.Load() == 2
+		}, 1*time.Second, 10*time.Millisecond)
+	})
+}
+
+// concurrencyGun tracks max amount of concurrent calls
+type concurrencyGun struct {
+	sleep    time.Duration
+	inFlight atomic.Int64
+	max      atomic.Int64
+}
+
+func (m *concurrencyGun) Call(_ *Generator) *Response {
+	cur := m.inFlight.Add(1)
+	defer m.inFlight.Add(-1)
+	for {
+		old := m.max.Load()
+		if cur <= old || m.max.CompareAndSwap(old, cur) {
+			break
+		}
+	}
+	time.Sleep(m.sleep)
+	return &Response{Data: "successCallData"}
+}
+
+func TestSmokeMaxInFlight(t *testing.T) {
+	t.Parallel()
+	gun := &concurrencyGun{sleep: 1 * time.Second}
+	gen, err := NewGenerator(&Config{
+		T:           t,
+		LoadType:    RPS,
+		Schedule:    Plain(100, 2*time.Second),
+		MaxInFlight: 5,
+		Gun:         gun,
+	})
+	require.NoError(t, err)
+	_, failed := gen.Run(true)
+	require.Equal(t, false, failed)
+	stats := gen.Stats()
+	require.LessOrEqual(t, gun.max.Load(), int64(5))
+	require.GreaterOrEqual(t, stats.Success.Load(), int64(5))
+	require.LessOrEqual(t, stats.Success.Load(), int64(15))
+	require.GreaterOrEqual(t, stats.Dropped.Load(), int64(150))
+	require.Equal(t, int64(0), stats.CallTimeout.Load())
+
+	// calls are not dropped while there are free slots
+	gen, err = NewGenerator(&Config{
+		T:           t,
+		LoadType:    RPS,
+		Schedule:    Plain(200, 1*time.Second),
+		MaxInFlight: 10,
+		Gun:         NewMockGun(&MockGunConfig{}),
+	})
+	require.NoError(t, err)
+	_, failed = gen.Run(true)
+	require.Equal(t, false, failed)
+	require.Equal(t, int64(0), gen.Stats().Dropped.Load())
+	require.GreaterOrEqual(t, gen.Stats().Success.Load(), int64(190))
+}
+
 func TestSmokeValidation(t *testing.T) {
 	t.Parallel()
 	t.Run("can't start without StartFrom var", func(t *testing.T) {
@@ -658,6 +1058,57 @@ func TestSmokeValidation(t *testing.T) {
 		})
 		require.Equal(t, ErrInvalidLabels, err)
 	})
+	t.Run("can't start with invalid arrival process", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewGenerator(&Config{
+			T:        t,
+			LoadType: RPS,
+			Arrival:  "arbitrary_arrival",
+			Schedule: Plain(1, 1*time.Second),
+			Gun:      NewMockGun(&MockGunConfig{}),
+		})
+		require.Equal(t, ErrInvalidArrival, err)
+		_, err = NewGenerator(&Config{
+			T:        t,
+			LoadType: RPS,
+			Arrival:  ArrivalCustom,
+			Schedule: Plain(1, 1*time.Second),
+			Gun:      NewMockGun(&MockGunConfig{}),
+		})
+		require.Equal(t, ErrNoInterArrival, err)
+	})
+	t.Run("can't start with negative ramp target", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewGenerator(&Config{
+			T:        t,
+			LoadType: RPS,
+			Schedule: Ramp(1, -1, 1*time.Second),
+			Gun:      NewMockGun(&MockGunConfig{}),
+		})
+		require.Equal(t, ErrInvalidSegmentTo, err)
+	})
+	t.Run("can't start with negative MaxInFlight", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewGenerator(&Config{
+			T:           t,
+			LoadType:    RPS,
+			Schedule:    Plain(1, 1*time.Second),
+			MaxInFlight: -1,
+			Gun:         NewMockGun(&MockGunConfig{}),
+		})
+		require.Equal(t, ErrInvalidMaxInFlight, err)
+	})
+	t.Run("can't start with invalid percentiles", func(t *testing.T) {
+		t.Parallel()
+		_, err := NewGenerator(&Config{
+			T:           t,
+			LoadType:    RPS,
+			Schedule:    Plain(1, 1*time.Second),
+			Percentiles: []float64{50, 101},
+			Gun:         NewMockGun(&MockGunConfig{}),
+		})
+		require.Equal(t, ErrInvalidPercentile, err)
+	})
 }
 
 func TestSmokeVUsIncrease(t *testing.T) {